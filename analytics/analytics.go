@@ -25,20 +25,26 @@ import (
 	"github.com/pkg/errors"
 )
 
-func detectCI() (string, bool) {
-	for k, v := range map[string]string{
-		"GITHUB_WORKFLOW": "github-actions",
-		"CIRCLECI":        "circle-ci",
-		"JENKINS_HOME":    "jenkins",
-		"BUILDKITE":       "buildkite",
-		"DRONE_BRANCH":    "drone",
-		"TRAVIS":          "travis",
-		"GITLAB_CI":       "gitlab",
-		"EARTHLY_IMAGE":   "earthly-image",
-		"AGENT_WORKDIR":   "jenkins", // https://github.com/jenkinsci/docker-agent/blob/master/11/alpine/Dockerfile#L35
+// DetectCI returns the name of the CI provider in which earthly is running, and whether it is
+// running in CI at all.
+func DetectCI() (string, bool) {
+	// The first match wins, as some of these variables are set by more than one provider.
+	for _, ci := range []struct {
+		env  string
+		name string
+	}{
+		{"GITHUB_WORKFLOW", "github-actions"},
+		{"CIRCLECI", "circle-ci"},
+		{"JENKINS_HOME", "jenkins"},
+		{"BUILDKITE", "buildkite"},
+		{"DRONE_BRANCH", "drone"},
+		{"TRAVIS", "travis"},
+		{"GITLAB_CI", "gitlab"},
+		{"EARTHLY_IMAGE", "earthly-image"},
+		{"AGENT_WORKDIR", "jenkins"}, // https://github.com/jenkinsci/docker-agent/blob/master/11/alpine/Dockerfile#L35
 	} {
-		if _, ok := os.LookupEnv(k); ok {
-			return v, true
+		if _, ok := os.LookupEnv(ci.env); ok {
+			return ci.name, true
 		}
 	}
 
@@ -215,7 +221,7 @@ func Count(subsystem, key string) {
 // CollectAnalytics sends analytics to api.earthly.dev
 func CollectAnalytics(ctx context.Context, earthlyServer string, displayErrors bool, version, platform, gitSha, commandName string, exitCode int, realtime time.Duration) {
 	var err error
-	ciName, ci := detectCI()
+	ciName, ci := DetectCI()
 	repoHash := getRepoHash()
 	installID, overrideInstallID := os.LookupEnv("EARTHLY_INSTALL_ID")
 	if !overrideInstallID {
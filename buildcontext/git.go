@@ -12,6 +12,7 @@ import (
 	"github.com/earthly/earthly/analytics"
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/lockfile"
 	"github.com/earthly/earthly/util/gitutil"
 	"github.com/earthly/earthly/util/llbutil"
 	"github.com/earthly/earthly/util/llbutil/llbfactory"
@@ -25,7 +26,8 @@ import (
 )
 
 const (
-	defaultGitImage = "alpine/git:v2.30.1"
+	// DefaultGitImage is the image used for internal git operations, such as reading git metadata.
+	DefaultGitImage = "alpine/git:v2.30.1"
 )
 
 type gitResolver struct {
@@ -34,6 +36,7 @@ type gitResolver struct {
 	projectCache   *synccache.SyncCache // "gitURL#gitRef" -> *resolvedGitProject
 	buildFileCache *synccache.SyncCache // project ref -> local path
 	gitLookup      *GitLookup
+	lock           *lockfile.Lock
 }
 
 type resolvedGitProject struct {
@@ -129,6 +132,14 @@ func (gr *gitResolver) resolveEarthProject(ctx context.Context, gwClient gwclien
 
 func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.Client, ref domain.Reference) (rgp *resolvedGitProject, gitURL string, subDir string, finalErr error) {
 	gitRef := ref.GetTag()
+	pinnedCommit, pinned := gr.lock.GitCommit(ref.GetGitURL(), gitRef)
+	if !gr.lock.GitAvailable(ref.GetGitURL(), gitRef, pinnedCommit) {
+		gr.lock.RecordMissingGit(ref.GetGitURL(), gitRef)
+		return nil, "", "", errors.Errorf("remote reference %s is not available offline", ref.StringCanonical())
+	}
+	if pinned {
+		gitRef = pinnedCommit
+	}
 
 	var err error
 	var keyScan string
@@ -149,9 +160,12 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 		if keyScan != "" {
 			gitOpts = append(gitOpts, llb.KnownSSHHosts(keyScan))
 		}
+		if authSecret := gr.gitLookup.AuthSecret(gitURL); authSecret != "" {
+			gitOpts = append(gitOpts, llb.AuthHeaderSecret(authSecret))
+		}
 		gitState := llb.Git(gitURL, gitRef, gitOpts...)
 		opImg := pllb.Image(
-			defaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+			DefaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
 			llb.Platform(llbutil.DefaultPlatform()))
 
 		// Get git hash.
@@ -253,5 +267,6 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 		return nil, "", "", err
 	}
 	rgp = rgpValue.(*resolvedGitProject)
+	gr.lock.RecordGit(ref.GetGitURL(), ref.GetTag(), rgp.hash)
 	return rgp, gitURL, subDir, nil
 }
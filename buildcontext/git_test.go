@@ -0,0 +1,53 @@
+package buildcontext
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/lockfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveGitProjectOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-git-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// main is pinned in the Earthfile.lock, but was never prefetched, and v1 was prefetched at another commit.
+	lockPath := filepath.Join(dir, lockfile.FileName)
+	pins := lockfile.New()
+	pins.RecordGit("github.com/earthly/hello-world", "main", "0123")
+	pins.RecordGit("github.com/earthly/hello-world", "v1", "4567")
+	err = pins.Write(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefetched := lockfile.New()
+	prefetched.RecordGit("github.com/earthly/hello-world", "v1", "89ab")
+
+	for _, target := range []string{
+		"github.com/earthly/hello-world:main+base",
+		"github.com/earthly/hello-world:v1+base",
+		"github.com/earthly/hello-world:v2+base",
+	} {
+		t.Run(target, func(t *testing.T) {
+			l, err := lockfile.Read(lockPath, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.SetOffline(prefetched)
+			ref, err := domain.ParseTarget(target)
+			assert.NoError(t, err)
+			// The resolver has no git lookup nor gateway client, so getting past the offline check would panic.
+			gr := &gitResolver{lock: l}
+			_, _, _, err = gr.resolveGitProject(context.Background(), nil, ref)
+			assert.Error(t, err)
+			assert.Equal(t, []string{"git github.com/earthly/hello-world:" + ref.GetTag()}, l.Missing())
+		})
+	}
+}
@@ -1,16 +1,23 @@
 package buildcontext
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net"
-	"net/url"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
@@ -19,8 +26,10 @@ import (
 
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/util/fileutil"
+	"github.com/google/uuid"
 
 	"github.com/jdxcode/netrc"
+	"github.com/moby/buildkit/session/secrets"
 	"github.com/moby/buildkit/util/gitutil"
 )
 
@@ -49,7 +58,10 @@ type GitLookup struct {
 	mu            sync.Mutex
 	matchers      []*gitMatcher
 	catchAll      *gitMatcher
-	autoProtocols map[string]gitProtocol // host -> detected protocol type
+	autoProtocols map[string]gitProtocol    // host -> detected protocol type
+	helperCreds   map[string]*gitCredential // host/path -> credentials returned by git credential fill
+	authHeaders   map[string][]byte         // auth secret name -> Authorization header
+	authPrefix    string                    // random prefix of the auth secret names of this session
 	sshAuthSock   string
 	console       conslogging.ConsoleLogger
 }
@@ -93,6 +105,9 @@ func NewGitLookup(console conslogging.ConsoleLogger, sshAuthSock string) *GitLoo
 			protocol: autoProtocol,
 		},
 		autoProtocols: map[string]gitProtocol{},
+		helperCreds:   map[string]*gitCredential{},
+		authHeaders:   map[string][]byte{},
+		authPrefix:    gitAuthSecretPrefix + strings.ReplaceAll(uuid.New().String(), "-", "") + "_",
 		sshAuthSock:   sshAuthSock,
 		console:       console,
 	}
@@ -263,11 +278,130 @@ func (gl *GitLookup) lookupNetRCCredential(host string) (login, password string,
 	return login, password, nil
 }
 
+// gitCredentialTimeout is how long a git credential helper is given to return credentials.
+const gitCredentialTimeout = 30 * time.Second
+
+// gitAuthSecretPrefix is the prefix of the IDs of the session secrets which hold the Authorization header
+// used to access https repositories; see GitLookup.AuthSecret. Earthfiles may not use secrets with this prefix.
+const gitAuthSecretPrefix = "earthly_git_auth_"
+
+// gitAuthProbeTimeout is how long an https git host is given to tell whether a repository requires authentication.
+const gitAuthProbeTimeout = 10 * time.Second
+
+// IsGitAuthSecret returns whether the given secret ID is reserved for the credentials used to access https repositories.
+func IsGitAuthSecret(id string) bool {
+	return strings.HasPrefix(id, gitAuthSecretPrefix)
+}
+
+type gitCredential struct {
+	once     sync.Once
+	user     string
+	password string
+}
+
+// lookupGitCredentialHelper queries the git credential helpers configured on the host (via git credential fill)
+// for credentials for the given https host and path. As with git itself, the helpers are only queried if the
+// repository requires authentication. The helpers are only invoked once per host and path, and the results
+// (including failures) are remembered for the duration of the build. It must be called without holding gl.mu,
+// as the helpers may take a while to respond.
+func (gl *GitLookup) lookupGitCredentialHelper(host, gitPath string) (login, password string) {
+	key := host + "/" + gitPath
+	gl.mu.Lock()
+	cred, ok := gl.helperCreds[key]
+	if !ok {
+		cred = &gitCredential{}
+		gl.helperCreds[key] = cred
+	}
+	gl.mu.Unlock()
+	cred.once.Do(func() {
+		client := &http.Client{Timeout: gitAuthProbeTimeout}
+		if !gitRequiresAuth(client, "https://"+host+"/"+gitPath) {
+			return
+		}
+		cred.user, cred.password, _ = gitCredentialFill(host, gitPath) // best effort
+	})
+	return cred.user, cred.password
+}
+
+// gitRequiresAuth returns whether the https repository at repoURL requires authentication, which is the case
+// when an anonymous request for its refs is rejected with 401 Unauthorized. Any other outcome, including an
+// error, is treated as not requiring authentication, and is left for the clone itself to report.
+func gitRequiresAuth(client *http.Client, repoURL string) bool {
+	resp, err := client.Get(repoURL + "/info/refs?service=git-upload-pack")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusUnauthorized
+}
+
+// gitCredentialFill runs git credential fill for the given https host and path. The returned credentials are
+// only ever held in memory; they are never approved back to the helpers, written to disk, or included in
+// errors or log messages.
+func gitCredentialFill(host, gitPath string) (login, password string, err error) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		return "", "", errors.Wrap(err, "git is not installed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gitCredentialTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, gitBin, "credential", "fill")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\npath=%s\n\n", host, gitPath))
+	// Never fall back to prompting, as the build output would interfere with a prompt on the terminal.
+	// An empty GIT_ASKPASS also stops git from running core.askPass or SSH_ASKPASS.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err = cmd.Run()
+	if err != nil {
+		// The output is not included, as it may contain partial credentials.
+		return "", "", errors.Wrapf(err, "git credential fill failed for %s", host)
+	}
+	login, password = parseGitCredential(stdout.Bytes())
+	if login == "" || password == "" {
+		return "", "", errors.Errorf("git credential fill returned no credentials for %s", host)
+	}
+	return login, password, nil
+}
+
+// parseGitCredential parses the username and password out of the output of git credential fill.
+func parseGitCredential(output []byte) (login, password string) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			// A blank line ends the credential description.
+			break
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "username":
+			login = parts[1]
+		case "password":
+			password = parts[1]
+		}
+	}
+	return login, password
+}
+
+// gitAuth describes the credentials to use for an https repository. When no user and password are configured,
+// the git credential helpers are queried.
+type gitAuth struct {
+	host     string
+	gitPath  string
+	user     string
+	password string
+}
+
 var errMakeCloneURLSubNotSupported = fmt.Errorf("makeCloneURL does not support gitMatcher substitution")
 
-func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string, string, error) {
+// makeCloneURL returns the URL to clone, which never contains credentials, and the credentials to use for it, if any.
+func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string, string, *gitAuth, error) {
 	if m.sub != "" {
-		return "", "", errMakeCloneURLSubNotSupported
+		return "", "", nil, errMakeCloneURLSubNotSupported
 	}
 
 	var err error
@@ -277,7 +411,7 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 	if configuredProtocol == autoProtocol {
 		configuredProtocol, err = gl.detectProtocol(host)
 		if err != nil {
-			return "", "", err
+			return "", "", nil, err
 		}
 		switch configuredProtocol {
 		case sshProtocol:
@@ -289,6 +423,7 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 	}
 
 	var gitURL, keyScan string
+	var auth *gitAuth
 	switch configuredProtocol {
 	case sshProtocol:
 		gitURL = user + "@" + host + ":" + gitPath
@@ -296,7 +431,7 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 		if keyScan == "" {
 			keyScan, err = loadKnownHosts()
 			if err != nil {
-				return "", "", err
+				return "", "", nil, err
 			}
 		}
 	case httpProtocol:
@@ -305,19 +440,73 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 		}
 		gitURL = "http://" + host + "/" + gitPath
 	case httpsProtocol:
-		var userAndPass string
 		if user == "" && password == "" {
 			user, password, _ = gl.lookupNetRCCredential(host) // best effort
 		}
+		auth = &gitAuth{host: host, gitPath: gitPath}
 		if user != "" && password != "" {
-			userAndPass = url.QueryEscape(user) + ":" + url.QueryEscape(password) + "@"
+			auth.user = user
+			auth.password = password
 		}
-		gitURL = "https://" + userAndPass + host + "/" + gitPath
+		gitURL = "https://" + host + "/" + gitPath
 	default:
-		return "", "", errors.Errorf("unsupported protocol: %s", configuredProtocol)
+		return "", "", nil, errors.Errorf("unsupported protocol: %s", configuredProtocol)
 	}
 
-	return gitURL, keyScan, nil
+	return gitURL, keyScan, auth, nil
+}
+
+// addAuth resolves the credentials to use for the given clone URL, and makes them available via AuthSecret.
+// It must be called without holding gl.mu.
+func (gl *GitLookup) addAuth(gitURL string, auth *gitAuth) {
+	if auth == nil {
+		return
+	}
+	user, password := auth.user, auth.password
+	if user == "" && password == "" {
+		user, password = gl.lookupGitCredentialHelper(auth.host, auth.gitPath)
+	}
+	if user == "" || password == "" {
+		return
+	}
+	header := "basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.authHeaders[gl.authSecretName(gitURL)] = []byte(header)
+}
+
+// authSecretName returns the name of the auth secret of the given URL. The names have a random prefix which
+// is only known to this session, such that an Earthfile cannot guess them.
+func (gl *GitLookup) authSecretName(gitURL string) string {
+	sum := sha256.Sum256([]byte(gitURL))
+	return gl.authPrefix + hex.EncodeToString(sum[:8])
+}
+
+// AuthSecret returns the name of the session secret which holds the Authorization header to use when cloning
+// the given URL (as returned by GetCloneURL or ConvertCloneURL), or an empty string if no credentials are known.
+// The name can be passed to llb.AuthHeaderSecret, or be used as the ID of a secret mount. This keeps the
+// credentials out of the clone URL, and therefore out of LLB definitions, cache keys and git metadata.
+func (gl *GitLookup) AuthSecret(gitURL string) string {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	name := gl.authSecretName(gitURL)
+	if _, ok := gl.authHeaders[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// GetSecret returns the Authorization header held by the session secret with the given ID. It implements
+// secrets.SecretStore. The buildkit git source first requests <name>.<host>, and then <name>, so both forms are served.
+func (gl *GitLookup) GetSecret(ctx context.Context, id string) ([]byte, error) {
+	name := strings.SplitN(id, ".", 2)[0]
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	header, ok := gl.authHeaders[name]
+	if !ok {
+		return nil, errors.WithStack(errors.Wrapf(secrets.ErrNotFound, "unable to lookup secret %s", id))
+	}
+	return header, nil
 }
 
 // GetCloneURL returns the repo to clone, and a path relative to the repo
@@ -326,11 +515,20 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 //   "github.com/earthly/earthly/examples/go" ---> ("git@github.com/earthly/earthly.git", "examples/go")
 // Additionally a ssh keyscan might be returned (or an empty string indicating none was configured)
 func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
+	gitURL, subPath, keyScan, auth, err := gl.getCloneURL(path)
+	if err != nil {
+		return "", "", "", err
+	}
+	gl.addAuth(gitURL, auth)
+	return gitURL, subPath, keyScan, nil
+}
+
+func (gl *GitLookup) getCloneURL(path string) (string, string, string, *gitAuth, error) {
 	gl.mu.Lock()
 	defer gl.mu.Unlock()
 	match, m, err := gl.getGitMatcherByPath(path)
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", nil, err
 	}
 
 	n := len(match)
@@ -344,7 +542,7 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
 
 	if m.sub != "" {
 		if !m.re.MatchString(path) {
-			return "", "", "", errors.Errorf("failed to determine git path to clone for %q", path)
+			return "", "", "", nil, errors.Errorf("failed to determine git path to clone for %q", path)
 		}
 		gitURL := m.re.ReplaceAllString(path, m.sub)
 
@@ -352,17 +550,17 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
 		if keyScan == "" {
 			keyScan, err = loadKnownHosts()
 			if err != nil {
-				return "", "", "", err
+				return "", "", "", nil, err
 			}
 		}
-		return gitURL, subPath, keyScan, nil
+		return gitURL, subPath, keyScan, nil, nil
 	}
 
-	gitURL, keyScan, err := gl.makeCloneURL(m, host, gitPath)
+	gitURL, keyScan, auth, err := gl.makeCloneURL(m, host, gitPath)
 	if err != nil {
-		return "", "", "", err
+		return "", "", "", nil, err
 	}
-	return gitURL, subPath, keyScan, nil
+	return gitURL, subPath, keyScan, auth, nil
 }
 
 // ConvertCloneURL takes a url such as https://github.com/user/repo.git or git@github.com:user/repo.git
@@ -370,6 +568,15 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
 // https or ssh protocol.
 // it also returns a keyScan
 func (gl *GitLookup) ConvertCloneURL(inURL string) (string, string, error) {
+	gitURL, keyScan, auth, err := gl.convertCloneURL(inURL)
+	if err != nil {
+		return "", "", err
+	}
+	gl.addAuth(gitURL, auth)
+	return gitURL, keyScan, nil
+}
+
+func (gl *GitLookup) convertCloneURL(inURL string) (string, string, *gitAuth, error) {
 	var host string
 
 	var splitChar string
@@ -380,12 +587,14 @@ func (gl *GitLookup) ConvertCloneURL(inURL string) (string, string, error) {
 	case gitutil.SSHProtocol:
 		splitChar = ":"
 	default:
-		return "", "", errors.Errorf("unsupported git protocol %v", protocol)
+		return "", "", nil, errors.Errorf("unsupported git protocol %v", protocol)
 	}
 	splits := strings.SplitN(remote, splitChar, 2)
 	host = splits[0]
 	gitPath := splits[1]
 
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
 	m := gl.getGitMatcherByName(host)
 	return gl.makeCloneURL(m, host, gitPath)
 }
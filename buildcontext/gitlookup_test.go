@@ -0,0 +1,92 @@
+package buildcontext
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/moby/buildkit/session/secrets"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGitCredential(t *testing.T) {
+	var tests = []struct {
+		name     string
+		output   string
+		login    string
+		password string
+	}{
+		{"full", "protocol=https\nhost=github.com\nusername=bot\npassword=s3cr=t\n", "bot", "s3cr=t"},
+		{"crlf", "username=bot\r\npassword=secret\r\n", "bot", "secret"},
+		{"no password", "protocol=https\nhost=github.com\nusername=bot\n", "bot", ""},
+		{"empty", "", "", ""},
+		{"malformed lines", "garbage\nusername=bot\n=\npassword=secret\n", "bot", "secret"},
+		{"stops at blank line", "username=bot\n\npassword=secret\n", "bot", ""},
+		{"later values win", "username=a\nusername=b\npassword=secret\n", "b", "secret"},
+	}
+	for _, tt := range tests {
+		login, password := parseGitCredential([]byte(tt.output))
+		assert.Equal(t, tt.login, login, tt.name)
+		assert.Equal(t, tt.password, password, tt.name)
+	}
+}
+
+func TestGitLookupAuthSecret(t *testing.T) {
+	gl := NewGitLookup(conslogging.Current(conslogging.NoColor, conslogging.NoPadding, false), "")
+	err := gl.AddMatcher("example.com", "example.com/[^/]+/[^/]+", "", "bot", "p@ss", ".git", "https", "")
+	assert.NoError(t, err)
+	err = gl.AddMatcher("other.com", "other.com/[^/]+/[^/]+", "", "", "", ".git", "https", "")
+	assert.NoError(t, err)
+	// Pretend the credential helpers have already been queried, and returned nothing.
+	cred := &gitCredential{}
+	cred.once.Do(func() {})
+	gl.helperCreds["other.com/org/repo.git"] = cred
+
+	gitURL, subPath, _, err := gl.GetCloneURL("example.com/org/repo/sub/dir")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/org/repo.git", gitURL)
+	assert.Equal(t, "sub/dir", subPath)
+
+	secret := gl.AuthSecret(gitURL)
+	assert.True(t, IsGitAuthSecret(secret))
+	// The names are random per session, such that they cannot be guessed by an Earthfile.
+	assert.NotEqual(t, secret, NewGitLookup(gl.console, "").authSecretName(gitURL))
+	header := "basic " + base64.StdEncoding.EncodeToString([]byte("bot:p@ss"))
+	for _, id := range []string{secret, secret + ".example.com"} {
+		dt, err := gl.GetSecret(context.Background(), id)
+		assert.NoError(t, err)
+		assert.Equal(t, header, string(dt))
+	}
+
+	gitURL, _, _, err = gl.GetCloneURL("other.com/org/repo")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://other.com/org/repo.git", gitURL)
+	assert.Equal(t, "", gl.AuthSecret(gitURL))
+
+	_, err = gl.GetSecret(context.Background(), "SECRET")
+	assert.True(t, errors.Is(err, secrets.ErrNotFound))
+}
+
+func TestGitRequiresAuth(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "git-upload-pack", r.URL.Query().Get("service"))
+		switch r.URL.Path {
+		case "/org/private.git/info/refs":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/org/public.git/info/refs":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	client := server.Client()
+	assert.True(t, gitRequiresAuth(client, server.URL+"/org/private.git"))
+	assert.False(t, gitRequiresAuth(client, server.URL+"/org/public.git"))
+	assert.False(t, gitRequiresAuth(client, server.URL+"/org/missing.git"))
+	server.Close()
+	assert.False(t, gitRequiresAuth(client, server.URL+"/org/private.git"))
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/lockfile"
 	"github.com/earthly/earthly/util/gitutil"
 	"github.com/earthly/earthly/util/llbutil/llbfactory"
 	"github.com/earthly/earthly/util/syncutil/synccache"
@@ -48,13 +49,16 @@ type Resolver struct {
 }
 
 // NewResolver returns a new NewResolver.
-func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLookup *GitLookup, console conslogging.ConsoleLogger) *Resolver {
+// Remote Earthfiles are checked out at the commits pinned by the lock (which may be nil),
+// and the commits resolved are recorded in it.
+func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLookup *GitLookup, console conslogging.ConsoleLogger, lock *lockfile.Lock) *Resolver {
 	return &Resolver{
 		gr: &gitResolver{
 			cleanCollection: cleanCollection,
 			projectCache:    synccache.New(),
 			buildFileCache:  synccache.New(),
 			gitLookup:       gitLookup,
+			lock:            lock,
 		},
 		lr: &localResolver{
 			gitMetaCache: synccache.New(),
@@ -18,6 +18,7 @@ import (
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/lockfile"
 	"github.com/earthly/earthly/states"
 	"github.com/earthly/earthly/util/containerutil"
 	"github.com/earthly/earthly/util/gwclientlogger"
@@ -75,6 +76,22 @@ type Opt struct {
 	LocalRegistryAddr      string
 	FeatureFlagOverrides   string
 	ContainerFrontend      containerutil.ContainerFrontend
+	Lock                   *lockfile.Lock
+	Breakpoints            *earthfile2llb.Breakpoints
+	// SaveFailedImage is the name of the image in which the state of a failed RUN command is saved,
+	// if not empty.
+	SaveFailedImage string
+	// LogsDir is the directory to which the complete output of each target and each of its commands
+	// is written, if not empty.
+	LogsDir string
+	// Dashboard shows the progress of the build at the bottom of the console, in place of the output
+	// of each command. It requires a terminal.
+	Dashboard bool
+	// GroupedOutput buffers the output of each target, to print it as one block once the target
+	// completes, rather than interleaving the output of targets which run in parallel.
+	GroupedOutput bool
+	// CacheMounts records the cache mount which created each buildkit cache mount record, if not nil.
+	CacheMounts *earthfile2llb.CacheMountsIndex
 }
 
 // BuildOpt is a collection of build options.
@@ -88,6 +105,14 @@ type BuildOpt struct {
 	OnlyArtifact               *domain.Artifact
 	OnlyArtifactDestPath       string
 	EnableGatewayClientLogging bool
+	// OnlyConvert stops the build once the target graph has been converted, without building it.
+	OnlyConvert bool
+	// Prefetch pulls all the images resolved during conversion into the cache, instead of building
+	// the target. It requires Opt.Lock to be set.
+	Prefetch bool
+	// InteractiveShell opens an interactive shell in the final state of the target, once it has been
+	// built. Any changes made within the shell are discarded.
+	InteractiveShell bool
 }
 
 // Builder executes Earthly builds.
@@ -96,6 +121,9 @@ type Builder struct {
 	opt       Opt
 	resolver  *buildcontext.Resolver
 	builtMain bool
+	runStates *earthfile2llb.RunStates
+	// composeLogs are output once the build is done, even if it fails.
+	composeLogs *earthfile2llb.ComposeLogsExports
 
 	outDirOnce sync.Once
 	outDir     string
@@ -103,6 +131,10 @@ type Builder struct {
 
 // NewBuilder returns a new earthly Builder.
 func NewBuilder(ctx context.Context, opt Opt) (*Builder, error) {
+	if opt.Lock.Offline() {
+		// Offline builds may only use images which are already in the buildkit cache.
+		opt.ImageResolveMode = llb.ResolveModePreferLocal
+	}
 	b := &Builder{
 		s: &solver{
 			sm:              newSolverMonitor(opt.Console, opt.Verbose, opt.DisableNoOutputUpdates),
@@ -114,22 +146,109 @@ func NewBuilder(ctx context.Context, opt Opt) (*Builder, error) {
 			enttlmnts:       opt.Enttlmnts,
 			saveInlineCache: opt.SaveInlineCache,
 		},
-		opt:      opt,
-		resolver: nil, // initialized below
+		opt:         opt,
+		resolver:    nil, // initialized below
+		composeLogs: earthfile2llb.NewComposeLogsExports(),
+	}
+	b.resolver = buildcontext.NewResolver(opt.SessionID, opt.CleanCollection, opt.GitLookup, opt.Console, opt.Lock)
+	if opt.SaveFailedImage != "" {
+		b.runStates = earthfile2llb.NewRunStates()
 	}
-	b.resolver = buildcontext.NewResolver(opt.SessionID, opt.CleanCollection, opt.GitLookup, opt.Console)
+	if opt.LogsDir != "" {
+		logs, err := newLogsDir(opt.LogsDir)
+		if err != nil {
+			return nil, err
+		}
+		b.s.sm.logs = logs
+	}
+	if opt.Dashboard && ansiSupported {
+		b.s.sm.dashboard = newDashboard()
+	}
+	b.s.sm.groupedOutput = opt.GroupedOutput
 	return b, nil
 }
 
 // BuildTarget executes the build of a given Earthly target.
 func (b *Builder) BuildTarget(ctx context.Context, target domain.Target, opt BuildOpt) (*states.MultiTarget, error) {
 	mts, err := b.convertAndBuild(ctx, target, opt)
+	b.outputComposeLogs(ctx)
+	if b.s.sm.logs != nil && !b.s.sm.logs.disabled() {
+		status := logStatusSuccess
+		if errors.Is(err, context.Canceled) {
+			status = logStatusCanceled
+		} else if err != nil {
+			status = logStatusFailure
+		}
+		logsErr := b.s.sm.logs.writeIndex(status)
+		if logsErr != nil {
+			b.opt.Console.Warnf("Warning: could not write the logs to %s: %s\n", b.opt.LogsDir, logsErr.Error())
+		} else {
+			b.opt.Console.Printf("The output of each target has been written to %s\n", b.opt.LogsDir)
+		}
+	}
 	if err != nil {
+		if b.opt.SaveFailedImage != "" {
+			saveErr := b.saveFailedImage(ctx)
+			if saveErr != nil {
+				b.opt.Console.Warnf("Warning: could not save the failed image %s: %s\n", b.opt.SaveFailedImage, saveErr.Error())
+			}
+		}
 		return nil, err
 	}
 	return mts, nil
 }
 
+// saveFailedImage loads the state in which the failed RUN command was executed into the container
+// frontend, and prints how to run the command again.
+func (b *Builder) saveFailedImage(ctx context.Context) error {
+	vertexName := b.s.sm.failedVertexName()
+	if vertexName == "" {
+		return errors.New("no failed command found")
+	}
+	run, ok := b.runStates.Get(vertexName)
+	if !ok {
+		return errors.Errorf("the failed command %s is not a RUN command", vertexName)
+	}
+	outDir, err := b.tempEarthlyOutDir()
+	if err != nil {
+		return err
+	}
+	outFile := filepath.Join(outDir, "failed-image.tar")
+	err = b.s.solveDockerTar(ctx, run.State, run.Platform, run.Image, b.opt.SaveFailedImage, outFile)
+	if err != nil {
+		return errors.Wrap(err, "solve failed image")
+	}
+	f, err := os.Open(outFile)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", outFile)
+	}
+	defer f.Close()
+	err = loadDockerTar(ctx, b.opt.ContainerFrontend, f, b.opt.Console)
+	if err != nil {
+		return err
+	}
+	b.opt.Console.Printf(
+		"The state of the failed command has been saved as %s. To run the command again, use:\n\t%s\n",
+		b.opt.SaveFailedImage, run.DockerRunCmd(b.opt.ContainerFrontend.Config().Binary, b.opt.SaveFailedImage))
+	return nil
+}
+
+// outputComposeLogs outputs the logs written by WITH DOCKER --compose-logs. This is done regardless of
+// whether the build succeeded, as the logs are most useful when the command failed.
+func (b *Builder) outputComposeLogs(ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+	for _, export := range b.composeLogs.All() {
+		err := b.s.solveLocal(ctx, export.State, llbutil.DefaultPlatform(), export.DestPath)
+		if err != nil {
+			b.opt.Console.Warnf("Warning: could not output the compose logs to %s: %s\n", export.DestPath, err.Error())
+			continue
+		}
+		b.opt.Console.Printf("The compose logs have been written to %s\n", export.DestPath)
+	}
+}
+
 // MakeImageAsTarBuilderFun returns a function which can be used to build an image as a tar.
 func (b *Builder) MakeImageAsTarBuilderFun() states.DockerBuilderFun {
 	return func(ctx context.Context, mts *states.MultiTarget, dockerTag string, outFile string) error {
@@ -149,6 +268,10 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 	imageIndex := 0
 	dirIndex := 0
 	localImages := make(map[string]string) // local reg pull name -> final name
+	var gitCloneSources *earthfile2llb.GitCloneSources
+	if opt.Prefetch {
+		gitCloneSources = earthfile2llb.NewGitCloneSources()
+	}
 	bf := func(childCtx context.Context, gwClient gwclient.Client) (*gwclient.Result, error) {
 		if opt.EnableGatewayClientLogging {
 			gwClient = gwclientlogger.New(gwClient)
@@ -176,11 +299,32 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 				GitLookup:            b.opt.GitLookup,
 				FeatureFlagOverrides: featureFlagOverrides,
 				LocalStateCache:      sharedLocalStateCache,
+				Lock:                 b.opt.Lock,
+				Breakpoints:          b.opt.Breakpoints,
+				RunStates:            b.runStates,
+				ComposeLogs:          b.composeLogs,
+				CacheMounts:          b.opt.CacheMounts,
+				GitCloneSources:      gitCloneSources,
+				TargetConverted:      b.s.sm.targetConverted,
+				InteractiveShell:     opt.InteractiveShell,
 			}, true)
 			if err != nil {
 				return nil, err
 			}
 		}
+		if opt.Prefetch {
+			err := b.prefetchImages(childCtx, gwClient)
+			if err != nil {
+				return nil, err
+			}
+			err = b.prefetchGitClones(childCtx, gwClient, gitCloneSources)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if opt.OnlyConvert || opt.Prefetch {
+			return gwclient.NewResult(), nil
+		}
 		res := gwclient.NewResult()
 		if !b.builtMain {
 			ref, err := b.stateToRef(childCtx, gwClient, mts.Final.MainState, mts.Final.Platform)
@@ -416,6 +560,9 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 	if opt.PrintPhases {
 		b.opt.Console.PrintPhaseFooter(PhaseBuild, false, "")
 	}
+	if opt.OnlyConvert || opt.Prefetch {
+		return mts, nil
+	}
 	b.builtMain = true
 
 	if opt.PrintPhases {
@@ -612,6 +759,60 @@ func (b *Builder) stateToRef(ctx context.Context, gwClient gwclient.Client, stat
 	return llbutil.StateToRef(ctx, gwClient, state, platform, b.opt.CacheImports.AsMap())
 }
 
+// prefetchImages pulls the layers of every image recorded in the lock into the buildkit cache.
+func (b *Builder) prefetchImages(ctx context.Context, gwClient gwclient.Client) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, img := range b.opt.Lock.PrefetchImages() {
+		// Images referenced by digest are recorded with the digest already in the reference.
+		imgRef := img.Ref
+		if !strings.Contains(imgRef, "@") {
+			imgRef = fmt.Sprintf("%s@%s", img.Ref, img.Digest)
+		}
+		img, imgRef := img, imgRef
+		eg.Go(func() error {
+			platform, err := llbutil.ParsePlatform(img.Platform)
+			if err != nil {
+				return errors.Wrapf(err, "parse platform %s", img.Platform)
+			}
+			state := pllb.Image(
+				imgRef,
+				llb.Platform(llbutil.PlatformWithDefault(platform)),
+				llb.WithCustomNamef("[prefetch] %s", img.Ref))
+			ref, err := llbutil.StateToRef(ctx, gwClient, state, platform, nil)
+			if err != nil {
+				return errors.Wrapf(err, "prefetch %s", img.Ref)
+			}
+			// Layers are pulled lazily; reading the root dir forces them to be fetched.
+			_, err = ref.ReadDir(ctx, gwclient.ReadDirRequest{Path: "/"})
+			if err != nil {
+				return errors.Wrapf(err, "prefetch %s", img.Ref)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// prefetchGitClones fetches the sources of every GIT CLONE command into the buildkit cache.
+func (b *Builder) prefetchGitClones(ctx context.Context, gwClient gwclient.Client, sources *earthfile2llb.GitCloneSources) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, source := range sources.All() {
+		source := source
+		eg.Go(func() error {
+			ref, err := llbutil.StateToRef(ctx, gwClient, source.State, nil, b.opt.CacheImports.AsMap())
+			if err != nil {
+				return errors.Wrapf(err, "prefetch %s", source.Repo)
+			}
+			_, err = ref.ReadDir(ctx, gwclient.ReadDirRequest{Path: "/"})
+			if err != nil {
+				return errors.Wrapf(err, "prefetch %s", source.Repo)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
 func (b *Builder) artifactStateToRef(ctx context.Context, gwClient gwclient.Client, state pllb.State, platform *specs.Platform) (gwclient.Reference, error) {
 	if b.opt.NoCache || b.builtMain {
 		state = state.SetMarshalDefaults(llb.IgnoreCache)
@@ -3,9 +3,11 @@ package builder
 import (
 	"testing"
 
+	"github.com/moby/buildkit/client/llb"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/lockfile"
 )
 
 // TestTempEarthlyOutDir tests that tempEarthlyOutDir always returns the same directory
@@ -24,3 +26,15 @@ func TestTempEarthlyOutDir(t *testing.T) {
 
 	assert.Equal(t, outDir1, outDir2)
 }
+
+func TestNewBuilderOffline(t *testing.T) {
+	lock := lockfile.New()
+	lock.SetOffline(lockfile.New())
+	b, err := NewBuilder(nil, Opt{
+		CleanCollection:  cleanup.NewCollection(),
+		ImageResolveMode: llb.ResolveModeForcePull,
+		Lock:             lock,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, llb.ResolveModePreferLocal, b.opt.ImageResolveMode)
+}
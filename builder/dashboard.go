@@ -0,0 +1,200 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+const (
+	durationBetweenDashboardUpdates = 250 * time.Millisecond
+	dashboardOutputLines            = 3
+	defaultDashboardWidth           = 80
+	defaultDashboardHeight          = 24
+)
+
+// dashboard shows the progress of the build at the bottom of the console, in place of the output
+// of each command.
+type dashboard struct {
+	ticker  *time.Ticker
+	active  bool
+	started time.Time
+}
+
+func newDashboard() *dashboard {
+	return &dashboard{
+		ticker:  time.NewTicker(durationBetweenDashboardUpdates),
+		active:  true,
+		started: time.Now(),
+	}
+}
+
+// dashboardActive returns whether the dashboard is shown in place of the output of each command.
+func (sm *solverMonitor) dashboardActive() bool {
+	return sm.dashboard != nil && sm.dashboard.active
+}
+
+// updateDashboard redraws the dashboard. Assumes msgMu locked.
+func (sm *solverMonitor) updateDashboard() {
+	if !sm.dashboardActive() {
+		return
+	}
+	width, height, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width = defaultDashboardWidth
+		height = defaultDashboardHeight
+	}
+	// Leave a line for the cursor, so that the terminal does not scroll.
+	sm.console.SetFooter(sm.renderDashboard(time.Now(), width, height-1))
+}
+
+// stopDashboard removes the dashboard, and falls back to printing the output of each command. The
+// output which commands have printed so far is not repeated. Assumes msgMu locked.
+func (sm *solverMonitor) stopDashboard() {
+	if !sm.dashboardActive() {
+		return
+	}
+	sm.dashboard.active = false
+	sm.dashboard.ticker.Stop()
+	sm.console.SetFooter(nil)
+}
+
+type dashboardTarget struct {
+	name     string
+	started  time.Time
+	vertices []*vertexMonitor
+}
+
+// renderDashboard returns the lines of the dashboard, which fit within the given width and height.
+func (sm *solverMonitor) renderDashboard(now time.Time, width, height int) []string {
+	var running, completed, cached, pending, failed int
+	targets := make(map[string]*dashboardTarget)
+	for _, vm := range sm.vertices {
+		if vm.isInternal {
+			continue
+		}
+		switch {
+		case vm.vertex.Error != "":
+			failed++
+		case vm.vertex.Cached:
+			cached++
+		case vm.vertex.Completed != nil:
+			completed++
+		case vm.vertex.Started != nil:
+			running++
+			key := fmt.Sprintf("%s %s", vm.targetStr, vm.salt)
+			t, ok := targets[key]
+			if !ok {
+				name := vm.targetStr
+				if vm.targetBrackets != "" {
+					name = fmt.Sprintf("%s (%s)", name, vm.targetBrackets)
+				}
+				t = &dashboardTarget{name: name, started: *vm.vertex.Started}
+				targets[key] = t
+			}
+			if vm.vertex.Started.Before(t.started) {
+				t.started = *vm.vertex.Started
+			}
+			t.vertices = append(t.vertices, vm)
+		default:
+			pending++
+		}
+	}
+
+	summary := fmt.Sprintf("Building (%s) │ %d running │ %d complete │ %d cached │ %d pending",
+		formatElapsed(now.Sub(sm.dashboard.started)), running, completed, cached, pending)
+	if failed > 0 {
+		summary = fmt.Sprintf("%s │ %d failed", summary, failed)
+	}
+	lines := []string{strings.Repeat("─", width), summary}
+
+	sorted := make([]*dashboardTarget, 0, len(targets))
+	for _, t := range targets {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].started.Equal(sorted[j].started) {
+			return sorted[i].started.Before(sorted[j].started)
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	for _, t := range sorted {
+		lines = append(lines, fmt.Sprintf("%s %s", t.name, formatElapsed(now.Sub(t.started))))
+		sort.Slice(t.vertices, func(i, j int) bool {
+			return t.vertices[i].vertex.Started.Before(*t.vertices[j].vertex.Started)
+		})
+		for _, vm := range t.vertices {
+			lines = append(lines, fmt.Sprintf("  --> %s %s",
+				firstLine(vm.operation), formatElapsed(now.Sub(*vm.vertex.Started))))
+			for _, line := range lastOutputLines(vm, dashboardOutputLines) {
+				lines = append(lines, fmt.Sprintf("      │ %s", line))
+			}
+		}
+	}
+
+	if height > 0 && len(lines) > height {
+		hidden := len(lines) - height + 1
+		lines = append(lines[:height-1], fmt.Sprintf("... %d more lines", hidden))
+	}
+	for i, line := range lines {
+		lines[i] = truncateRunes(line, width)
+	}
+	return lines
+}
+
+var ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// lastOutputLines returns the last n non-empty lines which the vertex has output.
+func lastOutputLines(vm *vertexMonitor, n int) []string {
+	if vm.tailOutput == nil {
+		return nil
+	}
+	var lines []string
+	rawLines := bytes.Split(vm.tailOutput.Bytes(), []byte{'\n'})
+	for i := len(rawLines) - 1; i >= 0 && len(lines) < n; i-- {
+		line := rawLines[i]
+		// Only the text after the last \r is visible on a terminal.
+		if j := bytes.LastIndexByte(line, '\r'); j != -1 {
+			line = line[j+1:]
+		}
+		line = ansiEscapeRegexp.ReplaceAll(line, nil)
+		str := strings.TrimRight(strings.ReplaceAll(string(line), "\t", "    "), " ")
+		if str == "" {
+			continue
+		}
+		lines = append([]string{str}, lines...)
+	}
+	return lines
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		return s[:i] + " ..."
+	}
+	return s
+}
+
+func truncateRunes(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	if width == 1 {
+		return string(runes[:1])
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func formatElapsed(d time.Duration) string {
+	if d < time.Second {
+		return "0s"
+	}
+	return d.Truncate(time.Second).String()
+}
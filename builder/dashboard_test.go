@@ -0,0 +1,107 @@
+package builder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderDashboard(t *testing.T) {
+	console := conslogging.Current(conslogging.NoColor, conslogging.NoPadding, false).WithWriter(ioutil.Discard)
+	sm := newSolverMonitor(console, false, true)
+	start := time.Date(2021, 8, 1, 10, 0, 0, 0, time.UTC)
+	sm.dashboard = &dashboard{active: true, started: start}
+	at := func(sec int) *time.Time {
+		ts := start.Add(time.Duration(sec) * time.Second)
+		return &ts
+	}
+
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{
+			{Digest: digest.Digest("a"), Name: "[+build salt1] RUN go build", Started: at(5)},
+			{Digest: digest.Digest("b"), Name: "[+test(GOOS=bGludXg=) salt2] RUN go test", Started: at(2)},
+			{Digest: digest.Digest("c"), Name: "[+build salt1] COPY go.mod .", Cached: true, Started: at(1), Completed: at(1)},
+			{Digest: digest.Digest("d"), Name: "[+build salt1] RUN go mod download", Started: at(1), Completed: at(4)},
+			{Digest: digest.Digest("e"), Name: "[+lint salt3] RUN golint", Started: nil},
+			{Digest: digest.Digest("f"), Name: "docker-image://docker.io/library/golang:1.16", Started: at(0)},
+		},
+		Logs: []*client.VertexLog{
+			{Vertex: digest.Digest("b"), Data: []byte("=== RUN TestA\n--- PASS: TestA\n\n\x1b[32mok\x1b[0m  pkg\t0.1s\n")},
+			{Vertex: digest.Digest("a"), Data: []byte("10%\r50%\r90%")},
+		},
+	}))
+
+	assert.Equal(t, []string{
+		strings.Repeat("─", 80),
+		"Building (10s) │ 2 running │ 1 complete │ 1 cached │ 1 pending",
+		"+test (GOOS=linux) 8s",
+		"  --> RUN go test 8s",
+		"      │ === RUN TestA",
+		"      │ --- PASS: TestA",
+		"      │ ok  pkg    0.1s",
+		"+build 5s",
+		"  --> RUN go build 5s",
+		"      │ 90%",
+	}, sm.renderDashboard(*at(10), 80, 0))
+
+	assert.Equal(t, []string{
+		strings.Repeat("─", 30),
+		"Building (10s) │ 2 running │ …",
+		"+test (GOOS=linux) 8s",
+		"  --> RUN go test 8s",
+		"      │ === RUN TestA",
+		"... 5 more lines",
+	}, sm.renderDashboard(*at(10), 30, 6))
+}
+
+func TestDashboardFallback(t *testing.T) {
+	console := conslogging.Current(conslogging.NoColor, conslogging.NoPadding, false).WithWriter(ioutil.Discard)
+	sm := newSolverMonitor(console, false, true)
+	sm.dashboard = &dashboard{active: true, ticker: time.NewTicker(time.Hour)}
+	started := time.Now()
+
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{{Digest: digest.Digest("a"), Name: "[+build salt1] RUN make", Started: &started}},
+		Logs:     []*client.VertexLog{{Vertex: digest.Digest("a"), Data: []byte("output\n")}},
+	}))
+	assert.True(t, sm.dashboardActive())
+	assert.False(t, sm.vertices[digest.Digest("a")].headerPrinted)
+
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{{Digest: digest.Digest("a"), Name: "[+build salt1] RUN make", Started: &started,
+			Error: "process \"/bin/sh -c make\" did not complete successfully: exit code: 2"}},
+	}))
+	assert.False(t, sm.dashboardActive())
+	assert.Equal(t, "output\n", string(sm.vertices[digest.Digest("a")].tailOutput.Bytes()))
+}
+
+func TestDashboardNoProgress(t *testing.T) {
+	var out bytes.Buffer
+	console := conslogging.Current(conslogging.NoColor, conslogging.NoPadding, false).WithWriter(&out)
+	sm := newSolverMonitor(console, false, true)
+	started := time.Now()
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{{Digest: digest.Digest("a"), Name: "[+build salt1] RUN make", Started: &started}},
+	}))
+	sm.vertices[digest.Digest("a")].headerPrinted = true
+	out.Reset()
+
+	sm.dashboard = &dashboard{active: true, ticker: time.NewTicker(time.Hour)}
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Statuses: []*client.VertexStatus{{ID: "downloading", Vertex: digest.Digest("a"), Current: 50, Total: 100}},
+	}))
+	assert.Empty(t, out.String())
+}
+
+func TestTruncateRunes(t *testing.T) {
+	assert.Equal(t, "│ abc", truncateRunes("│ abc", 5))
+	assert.Equal(t, "│ a…", truncateRunes("│ abcd", 4))
+	assert.Equal(t, "abc", truncateRunes("abc", 0))
+}
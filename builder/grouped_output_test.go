@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupedOutput(t *testing.T) {
+	var buf bytes.Buffer
+	console := conslogging.Current(conslogging.NoColor, conslogging.NoPadding, false).WithWriter(&buf)
+	sm := newSolverMonitor(console, false, true)
+	sm.groupedOutput = true
+
+	started := time.Now()
+	completed := started.Add(time.Second)
+	a1 := &client.Vertex{Digest: digest.Digest("a1"), Name: "[+a salta] RUN one", Started: &started}
+	a2 := &client.Vertex{Digest: digest.Digest("a2"), Name: "[+a salta] RUN two", Started: &started}
+	b1 := &client.Vertex{Digest: digest.Digest("b1"), Name: "[+b saltb] RUN three", Started: &started}
+	done := func(v *client.Vertex, errStr string) *client.Vertex {
+		return &client.Vertex{Digest: v.Digest, Name: v.Name, Started: &started, Completed: &completed, Error: errStr}
+	}
+
+	for _, ss := range []*client.SolveStatus{
+		{
+			Vertexes: []*client.Vertex{a1, a2, b1},
+			Logs: []*client.VertexLog{
+				{Vertex: a1.Digest, Data: []byte("a1 out\n")},
+				{Vertex: b1.Digest, Data: []byte("b1 out\n")},
+				{Vertex: a2.Digest, Data: []byte("a2 out\n")},
+			},
+		},
+		{Vertexes: []*client.Vertex{done(a1, "")}},
+	} {
+		assert.NoError(t, sm.processStatus(ss))
+	}
+	// +a still has a running command.
+	assert.Equal(t, "", buf.String())
+
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{done(b1, "process \"/bin/sh -c three\" did not complete successfully: exit code: 1")},
+	}))
+	assert.Equal(t, ""+
+		"+b | --> RUN three\n"+
+		"+b | b1 out\n"+
+		"+b | ERROR: Command exited with non-zero code: RUN three\n",
+		buf.String())
+	buf.Reset()
+
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{done(a2, "")},
+		Logs:     []*client.VertexLog{{Vertex: a2.Digest, Data: []byte("a2 more\n")}},
+	}))
+	// +a may still have commands to add until the converter reports it as converted.
+	assert.Equal(t, "", buf.String())
+
+	sm.targetConverted("salta")
+	assert.Equal(t, ""+
+		"+a | --> RUN one\n"+
+		"+a | --> RUN two\n"+
+		"+a | a1 out\n"+
+		"+a | a2 out\n"+
+		"+a | a2 more\n",
+		buf.String())
+	buf.Reset()
+
+	// A target which has been converted is flushed once its last command completes.
+	c1 := &client.Vertex{Digest: digest.Digest("c1"), Name: "[+c saltc] RUN four", Started: &started}
+	sm.targetConverted("saltc")
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{c1},
+		Logs:     []*client.VertexLog{{Vertex: c1.Digest, Data: []byte("c1 out\n")}},
+	}))
+	assert.Equal(t, "", buf.String())
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{Vertexes: []*client.Vertex{done(c1, "")}}))
+	assert.Equal(t, ""+
+		"+c | --> RUN four\n"+
+		"+c | c1 out\n",
+		buf.String())
+}
@@ -0,0 +1,388 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	logsIndexFile     = "index.json"
+	logsTimeFormat    = "2006-01-02T15:04:05.000Z07:00"
+	maxCommandNameLen = 40
+)
+
+// The statuses of the targets and commands in the logs index.
+const (
+	logStatusSuccess    = "success"
+	logStatusFailure    = "failure"
+	logStatusCanceled   = "canceled"
+	logStatusCached     = "cached"
+	logStatusIncomplete = "incomplete"
+)
+
+// logsDir writes the complete output of each target, and of each of its commands, to files in a
+// directory, together with an index which maps the targets to their files and final status. It is
+// safe for concurrent use.
+type logsDir struct {
+	dir string
+
+	mu       sync.Mutex
+	targets  []*targetLog
+	bySalt   map[string]*targetLog
+	dirNames map[string]bool
+	// failed is set once writing to the dir has failed, after which nothing more is written.
+	failed bool
+}
+
+type logsIndex struct {
+	Status  string       `json:"status"`
+	Targets []*targetLog `json:"targets"`
+}
+
+type targetLog struct {
+	Target    string        `json:"target"`
+	BuildArgs string        `json:"buildArgs,omitempty"`
+	File      string        `json:"file"`
+	Status    string        `json:"status"`
+	Commands  []*commandLog `json:"commands"`
+
+	dir string
+	log logFile
+}
+
+type commandLog struct {
+	Command   string     `json:"command"`
+	File      string     `json:"file,omitempty"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Started   *time.Time `json:"started,omitempty"`
+	Completed *time.Time `json:"completed,omitempty"`
+
+	target        *targetLog
+	log           logFile
+	headerWritten bool
+	// Output which has not yet been terminated with a \n.
+	openLine []byte
+}
+
+func newLogsDir(dir string) (*logsDir, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create logs dir %s", dir)
+	}
+	return &logsDir{
+		dir:      dir,
+		bySalt:   make(map[string]*targetLog),
+		dirNames: make(map[string]bool),
+	}, nil
+}
+
+// command registers a new command of the target with the given salt.
+func (ld *logsDir) command(targetStr, targetBrackets, salt, operation string) *commandLog {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	tl, ok := ld.bySalt[salt]
+	if !ok {
+		dirName := logFileName(targetStr, "target", 0)
+		for i := 2; ld.dirNames[dirName]; i++ {
+			dirName = fmt.Sprintf("%s-%d", logFileName(targetStr, "target", 0), i)
+		}
+		ld.dirNames[dirName] = true
+		tl = &targetLog{
+			Target:    targetStr,
+			BuildArgs: targetBrackets,
+			File:      filepath.ToSlash(filepath.Join(dirName, "target.log")),
+			dir:       dirName,
+		}
+		tl.log.path = filepath.Join(ld.dir, tl.File)
+		ld.targets = append(ld.targets, tl)
+		ld.bySalt[salt] = tl
+	}
+	cl := &commandLog{
+		Command: operation,
+		Status:  logStatusIncomplete,
+		target:  tl,
+	}
+	fileName := fmt.Sprintf("%03d-%s.log", len(tl.Commands)+1, logFileName(operation, "command", maxCommandNameLen))
+	cl.log.path = filepath.Join(ld.dir, tl.dir, fileName)
+	tl.Commands = append(tl.Commands, cl)
+	return cl
+}
+
+// update records the progress of the vertex of the command.
+func (ld *logsDir) update(cl *commandLog, vertex *client.Vertex) error {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.failed {
+		return nil
+	}
+	return ld.checkErr(cl.update(vertex))
+}
+
+// write writes output of the command, which was produced at the given time.
+func (ld *logsDir) write(cl *commandLog, ts time.Time, data []byte) error {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.failed {
+		return nil
+	}
+	return ld.checkErr(cl.write(ts, data))
+}
+
+// checkErr disables the logs dir if err is not nil, closing all of its files, and returns err.
+func (ld *logsDir) checkErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	ld.failed = true
+	for _, tl := range ld.targets {
+		for _, cl := range tl.Commands {
+			cl.log.close()
+		}
+		tl.log.close()
+	}
+	return err
+}
+
+// disabled returns true if writing to the logs dir has failed.
+func (ld *logsDir) disabled() bool {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	return ld.failed
+}
+
+// writeIndex writes the index of the logs, with the given status of the build, and closes all open
+// files. Further output reopens the files as needed.
+func (ld *logsDir) writeIndex(status string) error {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	var retErr error
+	for _, tl := range ld.targets {
+		tl.Status = logStatusSuccess
+		for _, cl := range tl.Commands {
+			err := cl.flush(time.Now())
+			if err != nil && retErr == nil {
+				retErr = err
+			}
+			err = cl.log.close()
+			if err != nil && retErr == nil {
+				retErr = err
+			}
+			if logStatusPriority(cl.Status) > logStatusPriority(tl.Status) {
+				tl.Status = cl.Status
+			}
+		}
+		err := tl.log.close()
+		if err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	if retErr != nil {
+		return retErr
+	}
+	dt, err := json.MarshalIndent(logsIndex{
+		Status:  status,
+		Targets: ld.targets,
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal logs index")
+	}
+	indexPath := filepath.Join(ld.dir, logsIndexFile)
+	err = ioutil.WriteFile(indexPath, append(dt, '\n'), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", indexPath)
+	}
+	return nil
+}
+
+// logStatusPriority orders the statuses of commands, for the status of their target to be that of
+// the command with the highest priority.
+func logStatusPriority(status string) int {
+	switch status {
+	case logStatusFailure:
+		return 3
+	case logStatusCanceled:
+		return 2
+	case logStatusIncomplete:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (cl *commandLog) update(vertex *client.Vertex) error {
+	cl.Started = vertex.Started
+	cl.Completed = vertex.Completed
+	switch {
+	case strings.Contains(vertex.Error, "context canceled"):
+		cl.Status = logStatusCanceled
+	case vertex.Error != "":
+		cl.Status = logStatusFailure
+		cl.Error = vertex.Error
+	case vertex.Cached:
+		cl.Status = logStatusCached
+	case vertex.Completed != nil:
+		cl.Status = logStatusSuccess
+	}
+	if !cl.headerWritten && (vertex.Started != nil || vertex.Cached || vertex.Error != "") {
+		ts := time.Now()
+		if vertex.Started != nil {
+			ts = *vertex.Started
+		}
+		header := fmt.Sprintf("--> %s", cl.Command)
+		if vertex.Cached {
+			header += " *cached*"
+		}
+		err := cl.target.log.writeLine(ts, []byte(header))
+		if err != nil {
+			return err
+		}
+		cl.headerWritten = true
+	}
+	if vertex.Completed == nil && vertex.Error == "" {
+		return cl.closeIfDone()
+	}
+	ts := time.Now()
+	if vertex.Completed != nil {
+		ts = *vertex.Completed
+	}
+	err := cl.flush(ts)
+	if err != nil {
+		return err
+	}
+	if cl.Status == logStatusFailure {
+		err = cl.writeLine(ts, []byte(fmt.Sprintf("ERROR: %s", vertex.Error)))
+		if err != nil {
+			return err
+		}
+	}
+	return cl.closeIfDone()
+}
+
+func (cl *commandLog) write(ts time.Time, data []byte) error {
+	cl.openLine = append(cl.openLine, data...)
+	for {
+		i := bytes.IndexByte(cl.openLine, '\n')
+		if i == -1 {
+			return cl.closeIfDone()
+		}
+		err := cl.writeLine(ts, cl.openLine[:i])
+		if err != nil {
+			return err
+		}
+		cl.openLine = cl.openLine[i+1:]
+	}
+}
+
+// closeIfDone closes the file of the command once the command has completed, and the file of its target
+// once all of the target's commands have completed, so that large builds do not run out of file
+// descriptors. Should more output arrive, the files are reopened for appending.
+func (cl *commandLog) closeIfDone() error {
+	if cl.Status == logStatusIncomplete {
+		return nil
+	}
+	err := cl.log.close()
+	if err != nil {
+		return err
+	}
+	for _, other := range cl.target.Commands {
+		if other.Status == logStatusIncomplete {
+			return nil
+		}
+	}
+	return cl.target.log.close()
+}
+
+// flush writes any output of the command which has not been terminated with a \n.
+func (cl *commandLog) flush(ts time.Time) error {
+	if len(cl.openLine) == 0 {
+		return nil
+	}
+	err := cl.writeLine(ts, cl.openLine)
+	if err != nil {
+		return err
+	}
+	cl.openLine = nil
+	return nil
+}
+
+func (cl *commandLog) writeLine(ts time.Time, line []byte) error {
+	if cl.File == "" {
+		cl.File = filepath.ToSlash(filepath.Join(cl.target.dir, filepath.Base(cl.log.path)))
+	}
+	err := cl.log.writeLine(ts, line)
+	if err != nil {
+		return err
+	}
+	return cl.target.log.writeLine(ts, line)
+}
+
+// logFile is a file to which timestamped lines are written. It is truncated when first opened.
+type logFile struct {
+	path    string
+	f       *os.File
+	created bool
+}
+
+func (lf *logFile) writeLine(ts time.Time, line []byte) error {
+	if lf.f == nil {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if !lf.created {
+			flag |= os.O_TRUNC
+			err := os.MkdirAll(filepath.Dir(lf.path), 0755)
+			if err != nil {
+				return errors.Wrapf(err, "create dir of %s", lf.path)
+			}
+		}
+		f, err := os.OpenFile(lf.path, flag, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "open %s", lf.path)
+		}
+		lf.f = f
+		lf.created = true
+	}
+	_, err := fmt.Fprintf(lf.f, "%s %s\n", ts.UTC().Format(logsTimeFormat), line)
+	if err != nil {
+		return errors.Wrapf(err, "write to %s", lf.path)
+	}
+	return nil
+}
+
+func (lf *logFile) close() error {
+	if lf.f == nil {
+		return nil
+	}
+	err := lf.f.Close()
+	lf.f = nil
+	if err != nil {
+		return errors.Wrapf(err, "close %s", lf.path)
+	}
+	return nil
+}
+
+var logFileNameRegexp = regexp.MustCompile(`[^A-Za-z0-9.+_-]+`)
+
+// logFileName returns a name derived from s which is safe to use in file names, truncated to
+// maxLen characters unless maxLen is 0.
+func logFileName(s, fallback string, maxLen int) string {
+	name := logFileNameRegexp.ReplaceAllString(strings.TrimPrefix(s, "./"), "_")
+	if maxLen > 0 && len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	name = strings.Trim(name, "._")
+	if name == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,150 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogsDir(t *testing.T) {
+	dir := t.TempDir()
+	logs, err := newLogsDir(dir)
+	assert.NoError(t, err)
+	console := conslogging.Current(conslogging.NoColor, conslogging.NoPadding, false).WithWriter(ioutil.Discard)
+	sm := newSolverMonitor(console, false, true)
+	sm.logs = logs
+
+	at := func(sec int) *time.Time {
+		ts := time.Date(2021, 8, 1, 10, 0, sec, 0, time.UTC)
+		return &ts
+	}
+	runMake := &client.Vertex{Digest: digest.Digest("a"), Name: "[+build salt1] RUN make", Started: at(0)}
+	runTest := &client.Vertex{Digest: digest.Digest("b"), Name: "[+build salt1] RUN make test", Started: at(3)}
+	runEcho := &client.Vertex{Digest: digest.Digest("c"), Name: "[+build(VERSION=MS4w) salt2] RUN echo", Cached: true, Started: at(0), Completed: at(0)}
+	internal := &client.Vertex{Digest: digest.Digest("d"), Name: "docker-image://docker.io/library/alpine:3.13", Started: at(0)}
+
+	for _, ss := range []*client.SolveStatus{
+		{
+			Vertexes: []*client.Vertex{runMake, runEcho, internal},
+			Logs: []*client.VertexLog{
+				{Vertex: runMake.Digest, Timestamp: *at(1), Data: []byte("line one\nline ")},
+				{Vertex: internal.Digest, Timestamp: *at(1), Data: []byte("internal\n")},
+			},
+		},
+		{
+			Vertexes: []*client.Vertex{runTest},
+			Logs: []*client.VertexLog{
+				{Vertex: runMake.Digest, Timestamp: *at(2), Data: []byte("two\n")},
+				{Vertex: runTest.Digest, Timestamp: *at(4), Data: []byte("FAIL")},
+			},
+		},
+		{
+			Vertexes: []*client.Vertex{
+				{Digest: runMake.Digest, Name: runMake.Name, Started: at(0), Completed: at(2)},
+				{Digest: runTest.Digest, Name: runTest.Name, Started: at(3), Completed: at(5), Error: "exit code: 1"},
+			},
+		},
+	} {
+		assert.NoError(t, sm.processStatus(ss))
+		if ss.Vertexes[0] == runMake {
+			// Files are only held open while their commands are running.
+			assert.NotNil(t, sm.vertices[runMake.Digest].cmdLog.log.f)
+			assert.Nil(t, sm.vertices[runEcho.Digest].cmdLog.log.f)
+		}
+	}
+	for _, tl := range logs.targets {
+		assert.Nil(t, tl.log.f, tl.Target)
+		for _, cl := range tl.Commands {
+			assert.Nil(t, cl.log.f, cl.Command)
+		}
+	}
+	assert.NoError(t, logs.writeIndex(logStatusFailure))
+
+	dt, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	assert.NoError(t, err)
+	var index logsIndex
+	assert.NoError(t, json.Unmarshal(dt, &index))
+	assert.Equal(t, logStatusFailure, index.Status)
+	if assert.Len(t, index.Targets, 2) {
+		build := index.Targets[0]
+		assert.Equal(t, "+build", build.Target)
+		assert.Equal(t, "+build/target.log", build.File)
+		assert.Equal(t, logStatusFailure, build.Status)
+		if assert.Len(t, build.Commands, 2) {
+			assert.Equal(t, "RUN make", build.Commands[0].Command)
+			assert.Equal(t, "+build/001-RUN_make.log", build.Commands[0].File)
+			assert.Equal(t, logStatusSuccess, build.Commands[0].Status)
+			assert.Equal(t, at(2), build.Commands[0].Completed)
+			assert.Equal(t, "+build/002-RUN_make_test.log", build.Commands[1].File)
+			assert.Equal(t, logStatusFailure, build.Commands[1].Status)
+			assert.Equal(t, "exit code: 1", build.Commands[1].Error)
+		}
+
+		buildArgs := index.Targets[1]
+		assert.Equal(t, "+build-2/target.log", buildArgs.File)
+		assert.Equal(t, "VERSION=1.0", buildArgs.BuildArgs)
+		assert.Equal(t, logStatusSuccess, buildArgs.Status)
+		if assert.Len(t, buildArgs.Commands, 1) {
+			assert.Equal(t, logStatusCached, buildArgs.Commands[0].Status)
+			assert.Empty(t, buildArgs.Commands[0].File)
+		}
+	}
+
+	dt, err = ioutil.ReadFile(filepath.Join(dir, "+build", "001-RUN_make.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"2021-08-01T10:00:01.000Z line one\n"+
+		"2021-08-01T10:00:02.000Z line two\n",
+		string(dt))
+
+	dt, err = ioutil.ReadFile(filepath.Join(dir, "+build", "target.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"2021-08-01T10:00:00.000Z --> RUN make\n"+
+		"2021-08-01T10:00:01.000Z line one\n"+
+		"2021-08-01T10:00:03.000Z --> RUN make test\n"+
+		"2021-08-01T10:00:02.000Z line two\n"+
+		"2021-08-01T10:00:05.000Z FAIL\n"+
+		"2021-08-01T10:00:05.000Z ERROR: exit code: 1\n",
+		string(dt))
+}
+
+func TestLogsDirWriteError(t *testing.T) {
+	dir := t.TempDir()
+	logs, err := newLogsDir(dir)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	console := conslogging.Current(conslogging.NoColor, conslogging.NoPadding, false).WithWriter(&buf)
+	sm := newSolverMonitor(console, false, true)
+	sm.logs = logs
+	// The target log cannot be created, as a directory is in the way.
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "+build", "target.log"), 0755))
+
+	started := time.Now()
+	runMake := &client.Vertex{Digest: digest.Digest("a"), Name: "[+build salt1] RUN make", Started: &started}
+	assert.NoError(t, sm.processStatus(&client.SolveStatus{
+		Vertexes: []*client.Vertex{runMake},
+		Logs:     []*client.VertexLog{{Vertex: runMake.Digest, Timestamp: started, Data: []byte("output\n")}},
+	}))
+	assert.True(t, logs.disabled())
+	assert.Equal(t, 1, strings.Count(buf.String(), "Warning: could not write the logs to "+dir), buf.String())
+	assert.Contains(t, buf.String(), "+build | output\n")
+}
+
+func TestLogFileName(t *testing.T) {
+	assert.Equal(t, "+build", logFileName("+build", "target", 0))
+	assert.Equal(t, "sub_dir+test", logFileName("./sub/dir+test", "target", 0))
+	assert.Equal(t, "RUN_go_test", logFileName("RUN go test ./...", "command", 0))
+	assert.Equal(t, "RUN_echo", logFileName("RUN echo \"hello world\"", "command", 9))
+	assert.Equal(t, "command", logFileName("***", "command", 0))
+}
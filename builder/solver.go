@@ -167,6 +167,46 @@ func (s *solver) solveMain(ctx context.Context, state pllb.State, platform specs
 	return nil
 }
 
+func (s *solver) solveLocal(ctx context.Context, state pllb.State, platform specs.Platform, outDir string) error {
+	dt, err := state.Marshal(ctx, llb.Platform(platform))
+	if err != nil {
+		return errors.Wrap(err, "state marshal")
+	}
+	solveOpt, err := s.newSolveOptMain()
+	if err != nil {
+		return errors.Wrap(err, "new solve opt")
+	}
+	solveOpt.Exports = []client.ExportEntry{
+		{
+			Type:      client.ExporterLocal,
+			OutputDir: outDir,
+		},
+	}
+	ch := make(chan *client.SolveStatus)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		var err error
+		_, err = s.bkClient.Solve(ctx, dt, *solveOpt, ch)
+		if err != nil {
+			return errors.Wrap(err, "solve")
+		}
+		return nil
+	})
+	var vertexFailureOutput string
+	eg.Go(func() error {
+		var err error
+		vertexFailureOutput, err = s.sm.monitorProgress(ctx, ch, "", true)
+		return err
+	})
+	err = eg.Wait()
+	if err != nil {
+		return NewBuildError(err, vertexFailureOutput)
+	}
+	return nil
+}
+
 func (s *solver) newSolveOptDocker(img *image.Image, dockerTag string, w io.WriteCloser) (*client.SolveOpt, error) {
 	imgJSON, err := json.Marshal(img)
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +50,8 @@ type vertexMonitor struct {
 	openLine            []byte
 	lastOpenLineUpdate  time.Time
 	lastOpenLineSkipped bool
+	// cmdLog is the log of the vertex within the logs dir, if any.
+	cmdLog *commandLog
 }
 
 func (vm *vertexMonitor) printHeader() {
@@ -84,7 +87,8 @@ var ansiEraseRestLine = []byte(fmt.Sprintf("%c[K", esc))
 var ansiSupported = os.Getenv("TERM") != "dumb" &&
 	(isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd()))
 
-func (vm *vertexMonitor) printOutput(output []byte, sameAsLast bool) error {
+// recordOutput records the output in the tail buffer, without printing it.
+func (vm *vertexMonitor) recordOutput(output []byte) error {
 	if vm.tailOutput == nil {
 		var err error
 		vm.tailOutput, err = circbuf.NewBuffer(tailErrorBufferSizeBytes)
@@ -97,6 +101,14 @@ func (vm *vertexMonitor) printOutput(output []byte, sameAsLast bool) error {
 	if err != nil {
 		return errors.Wrap(err, "write to in-memory output buffer")
 	}
+	return nil
+}
+
+func (vm *vertexMonitor) printOutput(output []byte, sameAsLast bool) error {
+	err := vm.recordOutput(output)
+	if err != nil {
+		return err
+	}
 	printOutput := make([]byte, 0, len(vm.openLine)+len(output)+10)
 	if bytes.HasPrefix(output, []byte{'\n'}) && len(vm.openLine) > 0 && !vm.lastOpenLineSkipped {
 		// Optimization for cases where ansi control sequences are not supported:
@@ -224,6 +236,15 @@ type solverMonitor struct {
 	noOutputTicker              *time.Ticker
 	noOutputTick                time.Duration
 	errVertex                   *vertexMonitor
+	logs                        *logsDir
+	dashboard                   *dashboard
+	// groupedOutput buffers the output of each target, to print it as one block once the target
+	// completes.
+	groupedOutput bool
+	targetOutputs map[string]*conslogging.BufferedLogger
+	// convertedTargets holds the salts of the targets which the converter reported as converted, and
+	// which therefore have no more commands to add.
+	convertedTargets map[string]bool
 
 	mu      sync.Mutex
 	ongoing bool
@@ -247,6 +268,8 @@ func newSolverMonitor(console conslogging.ConsoleLogger, verbose bool, disableNo
 		vertices:               make(map[digest.Digest]*vertexMonitor),
 		saltSeen:               make(map[string]bool),
 		timingTable:            make(map[timingKey]time.Duration),
+		targetOutputs:          make(map[string]*conslogging.BufferedLogger),
+		convertedTargets:       make(map[string]bool),
 		startTime:              time.Now(),
 		noOutputTicker:         time.NewTicker(noOutputTick),
 		noOutputTick:           noOutputTick,
@@ -259,6 +282,10 @@ func (sm *solverMonitor) monitorProgress(ctx context.Context, ch chan *client.So
 		sm.ongoing = true
 		sm.mu.Unlock()
 	}
+	var dashboardC <-chan time.Time
+	if sm.dashboard != nil {
+		dashboardC = sm.dashboard.ticker.C
+	}
 Loop:
 	for {
 		select {
@@ -275,11 +302,17 @@ Loop:
 			if err != nil {
 				return "", err
 			}
+		case <-dashboardC:
+			sm.msgMu.Lock()
+			sm.updateDashboard()
+			sm.msgMu.Unlock()
 		}
 	}
 	failedVertexOutput := ""
 	if !sideRun {
 		sm.msgMu.Lock()
+		sm.flushTargetOutputs()
+		sm.stopDashboard()
 		if sm.errVertex != nil {
 			if sm.errVertex.tailOutput != nil {
 				failedVertexOutput = string(sm.errVertex.tailOutput.Bytes())
@@ -296,9 +329,22 @@ Loop:
 	return failedVertexOutput, nil
 }
 
+// failedVertexName returns the name of the vertex which failed the build, if any.
+func (sm *solverMonitor) failedVertexName() string {
+	sm.msgMu.Lock()
+	defer sm.msgMu.Unlock()
+	if sm.errVertex == nil {
+		return ""
+	}
+	return sm.errVertex.vertex.Name
+}
+
 func (sm *solverMonitor) processStatus(ss *client.SolveStatus) error {
 	sm.msgMu.Lock()
 	defer sm.msgMu.Unlock()
+	// The targets whose output is to be flushed once the logs of this status are buffered, and
+	// whether to do so even if they have commands which are still running.
+	flushSalts := make(map[string]bool)
 	for _, vertex := range ss.Vertexes {
 		vm, ok := sm.vertices[vertex.Digest]
 		if !ok {
@@ -318,32 +364,51 @@ func (sm *solverMonitor) processStatus(ss *client.SolveStatus) error {
 			if vm.meta["@local"] == "true" {
 				vm.console = vm.console.WithLocal(true)
 			}
+			if sm.groupedOutput {
+				vm.console = sm.targetOutput(targetStr, salt).Console(vm.console)
+			}
+			if sm.logs != nil && !vm.isInternal {
+				vm.cmdLog = sm.logs.command(targetStr, targetBrackets, salt, operation)
+			}
 			sm.vertices[vertex.Digest] = vm
 		}
 		vm.vertex = vertex
+		if vm.meta["@interactive"] == "true" && vertex.Started != nil {
+			// The interactive session needs the terminal.
+			sm.stopDashboard()
+		}
+		if vm.cmdLog != nil {
+			sm.checkLogsErr(sm.logs.update(vm.cmdLog, vertex))
+		}
 		if !vm.headerPrinted &&
 			((!vm.isInternal && (vertex.Cached || vertex.Started != nil)) || vertex.Error != "") {
 			sm.printHeader(vm)
-			sm.noOutputTicker.Reset(sm.noOutputTick)
+			sm.resetNoOutputTicker()
 		}
 		if vertex.Error != "" {
 			if strings.Contains(vertex.Error, "context canceled") {
 				if !vm.isInternal {
 					vm.console.Printf("WARN: Canceled\n")
-					sm.noOutputTicker.Reset(sm.noOutputTick)
+					sm.resetNoOutputTicker()
 				}
 			} else {
 				vm.isError = vm.printError()
 				if sm.errVertex == nil && vm.isError {
 					sm.errVertex = vm
+					sm.stopDashboard()
 				}
-				sm.noOutputTicker.Reset(sm.noOutputTick)
+				// Failures are printed right away.
+				flushSalts[vm.salt] = true
+				sm.resetNoOutputTicker()
 			}
 		}
 		if sm.verbose {
 			vm.printTimingInfo()
 			sm.recordTiming(vm.targetStr, vm.targetBrackets, vm.salt, vertex)
-			sm.noOutputTicker.Reset(sm.noOutputTick)
+			sm.resetNoOutputTicker()
+		}
+		if sm.groupedOutput && vertex.Completed != nil && !flushSalts[vm.salt] {
+			flushSalts[vm.salt] = false
 		}
 	}
 	for _, vs := range ss.Statuses {
@@ -360,7 +425,7 @@ func (sm *solverMonitor) processStatus(ss *client.SolveStatus) error {
 			progress = 100
 		}
 		sm.printProgress(vm, vs.ID, progress)
-		sm.noOutputTicker.Reset(sm.noOutputTick)
+		sm.resetNoOutputTicker()
 	}
 	for _, logLine := range ss.Logs {
 		vm, ok := sm.vertices[logLine.Vertex]
@@ -375,15 +440,129 @@ func (sm *solverMonitor) processStatus(ss *client.SolveStatus) error {
 		if err != nil {
 			return err
 		}
-		sm.noOutputTicker.Reset(sm.noOutputTick)
+		if vm.cmdLog != nil {
+			sm.checkLogsErr(sm.logs.write(vm.cmdLog, logLine.Timestamp, logLine.Data))
+		}
+		sm.resetNoOutputTicker()
+	}
+	if sm.groupedOutput && len(flushSalts) > 0 {
+		salts := make([]string, 0, len(flushSalts))
+		for salt := range flushSalts {
+			salts = append(salts, salt)
+		}
+		sort.Strings(salts)
+		for _, salt := range salts {
+			if flushSalts[salt] || sm.targetDone(salt) {
+				sm.flushTargetOutput(salt)
+			}
+		}
 	}
 	return nil
 }
 
+// checkLogsErr warns about a failure to write to the logs dir. The build carries on regardless, and the
+// logs dir does not write anything more.
+func (sm *solverMonitor) checkLogsErr(err error) {
+	if err == nil {
+		return
+	}
+	sm.console.Warnf("Warning: could not write the logs to %s; no further logs will be written: %s\n", sm.logs.dir, err.Error())
+	sm.resetNoOutputTicker()
+}
+
+// resetNoOutputTicker postpones the no-output update, as output has just been printed. Output which
+// is buffered does not count, so that the no-output updates act as a heartbeat in the meantime.
+func (sm *solverMonitor) resetNoOutputTicker() {
+	if sm.groupedOutput {
+		return
+	}
+	sm.noOutputTicker.Reset(sm.noOutputTick)
+}
+
+// targetOutput returns the buffer of the output of the target with the given salt.
+func (sm *solverMonitor) targetOutput(targetStr, salt string) *conslogging.BufferedLogger {
+	bl, ok := sm.targetOutputs[salt]
+	if !ok {
+		console := sm.console.WithPrefixAndSalt(targetStr, salt)
+		bl = conslogging.NewBufferedLogger(&console)
+		sm.targetOutputs[salt] = bl
+	}
+	return bl
+}
+
+// targetConverted records that the target with the given salt has been converted, and flushes its
+// output if all its commands have already completed.
+func (sm *solverMonitor) targetConverted(salt string) {
+	sm.msgMu.Lock()
+	defer sm.msgMu.Unlock()
+	sm.convertedTargets[salt] = true
+	if sm.groupedOutput && sm.targetDone(salt) {
+		sm.flushTargetOutput(salt)
+	}
+}
+
+// targetDone returns whether the target with the given salt has been converted, and all its
+// known vertices have completed.
+func (sm *solverMonitor) targetDone(salt string) bool {
+	if !sm.convertedTargets[salt] {
+		return false
+	}
+	for _, vm := range sm.vertices {
+		if vm.salt == salt && vm.vertex.Completed == nil && vm.vertex.Error == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// flushTargetOutput prints the buffered output of the target with the given salt, if any.
+func (sm *solverMonitor) flushTargetOutput(salt string) {
+	bl, ok := sm.targetOutputs[salt]
+	if !ok || bl.Len() == 0 {
+		return
+	}
+	bl.Flush()
+	sm.lastVertexOutput = nil
+	sm.lastOutputWasProgress = false
+	sm.lastOutputWasNoOutputUpdate = false
+	sm.noOutputTicker.Reset(sm.noOutputTick)
+}
+
+// flushTargetOutputs prints the buffered output of all targets, in the order in which they started.
+func (sm *solverMonitor) flushTargetOutputs() {
+	if !sm.groupedOutput {
+		return
+	}
+	now := time.Now()
+	started := make(map[string]time.Time)
+	for _, vm := range sm.vertices {
+		at := now
+		if vm.vertex.Started != nil {
+			at = *vm.vertex.Started
+		}
+		if prev, ok := started[vm.salt]; !ok || at.Before(prev) {
+			started[vm.salt] = at
+		}
+	}
+	salts := make([]string, 0, len(started))
+	for salt := range started {
+		salts = append(salts, salt)
+	}
+	sort.Slice(salts, func(i, j int) bool {
+		if !started[salts[i]].Equal(started[salts[j]]) {
+			return started[salts[i]].Before(started[salts[j]])
+		}
+		return salts[i] < salts[j]
+	})
+	for _, salt := range salts {
+		sm.flushTargetOutput(salt)
+	}
+}
+
 func (sm *solverMonitor) processNoOutputTick() error {
 	sm.msgMu.Lock()
 	defer sm.msgMu.Unlock()
-	if sm.disableNoOutputUpdates {
+	if sm.disableNoOutputUpdates || sm.dashboardActive() {
 		return nil
 	}
 	ongoingBuilder := []string{}
@@ -414,13 +593,16 @@ func (sm *solverMonitor) processNoOutputTick() error {
 		ongoingStr = strings.Join(ongoing, ", ")
 	}
 	ongoingBuilder = append(ongoingBuilder, ongoingStr, string(ansiEraseRestLine))
-	sm.console.WithPrefix("ongoing").Printf("%s\n", strings.Join(ongoingBuilder, ""))
+	sm.console.WithPrefix("ongoing").WithGroupless(true).Printf("%s\n", strings.Join(ongoingBuilder, ""))
 	sm.lastOutputWasProgress = false
 	sm.lastOutputWasNoOutputUpdate = true
 	return nil
 }
 
 func (sm *solverMonitor) printOutput(vm *vertexMonitor, data []byte) error {
+	if sm.dashboardActive() {
+		return vm.recordOutput(data)
+	}
 	sameAsLast := (sm.lastVertexOutput == vm && !sm.lastOutputWasProgress)
 	sm.lastVertexOutput = vm
 	sm.lastOutputWasProgress = false
@@ -429,6 +611,10 @@ func (sm *solverMonitor) printOutput(vm *vertexMonitor, data []byte) error {
 }
 
 func (sm *solverMonitor) printProgress(vm *vertexMonitor, id string, progress int) {
+	if sm.dashboardActive() {
+		// The dashboard shows the running vertices instead.
+		return
+	}
 	if vm.shouldPrintProgress(id, progress, sm.verbose, sm.lastOutputWasProgress) {
 		if !vm.headerPrinted {
 			sm.printHeader(vm)
@@ -440,6 +626,10 @@ func (sm *solverMonitor) printProgress(vm *vertexMonitor, id string, progress in
 }
 
 func (sm *solverMonitor) printHeader(vm *vertexMonitor) {
+	if sm.dashboardActive() {
+		// The header is printed with the first output once the dashboard is stopped.
+		return
+	}
 	seen := sm.saltSeen[vm.salt]
 	if !seen {
 		sm.saltSeen[vm.salt] = true
@@ -511,6 +701,11 @@ func (sm *solverMonitor) reprintFailure(errVertex *vertexMonitor, phaseText stri
 	sm.lastOutputWasNoOutputUpdate = false
 	sm.console.PrintFailure(phaseText)
 	sm.console.Warnf("Repeating the output of the command that caused the failure\n")
+	if sm.groupedOutput {
+		// Repeat the output directly, rather than in the buffer of the target.
+		errVertex.console = sm.console.WithPrefixAndSalt(errVertex.targetStr, errVertex.salt).
+			WithLocal(errVertex.meta["@local"] == "true")
+	}
 	errVertex.console = errVertex.console.WithFailed(true)
 	errVertex.printHeader()
 	if errVertex.tailOutput != nil {
@@ -527,6 +722,25 @@ func (sm *solverMonitor) reprintFailure(errVertex *vertexMonitor, phaseText stri
 		errVertex.console.Printf("[no output]\n")
 	}
 	errVertex.printError()
+	msg := errVertex.vertex.Error
+	if strings.Contains(msg, "did not complete successfully") {
+		msg = fmt.Sprintf("Command exited with non-zero code: %s", errVertex.operation)
+	}
+	file, line := parseLocation(errVertex.meta["@location"])
+	sm.console.PrintErrorAnnotation(file, line, fmt.Sprintf("[%s] %s", errVertex.targetStr, msg))
+}
+
+// parseLocation parses the @location metadata of a vertex, which is in the form <file>:<line>.
+func parseLocation(location string) (string, int) {
+	i := strings.LastIndexByte(location, ':')
+	if i == -1 {
+		return location, 0
+	}
+	line, err := strconv.Atoi(location[i+1:])
+	if err != nil {
+		return location, 0
+	}
+	return location[:i], line
 }
 
 var vertexRegexp = regexp.MustCompile(`(?s)^\[([^\]]*)\] (.*)$`)
@@ -91,6 +91,14 @@ func TestParseVertexName(t *testing.T) {
 			salt:           "5577006791947779410",
 			operation:      "RUN whoami",
 		},
+		{
+			name:           "[+target(@location=c3ViL0VhcnRoZmlsZToxMg==) salt] RUN make",
+			targetStr:      "+target",
+			targetBrackets: "",
+			meta:           map[string]string{"@location": "sub/Earthfile:12"},
+			salt:           "salt",
+			operation:      "RUN make",
+		},
 	} {
 		targetStr, targetBrackets, meta, salt, operation := parseVertexName(tt.name)
 		Equal(t, tt.targetStr, targetStr)
@@ -101,3 +109,17 @@ func TestParseVertexName(t *testing.T) {
 
 	}
 }
+
+func TestParseLocation(t *testing.T) {
+	file, line := parseLocation("sub/Earthfile:12")
+	Equal(t, "sub/Earthfile", file)
+	Equal(t, 12, line)
+
+	file, line = parseLocation("")
+	Equal(t, "", file)
+	Equal(t, 0, line)
+
+	file, line = parseLocation("Earthfile")
+	Equal(t, "Earthfile", file)
+	Equal(t, 0, line)
+}
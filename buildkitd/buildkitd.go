@@ -2,7 +2,10 @@ package buildkitd
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
@@ -48,6 +51,9 @@ func DefaultAddressForSetting(setting string) (string, error) {
 	case containerutil.FrontendPodmanShell:
 		return TCPAddress, nil // Right now, podman only works over TCP. There are weird errors when trying to use the provided helper from buildkit.
 
+	case containerutil.FrontendNerdctlShell:
+		return TCPAddress, nil // There is no buildkit connection helper for containerd.
+
 	case containerutil.FrontendStub:
 		return DockerAddress, nil // Maintiain old behavior
 	}
@@ -91,6 +97,24 @@ func NewClient(ctx context.Context, console conslogging.ConsoleLogger, image, co
 	return bkClient, nil
 }
 
+// NewClientNoStart returns a new buildkitd client connected to the configured address. Unlike NewClient,
+// it never attempts to start or restart a managed buildkitd; an error is returned if it is not reachable.
+func NewClientNoStart(ctx context.Context, settings Settings, opts ...client.ClientOpt) (*client.Client, error) {
+	opts, err := addRequiredOpts(settings, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "add required client opts")
+	}
+	err = checkConnection(ctx, settings.BuildkitAddress, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect to buildkitd at %s", settings.BuildkitAddress)
+	}
+	bkClient, err := client.New(ctx, settings.BuildkitAddress, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "new buildkit client")
+	}
+	return bkClient, nil
+}
+
 // ResetCache restarts the buildkitd daemon with the reset command.
 func ResetCache(ctx context.Context, console conslogging.ConsoleLogger, image, containerName string, fe containerutil.ContainerFrontend, settings Settings, opts ...client.ClientOpt) error {
 	// Prune by resetting container.
@@ -283,6 +307,7 @@ func Start(ctx context.Context, console conslogging.ConsoleLogger, image, contai
 		"BUILDKIT_DEBUG":                 strconv.FormatBool(settings.Debug),
 		"BUILDKIT_TCP_TRANSPORT_ENABLED": strconv.FormatBool(settings.UseTCP),
 		"BUILDKIT_TLS_ENABLED":           strconv.FormatBool(settings.UseTCP && settings.UseTLS),
+		"SHELLREPEATER_PORT":             strconv.Itoa(settings.ShellRepeaterPort),
 	}
 
 	labelOpts := map[string]string{
@@ -334,7 +359,7 @@ func Start(ctx context.Context, console conslogging.ConsoleLogger, image, contai
 		portOpts = append(portOpts, containerutil.Port{
 			IP:            "127.0.0.1",
 			HostPort:      hostPort,
-			ContainerPort: 8373,
+			ContainerPort: settings.ShellRepeaterPort,
 			Protocol:      containerutil.ProtocolTCP,
 		})
 
@@ -422,6 +447,10 @@ func Start(ctx context.Context, console conslogging.ConsoleLogger, image, contai
 		envOpts["CACHE_SIZE_MB"] = strconv.FormatInt(int64(settings.CacheSizeMb), 10)
 	}
 
+	if settings.DindCacheSizeMb > 0 {
+		envOpts["EARTHLY_DIND_CACHE_SIZE_MB"] = strconv.FormatInt(int64(settings.DindCacheSizeMb), 10)
+	}
+
 	if settings.GitURLInsteadOf != "" {
 		envOpts["GIT_URL_INSTEAD_OF"] = strconv.FormatInt(int64(settings.CacheSizeMb), 10)
 	}
@@ -786,3 +815,47 @@ func addRequiredOpts(settings Settings, opts ...client.ClientOpt) ([]client.Clie
 
 	return append(opts, client.WithCredentials(server.Hostname(), caPath, certPath, keyPath)), nil
 }
+
+// DebuggerTLSConfig returns the TLS config used to connect to the debugger of an mTLS-enabled daemon,
+// along with the PEM encoded CA. It returns nil if TLS is not enabled.
+func DebuggerTLSConfig(settings Settings, serverName string) (*tls.Config, []byte, error) {
+	if !settings.UseTCP || !settings.UseTLS {
+		return nil, nil, nil
+	}
+
+	caPath, err := makeTLSPath(settings.TLSCA)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "caPath")
+	}
+
+	certPath, err := makeTLSPath(settings.ClientTLSCert)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "certPath")
+	}
+
+	keyPath, err := makeTLSPath(settings.ClientTLSKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "keyPath")
+	}
+
+	ca, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "read %s", caPath)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, nil, errors.Errorf("%s does not contain a PEM encoded certificate", caPath)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "load client certificate")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, ca, nil
+}
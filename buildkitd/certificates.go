@@ -7,6 +7,7 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"math/big"
 	"net"
@@ -49,6 +50,27 @@ func GenerateCertificates(dir string) error {
 	return nil
 }
 
+// LoadCertificate reads a PEM encoded certificate from disk. Relative paths are interpreted as relative to the ~/.earthly folder.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	fullPath, err := makeTLSPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", fullPath)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.Errorf("%s does not contain a PEM encoded certificate", fullPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse certificate %s", fullPath)
+	}
+	return cert, nil
+}
+
 // DeleteCerts removes all generated certs.
 func DeleteCerts(dir string) error {
 	return os.RemoveAll(dir)
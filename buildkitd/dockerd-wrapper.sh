@@ -24,11 +24,19 @@ execute() {
         exit 1
     fi
 
+    acquire_data_root
     start_dockerd
     load_images
     if [ "$EARTHLY_START_COMPOSE" = "true" ]; then
         # shellcheck disable=SC2086
         docker_compose_cmd up -d $EARTHLY_COMPOSE_SERVICES
+        if ! wait_for_compose_services; then
+            print_compose_logs
+            save_compose_logs
+            docker_compose_cmd down --remove-orphans
+            stop_dockerd
+            exit 1
+        fi
     fi
 
     shift
@@ -39,12 +47,133 @@ execute() {
     set -e
 
     if [ "$EARTHLY_START_COMPOSE" = "true" ]; then
+        if [ "$exit_code" != "0" ]; then
+            print_compose_logs
+        fi
+        save_compose_logs
         docker_compose_cmd down --remove-orphans
     fi
     stop_dockerd
     return "$exit_code"
 }
 
+# Waits until the containers of the compose services are healthy, or running if they do not define a
+# healthcheck. Containers which have exited successfully, such as one-off setup tasks, are also fine.
+wait_for_compose_services() {
+    health_timeout="${EARTHLY_COMPOSE_HEALTH_TIMEOUT:-0}"
+    if [ "$health_timeout" = "0" ]; then
+        return 0
+    fi
+    echo "Waiting for compose services to become healthy..."
+    i=0
+    while true; do
+        pending=""
+        # shellcheck disable=SC2086
+        containers="$(docker_compose_cmd ps -q $EARTHLY_COMPOSE_SERVICES)"
+        if [ -z "$containers" ]; then
+            echo "ERROR: no compose containers are running"
+            return 1
+        fi
+        for container in $containers; do
+            # shellcheck disable=SC2046
+            set -- $(docker inspect -f '{{.Name}} {{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}} {{.State.ExitCode}}' "$container")
+            name="${1#/}"
+            case "$2" in
+                healthy|running)
+                    ;;
+                exited)
+                    if [ "$3" != "0" ]; then
+                        echo "ERROR: compose container $name exited with code $3"
+                        return 1
+                    fi
+                    ;;
+                unhealthy|dead)
+                    echo "ERROR: compose container $name is $2"
+                    return 1
+                    ;;
+                *)
+                    pending="$pending $name"
+                    ;;
+            esac
+        done
+        if [ -z "$pending" ]; then
+            echo "...done"
+            return 0
+        fi
+        if [ "$i" -ge "$health_timeout" ]; then
+            echo "ERROR: compose containers did not become healthy within ${health_timeout}s:$pending"
+            return 1
+        fi
+        sleep 1
+        i=$((i+1))
+    done
+}
+
+print_compose_logs() {
+    for service in $(docker_compose_cmd ps --services); do
+        echo "==== Begin logs of compose service $service ===="
+        docker_compose_cmd logs --no-color "$service" || true
+        echo "==== End logs of compose service $service ===="
+    done
+}
+
+# Writes the logs of each compose service to a file in the directory given via EARTHLY_COMPOSE_LOGS.
+# The directory is bound to the buildkit host, from where earthly outputs it once the build is done,
+# even if the command fails.
+save_compose_logs() {
+    if [ -z "${EARTHLY_COMPOSE_LOGS:-}" ]; then
+        return 0
+    fi
+    rm -rf "$EARTHLY_COMPOSE_LOGS"
+    mkdir -p "$EARTHLY_COMPOSE_LOGS"
+    for service in $(docker_compose_cmd ps --services); do
+        docker_compose_cmd logs --no-color "$service" >"$EARTHLY_COMPOSE_LOGS/$service.log" 2>&1 || true
+    done
+}
+
+# Uses the data root kept between runs of the target, if one is configured and it is not in use by
+# another build. Otherwise, dockerd starts from an empty data root which is wiped when done.
+acquire_data_root() {
+    data_root="$EARTHLY_DOCKERD_DATA_ROOT"
+    persist_data_root=false
+    if [ -z "${EARTHLY_DOCKERD_CACHE_DATA_ROOT:-}" ]; then
+        return
+    fi
+    lock_dir="$EARTHLY_DOCKERD_CACHE_DATA_ROOT.lock"
+    mkdir -p "$(dirname "$EARTHLY_DOCKERD_CACHE_DATA_ROOT")"
+    # The lock is refreshed every few seconds while in use. A lock which has not been refreshed for
+    # over a minute has been left behind by an interrupted build.
+    if [ -d "$lock_dir" ] && [ -n "$(find "$lock_dir" -maxdepth 0 -mmin +1)" ]; then
+        rm -rf "$lock_dir"
+    fi
+    if ! mkdir "$lock_dir" 2>/dev/null; then
+        echo "The cached docker state of this target is in use by another build; starting with an empty state"
+        return
+    fi
+    data_root="$EARTHLY_DOCKERD_CACHE_DATA_ROOT"
+    persist_data_root=true
+    # Mark the state as recently used, such that it is the last to be removed once the cache is full.
+    mkdir -p "$data_root"
+    touch "$data_root"
+    (
+        while [ -d "$lock_dir" ] && kill -0 "$$" >/dev/null 2>&1; do
+            touch "$lock_dir"
+            sleep 10
+        done
+    ) &
+    lock_refresh_pid="$!"
+}
+
+release_data_root() {
+    if [ "$persist_data_root" = "true" ]; then
+        kill "$lock_refresh_pid" >/dev/null 2>&1 || true
+        rm -rf "$lock_dir"
+    else
+        # Wipe dockerd data when done.
+        rm -rf "$data_root"
+    fi
+}
+
 start_dockerd() {
     # Use a specific IP range to avoid collision with host dockerd (we need to also connect to host
     # docker containers for the debugger).
@@ -64,10 +193,12 @@ start_dockerd() {
 }
 EOF
 
-    # Start with a rm -rf to make sure a previous interrupted build did not leave its state around.
-    rm -rf "$EARTHLY_DOCKERD_DATA_ROOT"
-    mkdir -p "$EARTHLY_DOCKERD_DATA_ROOT"
-    dockerd --data-root="$EARTHLY_DOCKERD_DATA_ROOT" --bip=172.20.0.1/16 >/var/log/docker.log 2>&1 &
+    if [ "$persist_data_root" != "true" ]; then
+        # Start with a rm -rf to make sure a previous interrupted build did not leave its state around.
+        rm -rf "$data_root"
+    fi
+    mkdir -p "$data_root"
+    dockerd --data-root="$data_root" --bip=172.20.0.1/16 >/var/log/docker.log 2>&1 &
     dockerd_pid="$!"
     i=1
     timeout=300
@@ -88,6 +219,10 @@ EOF
             cat /var/log/docker.log
             echo "==== End dockerd logs ===="
             echo "If you are having trouble running docker, try using the official earthly/dind image instead"
+            if [ "$persist_data_root" = "true" ]; then
+                echo "The cached docker state of this target may be corrupted; it can be cleared via earthly prune --reset"
+            fi
+            release_data_root
             exit 1
         fi
         i=$((i+1))
@@ -95,6 +230,10 @@ EOF
 }
 
 stop_dockerd() {
+    if [ "$persist_data_root" = "true" ]; then
+        # Only images, layers and volumes are kept between runs.
+        docker container prune -f >/dev/null 2>&1 || true
+    fi
     dockerd_pid="$(cat /var/run/docker.pid)"
     timeout=30
     if [ -n "$dockerd_pid" ]; then
@@ -108,16 +247,29 @@ stop_dockerd() {
             i=$((i+1))
         done
     fi
-    # Wipe dockerd data when done.
-    rm -rf "$EARTHLY_DOCKERD_DATA_ROOT"
+    release_data_root
 }
 
 load_images() {
     if [ -n "$EARTHLY_DOCKER_LOAD_FILES" ]; then
         echo "Loading images..."
+        # The images are loaded in parallel. Layers which are already present, as is the case
+        # for a cached docker state, are not loaded again.
+        load_pids=""
         for img in $EARTHLY_DOCKER_LOAD_FILES; do
-            docker load -i "$img" || (stop_dockerd; exit 1)
+            docker load -i "$img" &
+            load_pids="$load_pids $!"
         done
+        load_failed=false
+        for pid in $load_pids; do
+            if ! wait "$pid"; then
+                load_failed=true
+            fi
+        done
+        if [ "$load_failed" = "true" ]; then
+            stop_dockerd
+            exit 1
+        fi
         echo "...done"
     fi
 }
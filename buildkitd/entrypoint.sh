@@ -77,9 +77,44 @@ else
 fi
 ln -sf "/sbin/$IP_TABLES" /sbin/iptables
 
+# Removes the least recently used docker states kept by WITH DOCKER --cache-state, until their total size
+# is within EARTHLY_DIND_CACHE_SIZE_MB. States which are in use by a build are kept.
+prune_dind_cache() {
+    if [ -z "$EARTHLY_DIND_CACHE_SIZE_MB" ]; then
+        return 0
+    fi
+    cache_dir="$EARTHLY_TMP_DIR/dind-cache"
+    total="$(du -sm "$cache_dir" | cut -f1)"
+    # The states are touched whenever they are used, so the oldest are listed first.
+    # shellcheck disable=SC2045
+    for state in $(ls -tr "$cache_dir"); do
+        if [ "$total" -le "$EARTHLY_DIND_CACHE_SIZE_MB" ]; then
+            break
+        fi
+        case "$state" in
+            *.lock)
+                continue
+                ;;
+        esac
+        # Take the lock of the state, such that no build starts using it while it is removed.
+        if ! mkdir "$cache_dir/$state.lock" 2>/dev/null; then
+            continue
+        fi
+        size="$(du -sm "$cache_dir/$state" | cut -f1)"
+        echo "Removing cached docker state $state (${size}MB) to stay within dind_cache_size_mb"
+        rm -rf "${cache_dir:?}/$state"
+        rmdir "$cache_dir/$state.lock"
+        total=$((total-size))
+    done
+}
+
 # clear any leftovers in the dind dir
 rm -rf "$EARTHLY_TMP_DIR/dind"
 mkdir -p "$EARTHLY_TMP_DIR/dind"
+# keep the docker state cached via WITH DOCKER --cache-state, but not the locks of builds which were running
+mkdir -p "$EARTHLY_TMP_DIR/dind-cache"
+rm -rf "$EARTHLY_TMP_DIR"/dind-cache/*.lock
+prune_dind_cache
 
 # setup git credentials and config
 i=0
@@ -167,8 +202,13 @@ execpid=$!
 
 # quit if either buildkit or shellrepeater die
 set +x
+i=0
 while true
 do
+    i=$((i+1))
+    if [ "$((i % 300))" = "0" ]; then
+        prune_dind_cache &
+    fi
     if ! kill -0 $shellrepeaterpid >/dev/null 2>&1; then
         echo "Error: shellrepeater process has exited"
         exit 1
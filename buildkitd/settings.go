@@ -12,6 +12,7 @@ import (
 // Settings represents the buildkitd settings used to start up the daemon with.
 type Settings struct {
 	CacheSizeMb          int
+	DindCacheSizeMb      int
 	GitURLInsteadOf      string
 	Debug                bool
 	BuildkitAddress      string
@@ -30,6 +31,7 @@ type Settings struct {
 	UseTLS               bool
 	VolumeName           string
 	IPTables             string
+	ShellRepeaterPort    int
 }
 
 // Hash returns a secure hash of the settings.
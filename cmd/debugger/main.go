@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"os"
 	"os/exec"
 	"time"
@@ -83,10 +82,14 @@ func populateShellHistory(cmd string) error {
 	return result
 }
 
-func interactiveMode(ctx context.Context, remoteConsoleAddr string, cmdBuilder func() (*exec.Cmd, error)) error {
+func interactiveMode(ctx context.Context, settings *common.DebuggerSettings, cmdBuilder func() (*exec.Cmd, error)) error {
 	log := slog.GetLogger(ctx)
 
-	conn, err := net.Dial("tcp", remoteConsoleAddr)
+	tlsConfig, err := settings.TLSConfig()
+	if err != nil {
+		return err
+	}
+	conn, err := common.Dial(ctx, settings.RepeaterAddr, tlsConfig)
 	if err != nil {
 		return errors.Wrap(err, "failed to connect to remote debugger")
 	}
@@ -97,7 +100,7 @@ func interactiveMode(ctx context.Context, remoteConsoleAddr string, cmdBuilder f
 		}
 	}()
 
-	_, err = conn.Write([]byte{common.ShellID})
+	err = common.WriteHandshake(conn, common.ShellID, settings.SessionToken)
 	if err != nil {
 		return err
 	}
@@ -234,7 +237,7 @@ func main() {
 			return exec.Command(args[0], args[1:]...), nil
 		}
 
-		err = interactiveMode(ctx, debuggerSettings.RepeaterAddr, cmdBuilder)
+		err = interactiveMode(ctx, debuggerSettings, cmdBuilder)
 		if err != nil {
 			log.Error(err)
 		}
@@ -286,7 +289,7 @@ func main() {
 				return exec.Command(shellPath), nil
 			}
 
-			err = interactiveMode(ctx, debuggerSettings.RepeaterAddr, cmdBuilder)
+			err = interactiveMode(ctx, debuggerSettings, cmdBuilder)
 			if err != nil {
 				log.Error(err)
 			}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/moby/buildkit/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheFilters(t *testing.T) {
+	var tests = []struct {
+		in       []string
+		expected []string
+		err      bool
+	}{
+		{[]string{"type=exec.cachemount"}, []string{"type==exec.cachemount"}, false},
+		{[]string{"type==regular", "id!=abc"}, []string{"type==regular,id!=abc"}, false},
+		{[]string{"description~=npm"}, []string{"description~=npm"}, false},
+		{[]string{"type=regular", "inuse"}, []string{"type==regular,inuse"}, false},
+		{[]string{"=foo"}, nil, true},
+		{nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		actual, err := parseCacheFilters(tt.in)
+		if tt.err {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, actual)
+	}
+}
+
+func TestMatchCacheMounts(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "cache-mounts.json")
+	err := ioutil.WriteFile(indexPath, []byte(`{
+  "cached mount /root/.npm from exec npm install": {"path": "/root/.npm", "id": "npm", "target": "+build"},
+  "cached mount /go/pkg/mod from exec go build": {"path": "/go/pkg/mod", "id": "gomod", "global": true, "target": "+build"},
+  "cached mount /root/.npm from exec npm test": {"path": "/root/.npm", "id": "npm", "target": "./web+test"}
+}`), 0644)
+	assert.NoError(t, err)
+	cacheMounts, err := earthfile2llb.ReadCacheMountsIndex(indexPath)
+	assert.NoError(t, err)
+
+	records := []*client.UsageInfo{
+		{ID: "abc123", Description: "cached mount /root/.npm from exec npm install"},
+		{ID: "abd456", Description: "cached mount /go/pkg/mod from exec go build"},
+		{ID: "xyz789", Description: "cached mount /root/.npm from exec npm test"},
+		{ID: "old000", Description: "cached mount /root/.cache from exec make"},
+	}
+
+	matched, err := matchCacheMounts(records, cacheMounts, []string{"npm"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*client.UsageInfo{records[0], records[2]}, matched)
+
+	matched, err = matchCacheMounts(records, cacheMounts, []string{"+build"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*client.UsageInfo{records[0]}, matched)
+
+	matched, err = matchCacheMounts(records, cacheMounts, []string{"/root/.npm/", "gomod", "./web+test"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*client.UsageInfo{records[0], records[2], records[1]}, matched)
+
+	matched, err = matchCacheMounts(records, cacheMounts, []string{"/root/.cache"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*client.UsageInfo{records[3]}, matched)
+
+	_, err = matchCacheMounts(records, cacheMounts, []string{"abc123"})
+	assert.Error(t, err)
+
+	_, err = matchCacheMounts(records, cacheMounts, []string{"/does/not/exist"})
+	assert.Error(t, err)
+}
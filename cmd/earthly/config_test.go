@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectConfigDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-project-config-dir")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0755))
+
+	var tests = []struct {
+		args []string
+		dir  string
+	}{
+		{nil, "."},
+		{[]string{"+build"}, "."},
+		{[]string{sub + "+build", "--FOO=a+b"}, sub},
+		{[]string{"prefetch", sub + "+build"}, sub},
+		{[]string{"--flag", sub + "+build/out.bin", "./out"}, sub},
+		{[]string{"github.com/foo/bar+build", sub + "+build"}, "."},
+		{[]string{filepath.Join(dir, "missing") + "+build"}, "."},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.dir, projectConfigDir(tt.args), "args %v", tt.args)
+	}
+}
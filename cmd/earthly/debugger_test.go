@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellRepeaterAddr(t *testing.T) {
+	debuggerURL, err := url.Parse("tcp://127.0.0.1:8373")
+	assert.NoError(t, err)
+	// A managed buildkitd is reached on the configured port within its container, rather than on the
+	// port published on the host.
+	assert.Equal(t, "172.17.0.2:9000", shellRepeaterAddr("172.17.0.2", debuggerURL, 9000))
+
+	debuggerURL, err = url.Parse("tcp://buildkit.example.com:9001")
+	assert.NoError(t, err)
+	assert.Equal(t, "buildkit.example.com:9001", shellRepeaterAddr("", debuggerURL, 9000))
+}
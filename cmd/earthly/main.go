@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -21,12 +22,14 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"github.com/joho/godotenv"
@@ -59,13 +62,18 @@ import (
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/config"
 	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/debugger/asciicast"
 	debuggercommon "github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/debugger/terminal"
 	"github.com/earthly/earthly/docker2earthly"
+	"github.com/earthly/earthly/doctor"
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2dockerfile"
 	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/lockfile"
 	"github.com/earthly/earthly/secretsclient"
 	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/states/image"
 	"github.com/earthly/earthly/util/cliutil"
 	"github.com/earthly/earthly/util/containerutil"
 	"github.com/earthly/earthly/util/fileutil"
@@ -79,6 +87,16 @@ const (
 	DefaultBuildkitdContainerName = "earthly-buildkitd"
 	// DefaultBuildkitdVolumeName is the name of the docker volume used for storing the cache.
 	DefaultBuildkitdVolumeName = "earthly-cache"
+	// prefetchIndexFileName is the name of the file in the earthly dir which records the image digests and
+	// remote Earthfile commits pulled by earthly prefetch, for use by offline builds.
+	prefetchIndexFileName = "prefetch.lock"
+	// cacheMountsIndexFileName is the name of the file in the earthly dir which records the id and target
+	// of the cache mounts created by RUN --mount=type=cache, for use by earthly cache mounts.
+	cacheMountsIndexFileName = "cache-mounts.json"
+	// outputModeInterleaved prints the output of targets which run in parallel as it is produced.
+	outputModeInterleaved = "interleaved"
+	// outputModeGrouped prints the output of each target as one block, once the target completes.
+	outputModeGrouped = "grouped"
 )
 
 var dotEnvPath = ".env"
@@ -100,6 +118,15 @@ type cliFlags struct {
 	artifactMode              bool
 	imageMode                 bool
 	pull                      bool
+	updateLock                bool
+	lockOnly                  bool
+	offline                   bool
+	prefetch                  bool
+	interactiveShell          bool
+	runImage                  bool
+	runEnvs                   cli.StringSlice
+	runVolumes                cli.StringSlice
+	runArgs                   []string
 	push                      bool
 	ci                        bool
 	output                    bool
@@ -107,6 +134,10 @@ type cliFlags struct {
 	noCache                   bool
 	pruneAll                  bool
 	pruneReset                bool
+	pruneKeepDuration         time.Duration
+	pruneKeepStorage          string
+	cacheFilters              cli.StringSlice
+	doctorJSON                bool
 	buildkitdSettings         buildkitd.Settings
 	allowPrivileged           bool
 	enableProfiler            bool
@@ -119,9 +150,18 @@ type cliFlags struct {
 	saveInlineCache           bool
 	useInlineCache            bool
 	configPath                string
+	cfgOrigins                config.Origins
 	gitUsernameOverride       string
 	gitPasswordOverride       string
 	interactiveDebugging      bool
+	breakpoints               cli.StringSlice
+	debuggerRecordPath        string
+	saveFailedImage           string
+	logsDir                   string
+	dashboard                 bool
+	outputMode                string
+	replaySpeed               float64
+	replayIdleTimeLimit       time.Duration
 	sshAuthSock               string
 	verbose                   bool
 	debug                     bool
@@ -140,12 +180,14 @@ type cliFlags struct {
 	dockerfilePath            string
 	earthfilePath             string
 	earthfileFinalImage       string
+	exportDockerfilePath      string
 	expiry                    string
 	termsConditionsPrivacy    bool
 	authToken                 string
 	noFakeDep                 bool
 	enableSourceMap           bool
 	configDryRun              bool
+	configShowEffective       bool
 	strict                    bool
 	conversionParllelism      int
 	debuggerHost              string
@@ -242,7 +284,13 @@ func main() {
 		padding = conslogging.NoPadding
 	}
 
-	app := newEarthlyApp(ctx, conslogging.Current(colorMode, padding, false))
+	console := conslogging.Current(colorMode, padding, false)
+	_, noLogGroups := os.LookupEnv("EARTHLY_NO_LOG_GROUPS")
+	if ciName, isCI := analytics.DetectCI(); isCI && !noLogGroups {
+		console = console.WithLogGroups(conslogging.LogGroupModeForCI(ciName))
+	}
+
+	app := newEarthlyApp(ctx, console)
 	app.autoComplete()
 
 	exitCode := app.run(ctx, os.Args)
@@ -353,6 +401,18 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Force pull any referenced Docker images",
 			Destination: &app.pull,
 		},
+		&cli.BoolFlag{
+			Name:        "update-lock",
+			EnvVars:     []string{"EARTHLY_UPDATE_LOCK"},
+			Usage:       "Resolve image digests and remote Earthfile commits afresh, ignoring and rewriting Earthfile.lock",
+			Destination: &app.updateLock,
+		},
+		&cli.BoolFlag{
+			Name:        "offline",
+			EnvVars:     []string{"EARTHLY_OFFLINE"},
+			Usage:       "Never access the network to resolve images and remote Earthfiles; only use those prefetched or pinned",
+			Destination: &app.offline,
+		},
 		&cli.BoolFlag{
 			Name:        "push",
 			EnvVars:     []string{"EARTHLY_PUSH"},
@@ -527,6 +587,43 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Enable interactive debugging",
 			Destination: &app.interactiveDebugging,
 		},
+		&cli.StringSliceFlag{
+			Name:        "break",
+			EnvVars:     []string{"EARTHLY_BREAK"},
+			Usage:       wrap("Pause the build to open an interactive shell before the command at <path>:<line>, ", "or before the first command of a target; may be repeated"),
+			Destination: &app.breakpoints,
+		},
+		&cli.StringFlag{
+			Name:        "debugger-record",
+			EnvVars:     []string{"EARTHLY_DEBUGGER_RECORD"},
+			Usage:       wrap("Record interactive debugger sessions as asciicast files at the given path; ", "play them back via earthly debug replay"),
+			Destination: &app.debuggerRecordPath,
+		},
+		&cli.StringFlag{
+			Name:        "save-failed-image",
+			EnvVars:     []string{"EARTHLY_SAVE_FAILED_IMAGE"},
+			Usage:       wrap("When a RUN command fails, save the state in which it ran as a local image with the given name, ", "and print the command which runs it again"),
+			Destination: &app.saveFailedImage,
+		},
+		&cli.StringFlag{
+			Name:        "logs-dir",
+			EnvVars:     []string{"EARTHLY_LOGS_DIR"},
+			Usage:       wrap("Write the complete, timestamped output of each target and each of its commands to files in the given directory, ", "together with an index.json mapping the targets to their files and status"),
+			Destination: &app.logsDir,
+		},
+		&cli.BoolFlag{
+			Name:        "dashboard",
+			EnvVars:     []string{"EARTHLY_DASHBOARD"},
+			Usage:       wrap("Show the running targets and commands, and the last lines of their output, in a dashboard ", "in place of the output of each command, when running in a terminal"),
+			Destination: &app.dashboard,
+		},
+		&cli.StringFlag{
+			Name:        "output-mode",
+			EnvVars:     []string{"EARTHLY_OUTPUT_MODE"},
+			Usage:       wrap("How to print the output of targets which run in parallel: interleaved, as it is produced, ", "or grouped, as one block per target once it completes"),
+			Value:       outputModeInterleaved,
+			Destination: &app.outputMode,
+		},
 		&cli.BoolFlag{
 			Name:        "verbose",
 			Aliases:     []string{"V"},
@@ -900,6 +997,26 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 						},
 					},
 				},
+				{
+					Name:        "replay",
+					Usage:       "Play back a recorded interactive debugger session",
+					Description: "Plays back an asciicast file recorded via --debugger-record",
+					UsageText:   "earthly [options] debug replay [--speed <factor>] [--idle-time-limit <duration>] <path>",
+					Action:      app.actionDebugReplay,
+					Flags: []cli.Flag{
+						&cli.Float64Flag{
+							Name:        "speed",
+							Usage:       "The factor by which to speed up the playback",
+							Value:       1,
+							Destination: &app.replaySpeed,
+						},
+						&cli.DurationFlag{
+							Name:        "idle-time-limit",
+							Usage:       "The maximum time to pause between outputs",
+							Destination: &app.replayIdleTimeLimit,
+						},
+					},
+				},
 			},
 		},
 		{
@@ -923,6 +1040,144 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 				},
 			},
 		},
+		{
+			Name:        "cache",
+			Usage:       "Inspect and prune the Earthly build cache",
+			Description: "Inspect and prune the Earthly build cache",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "du",
+					Usage:     "Show the disk usage of the build cache",
+					UsageText: "earthly [options] cache du [--filter <key>=<value>]",
+					Action:    app.actionCacheDu,
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:  "filter",
+							Usage: wrap("Only show cache records matching the filter (e.g. type=exec.cachemount)", "Valid keys are id, parent, description, type, inuse, mutable, immutable, shared and private"),
+							Value: &app.cacheFilters,
+						},
+					},
+				},
+				{
+					Name:      "prune",
+					Usage:     "Selectively prune the build cache",
+					UsageText: "earthly [options] cache prune [--all|-a] [--keep-duration <duration>] [--keep-storage <size>] [--filter <key>=<value>]",
+					Action:    app.actionCachePrune,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:        "all",
+							Aliases:     []string{"a"},
+							Usage:       "Prune all cache, including records that would otherwise be kept",
+							Destination: &app.pruneAll,
+						},
+						&cli.DurationFlag{
+							Name:        "keep-duration",
+							Usage:       "Keep cache records which have been used within the given duration (e.g. 72h)",
+							Destination: &app.pruneKeepDuration,
+						},
+						&cli.StringFlag{
+							Name:        "keep-storage",
+							Usage:       "Keep the most recently used cache records, up to the given total size (e.g. 20GB)",
+							Destination: &app.pruneKeepStorage,
+						},
+						&cli.StringSliceFlag{
+							Name:  "filter",
+							Usage: wrap("Only prune cache records matching the filter (e.g. type=exec.cachemount)", "Valid keys are id, parent, description, type, inuse, mutable, immutable, shared and private"),
+							Value: &app.cacheFilters,
+						},
+					},
+				},
+				{
+					Name:  "mounts",
+					Usage: "Manage the caches created by RUN --mount=type=cache",
+					Subcommands: []*cli.Command{
+						{
+							Name:      "ls",
+							Usage:     "List cache mounts",
+							UsageText: "earthly [options] cache mounts ls",
+							Action:    app.actionCacheMountsList,
+						},
+						{
+							Name:      "rm",
+							Usage:     "Remove cache mounts by id, by owning target or by mount path",
+							UsageText: "earthly [options] cache mounts rm <id|target> [<id|target> ...]",
+							Action:    app.actionCacheMountsRemove,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:        "lock",
+			Usage:       "Pin the images and remote Earthfiles used by a target in Earthfile.lock",
+			Description: "Resolves the image digests and remote Earthfile commits referenced by the target, and records them in an Earthfile.lock next to its Earthfile; subsequent builds use the pinned versions",
+			UsageText:   "earthly [options] lock <target-ref>",
+			Action:      app.actionLock,
+		},
+		{
+			Name:        "prefetch",
+			Usage:       "Pull the images and remote Earthfiles used by a target, for offline builds",
+			Description: "Pulls all base images and clones all remote Earthfiles reachable from the target into the buildkit cache, without building it, such that the target can later be built with --offline",
+			UsageText:   "earthly [options] prefetch <target-ref>",
+			Action:      app.actionPrefetch,
+		},
+		{
+			Name:        "shell",
+			Usage:       "Open an interactive shell in the final state of a target",
+			Description: "Builds the target, then opens an interactive shell in its final state, with its environment, working directory and user; changes made within the shell are discarded",
+			UsageText:   "earthly [options] shell <target-ref> [--<build-arg-key>=<build-arg-value>...]",
+			Action:      app.actionShell,
+		},
+		{
+			Name:        "run",
+			Usage:       "Build a target and run its image locally",
+			Description: "Builds the target, loads its image into the container frontend and runs it, publishing the ports it EXPOSEs and streaming its logs; the container is stopped and removed on exit",
+			UsageText:   "earthly [options] run [--env <key>[=<value>]] [--volume <host-path>:<container-path>[:ro]] <target-ref> [--<build-arg-key>=<build-arg-value>...] [-- <args>...]",
+			Action:      app.actionRun,
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:        "env",
+					Aliases:     []string{"e"},
+					Usage:       "Set an environment variable in the container; the value is taken from the environment if omitted",
+					Destination: &app.runEnvs,
+				},
+				&cli.StringSliceFlag{
+					Name:        "volume",
+					Aliases:     []string{"v"},
+					Usage:       "Bind mount a host path in the container, as <host-path>:<container-path>[:ro]",
+					Destination: &app.runVolumes,
+				},
+			},
+		},
+		{
+			Name:        "export-dockerfile",
+			Usage:       "Export a target as a standalone multi-stage Dockerfile",
+			Description: "Lowers the target, together with the targets it inherits from or copies artifacts from, into a multi-stage Dockerfile which can be built with docker build from the directory of the Earthfile",
+			UsageText:   "earthly [options] export-dockerfile [--dockerfile <path>] <target-ref> [--<build-arg-key>=<build-arg-value>...]",
+			Action:      app.actionExportDockerfile,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "dockerfile",
+					Usage:       "Path to dockerfile output, or - for stdout",
+					Value:       "-",
+					Destination: &app.exportDockerfilePath,
+				},
+			},
+		},
+		{
+			Name:        "doctor",
+			Usage:       "Diagnose common problems with the Earthly setup",
+			Description: "Runs a series of checks against the container frontend, buildkitd and the Earthly configuration, and suggests fixes for any problems found",
+			UsageText:   "earthly [options] doctor [--json]",
+			Action:      app.actionDoctor,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "json",
+					Usage:       "Output the report as JSON, e.g. for attaching to a support ticket",
+					Destination: &app.doctorJSON,
+				},
+			},
+		},
 		{
 			Name:   "config",
 			Usage:  "Edits your Earthly configuration file",
@@ -965,6 +1220,19 @@ Set up a whole custom git repository for a server called example.com, using a si
 					Usage:       "Print the changed config file to the console instead of writing it out",
 					Destination: &app.configDryRun,
 				},
+				&cli.BoolFlag{
+					Name:        "show-effective",
+					Usage:       "Print the effective value of every config key, and where it was set, instead of changing the config",
+					Destination: &app.configShowEffective,
+				},
+			},
+			Subcommands: []*cli.Command{
+				{
+					Name:      "schema",
+					Usage:     "Print a JSON Schema of the config file, for validation and completion in editors",
+					UsageText: "earthly [options] config schema",
+					Action:    app.actionConfigSchema,
+				},
 			},
 		},
 	}
@@ -997,18 +1265,43 @@ func (app *earthlyApp) before(context *cli.Context) error {
 		return fmt.Errorf("buildkit-container-name is not currently supported")
 	}
 
-	var yamlData []byte
-	var err error
+	var layers []config.Layer
 	if app.configPath != "" {
-		yamlData, err = config.ReadConfigFile(app.configPath, context.IsSet("config"))
+		yamlData, err := config.ReadConfigFile(app.configPath, context.IsSet("config"))
 		if err != nil {
 			return errors.Wrapf(err, "read config")
 		}
+		layers = append(layers, config.Layer{Origin: app.configPath, Data: yamlData})
+	}
+	projectConfigPath, err := config.FindProjectConfig(projectConfigDir(context.Args().Slice()), app.configPath)
+	if err != nil {
+		return errors.Wrap(err, "find project config")
+	}
+	if projectConfigPath != "" {
+		app.console.VerbosePrintf("loading project config values from %q\n", projectConfigPath)
+		yamlData, err := config.ReadConfigFile(projectConfigPath, true)
+		if err != nil {
+			return errors.Wrapf(err, "read project config")
+		}
+		layers = append(layers, config.Layer{Origin: projectConfigPath, Data: yamlData, Project: true})
+	}
+
+	for _, layer := range layers {
+		unknownKeys, err := config.UnknownKeys(layer.Data)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s", layer.Origin)
+		}
+		for _, k := range unknownKeys {
+			app.console.Warnf("Warning: %s:%d: unknown config key %s will be ignored", layer.Origin, k.Line, k.Key)
+		}
+	}
+	for _, name := range config.UnknownEnvOverrides(os.Environ()) {
+		app.console.Warnf("Warning: environment variable %s does not match any config key and will be ignored", name)
 	}
 
-	app.cfg, err = config.ParseConfigFile(yamlData)
+	app.cfg, app.cfgOrigins, err = config.ParseConfigLayers(layers, os.Environ())
 	if err != nil {
-		return errors.Wrapf(err, "failed to parse %s", app.configPath)
+		return err
 	}
 
 	if app.cfg.Git == nil {
@@ -1067,6 +1360,14 @@ func (app *earthlyApp) before(context *cli.Context) error {
 	}
 	app.buildkitdSettings.IPTables = app.cfg.Global.IPTables
 
+	app.buildkitdSettings.ShellRepeaterPort = app.cfg.Global.ShellRepeaterPort
+	if app.buildkitdSettings.ShellRepeaterPort == 0 {
+		app.buildkitdSettings.ShellRepeaterPort = debuggercommon.DefaultPort
+	}
+	if app.buildkitdSettings.ShellRepeaterPort < 0 || app.buildkitdSettings.ShellRepeaterPort > 65535 {
+		return errors.Errorf("invalid shell_repeater_port %d", app.buildkitdSettings.ShellRepeaterPort)
+	}
+
 	// Make a small attempt to check if we are not bootstrapped. If not, then do that before we do anything else.
 	isBootstrapCmd := false
 	for _, f := range context.Args().Slice() {
@@ -1261,6 +1562,7 @@ func (app *earthlyApp) processDeprecatedCommandOptions(context *cli.Context, cfg
 	} else {
 		app.buildkitdSettings.CacheSizeMb = cfg.Global.BuildkitCacheSizeMb
 	}
+	app.buildkitdSettings.DindCacheSizeMb = cfg.Global.DindCacheSizeMb
 
 	if cfg.Global.DebuggerPort != config.DefaultDebuggerPort {
 		app.console.Warnf("Warning: specifying the port using the debugger-port setting is deprecated. Set it in ~/.earthly/config.yml as part of the debugger_host variable; see https://docs.earthly.dev/earthly-config for reference.\n")
@@ -1534,6 +1836,10 @@ func (app *earthlyApp) run(ctx context.Context, args []string) int {
 			return 6
 		} else if isInterpereterError {
 			app.console.Warnf("Error: %s\n", ie.Error())
+			if ie.SourceLocation != nil && buildErr == nil {
+				// Failed commands are annotated as they are reported by the build.
+				app.console.PrintErrorAnnotation(ie.SourceLocation.File, ie.SourceLocation.StartLine, ie.Error())
+			}
 		} else {
 			app.console.Warnf("Error: %v\n", err)
 		}
@@ -2422,6 +2728,23 @@ func (app *earthlyApp) actionDebugAst(c *cli.Context) error {
 	return nil
 }
 
+func (app *earthlyApp) actionDebugReplay(c *cli.Context) error {
+	app.commandName = "debugReplay"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return errors.Wrapf(err, "open recording %s", c.Args().First())
+	}
+	defer f.Close()
+	_, err = asciicast.Replay(c.Context, f, os.Stdout, asciicast.ReplayOpt{
+		Speed:         app.replaySpeed,
+		IdleTimeLimit: app.replayIdleTimeLimit,
+	})
+	return err
+}
+
 func (app *earthlyApp) actionPrune(c *cli.Context) error {
 	app.commandName = "prune"
 	if c.NArg() != 0 {
@@ -2445,10 +2768,286 @@ func (app *earthlyApp) actionPrune(c *cli.Context) error {
 	if app.pruneAll {
 		opts = append(opts, client.PruneAll)
 	}
+	return app.pruneCache(c.Context, bkClient, opts...)
+}
+
+func (app *earthlyApp) actionDoctor(c *cli.Context) error {
+	app.commandName = "doctor"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+	checks := doctor.Run(c.Context, doctor.Opt{
+		Frontend:      app.containerFrontend,
+		ContainerName: app.containerName,
+		Image:         app.buildkitdImage,
+		Settings:      app.buildkitdSettings,
+	})
+	report := &doctor.Report{
+		Version:  Version,
+		Platform: getPlatform(),
+		Checks:   checks,
+	}
+	if app.doctorJSON {
+		err := doctor.PrintJSON(os.Stdout, report)
+		if err != nil {
+			return err
+		}
+	} else {
+		doctor.PrintText(os.Stdout, report)
+	}
+	if doctor.HasErrors(checks) {
+		return errors.New("one or more checks failed")
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionCacheDu(c *cli.Context) error {
+	app.commandName = "cacheDu"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+	filters, err := parseCacheFilters(app.cacheFilters.Value())
+	if err != nil {
+		return err
+	}
+
+	bkClient, err := buildkitd.NewClient(c.Context, app.console, app.buildkitdImage, app.containerName, app.containerFrontend, app.buildkitdSettings)
+	if err != nil {
+		return errors.Wrap(err, "cache du new buildkitd client")
+	}
+	defer bkClient.Close()
+
+	records, err := bkClient.DiskUsage(c.Context, client.WithFilter(filters))
+	if err != nil {
+		return errors.Wrap(err, "buildkit disk usage")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tTYPE\tSIZE\tRECLAIMABLE\tLAST USED\tDESCRIPTION\n")
+	for _, r := range records {
+		lastUsed := "never"
+		if r.LastUsedAt != nil {
+			lastUsed = humanize.Time(*r.LastUsedAt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n",
+			shortCacheID(r.ID), r.RecordType, humanize.Bytes(uint64(r.Size)), !r.InUse, lastUsed, r.Description)
+	}
+	w.Flush()
+
+	fmt.Println()
+	printCacheUsageByType(os.Stdout, records)
+	return nil
+}
+
+func (app *earthlyApp) actionCachePrune(c *cli.Context) error {
+	app.commandName = "cachePrune"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+	filters, err := parseCacheFilters(app.cacheFilters.Value())
+	if err != nil {
+		return err
+	}
+	var keepBytes uint64
+	if app.pruneKeepStorage != "" {
+		keepBytes, err = humanize.ParseBytes(app.pruneKeepStorage)
+		if err != nil {
+			return errors.Wrapf(err, "parse --keep-storage %q", app.pruneKeepStorage)
+		}
+	}
+
+	var opts []client.PruneOption
+	if app.pruneAll {
+		opts = append(opts, client.PruneAll)
+	}
+	if app.pruneKeepDuration != 0 || keepBytes != 0 {
+		opts = append(opts, client.WithKeepOpt(app.pruneKeepDuration, int64(keepBytes)))
+	}
+	if len(filters) > 0 {
+		opts = append(opts, client.WithFilter(filters))
+	}
+
+	bkClient, err := buildkitd.NewClient(c.Context, app.console, app.buildkitdImage, app.containerName, app.containerFrontend, app.buildkitdSettings)
+	if err != nil {
+		return errors.Wrap(err, "cache prune new buildkitd client")
+	}
+	defer bkClient.Close()
+	return app.pruneCache(c.Context, bkClient, opts...)
+}
+
+func (app *earthlyApp) actionCacheMountsList(c *cli.Context) error {
+	app.commandName = "cacheMountsList"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+
+	bkClient, err := buildkitd.NewClient(c.Context, app.console, app.buildkitdImage, app.containerName, app.containerFrontend, app.buildkitdSettings)
+	if err != nil {
+		return errors.Wrap(err, "cache mounts ls new buildkitd client")
+	}
+	defer bkClient.Close()
+
+	records, err := bkClient.DiskUsage(c.Context, client.WithFilter([]string{cacheMountFilter}))
+	if err != nil {
+		return errors.Wrap(err, "buildkit disk usage")
+	}
+
+	cacheMounts := app.readCacheMountsIndex(records)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tTARGET\tPATH\tSIZE\tIN USE\tLAST USED\n")
+	for _, r := range records {
+		lastUsed := "never"
+		if r.LastUsedAt != nil {
+			lastUsed = humanize.Time(*r.LastUsedAt)
+		}
+		mount := cacheMounts.Lookup(r.Description)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n",
+			mount.ID, cacheMountOwner(mount), mount.Path, humanize.Bytes(uint64(r.Size)), r.InUse, lastUsed)
+	}
+	w.Flush()
+	return nil
+}
+
+func (app *earthlyApp) actionCacheMountsRemove(c *cli.Context) error {
+	app.commandName = "cacheMountsRemove"
+	if c.NArg() == 0 {
+		return errors.New("no cache mount id or target provided")
+	}
+
+	bkClient, err := buildkitd.NewClient(c.Context, app.console, app.buildkitdImage, app.containerName, app.containerFrontend, app.buildkitdSettings)
+	if err != nil {
+		return errors.Wrap(err, "cache mounts rm new buildkitd client")
+	}
+	defer bkClient.Close()
+
+	records, err := bkClient.DiskUsage(c.Context, client.WithFilter([]string{cacheMountFilter}))
+	if err != nil {
+		return errors.Wrap(err, "buildkit disk usage")
+	}
+	cacheMounts := app.readCacheMountsIndex(records)
+	matched, err := matchCacheMounts(records, cacheMounts, c.Args().Slice())
+	if err != nil {
+		return err
+	}
+
+	var filters []string
+	for _, r := range matched {
+		if r.InUse {
+			mount := cacheMounts.Lookup(r.Description)
+			app.console.Warnf("Cache mount %s of %s is currently in use and will not be removed\n", mount.ID, cacheMountOwner(mount))
+			continue
+		}
+		filters = append(filters, fmt.Sprintf("%s,id==%s", cacheMountFilter, r.ID))
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return app.pruneCache(c.Context, bkClient, client.PruneAll, client.WithFilter(filters))
+}
+
+func cacheMountsIndexPath() string {
+	return filepath.Join(cliutil.GetEarthlyDir(), cacheMountsIndexFileName)
+}
+
+// readCacheMountsIndex reads the cache mounts index, dropping the entries of cache mounts which no
+// longer exist. The index is only used to describe the cache mounts, so a broken index is replaced by
+// an empty one rather than failing the command.
+func (app *earthlyApp) readCacheMountsIndex(records []*client.UsageInfo) *earthfile2llb.CacheMountsIndex {
+	cacheMounts, err := earthfile2llb.ReadCacheMountsIndex(cacheMountsIndexPath())
+	if err != nil {
+		app.console.Warnf("Warning: %s\n", err.Error())
+		return earthfile2llb.NewCacheMountsIndex()
+	}
+	var descriptions []string
+	for _, r := range records {
+		descriptions = append(descriptions, r.Description)
+	}
+	cacheMounts.Retain(descriptions)
+	_, err = cliutil.GetOrCreateEarthlyDir()
+	if err == nil {
+		err = cacheMounts.Write(cacheMountsIndexPath())
+	}
+	if err != nil {
+		app.console.Warnf("Warning: could not write the cache mounts index: %s\n", err.Error())
+	}
+	return cacheMounts
+}
+
+// mergeCacheMountsIndex adds the cache mounts recorded by a build to the cache mounts index.
+func (app *earthlyApp) mergeCacheMountsIndex(cacheMounts *earthfile2llb.CacheMountsIndex) {
+	_, err := cliutil.GetOrCreateEarthlyDir()
+	if err == nil {
+		err = cacheMounts.Merge(cacheMountsIndexPath())
+	}
+	if err != nil {
+		app.console.Warnf("Warning: could not write the cache mounts index: %s\n", err.Error())
+	}
+}
+
+// cacheMountFilter is the buildkit filter which selects the records created by RUN --mount=type=cache.
+const cacheMountFilter = "type==" + string(client.UsageRecordTypeCacheMount)
+
+// cacheMountOwner returns the target which owns the cache mount, for display purposes.
+func cacheMountOwner(mount earthfile2llb.CacheMountRecord) string {
+	switch {
+	case mount.Global:
+		return "(global)"
+	case mount.Target == "":
+		return "-"
+	default:
+		return mount.Target
+	}
+}
+
+// matchCacheMounts returns the cache mount records referenced by each arg, which may either be
+// the id of the mount (as given via RUN --mount id=), the target owning the mount, or the absolute
+// path the cache is mounted at. All cache mounts matching an arg are returned.
+func matchCacheMounts(records []*client.UsageInfo, cacheMounts *earthfile2llb.CacheMountsIndex, args []string) ([]*client.UsageInfo, error) {
+	var matched []*client.UsageInfo
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		found := false
+		for _, r := range records {
+			if !cacheMountMatches(cacheMounts.Lookup(r.Description), arg) {
+				continue
+			}
+			found = true
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				matched = append(matched, r)
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("no cache mount found for %q", arg)
+		}
+	}
+	return matched, nil
+}
+
+func cacheMountMatches(mount earthfile2llb.CacheMountRecord, arg string) bool {
+	switch {
+	case mount.ID == arg:
+		return true
+	case path.IsAbs(arg):
+		return path.Clean(mount.Path) == path.Clean(arg)
+	default:
+		// Global cache mounts are shared between targets, and are therefore not owned by the
+		// target which happened to create them.
+		return !mount.Global && mount.Target == arg
+	}
+}
+
+// pruneCache issues a prune request to buildkit and reports progress as
+// records are removed.
+func (app *earthlyApp) pruneCache(ctx context.Context, bkClient *client.Client, opts ...client.PruneOption) error {
+	console := app.console.WithPrefix("prune")
+	var pruned []*client.UsageInfo
+	var freed uint64
 	ch := make(chan client.UsageInfo, 1)
-	eg, ctx := errgroup.WithContext(c.Context)
+	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
-		err = bkClient.Prune(ctx, ch, opts...)
+		err := bkClient.Prune(ctx, ch, opts...)
 		if err != nil {
 			return errors.Wrap(err, "buildkit prune")
 		}
@@ -2456,25 +3055,110 @@ func (app *earthlyApp) actionPrune(c *cli.Context) error {
 		return nil
 	})
 	eg.Go(func() error {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		lastReported := 0
 		for {
 			select {
-			case _, ok := <-ch:
+			case info, ok := <-ch:
 				if !ok {
 					return nil
 				}
-				// TODO: Print some progress info.
+				rec := info
+				pruned = append(pruned, &rec)
+				freed += uint64(info.Size)
+				console.VerbosePrintf("Removed %s %s (%s)\n", info.RecordType, shortCacheID(info.ID), humanize.Bytes(uint64(info.Size)))
+			case <-ticker.C:
+				if len(pruned) != lastReported {
+					lastReported = len(pruned)
+					console.Printf("Pruned %d records so far, %s freed\n", len(pruned), humanize.Bytes(freed))
+				}
 			case <-ctx.Done():
 				return nil
 			}
 		}
 	})
-	err = eg.Wait()
+	err := eg.Wait()
 	if err != nil {
 		return errors.Wrap(err, "err group")
 	}
+	console.Printf("Pruned %d records, %s freed in total\n", len(pruned), humanize.Bytes(freed))
+	if app.verbose && len(pruned) > 0 {
+		printCacheUsageByType(os.Stderr, pruned)
+	}
 	return nil
 }
 
+// parseCacheFilters converts key=value filters into the key==value form
+// expected by buildkit. All filters are combined into a single expression,
+// such that a record must match every one of them.
+func parseCacheFilters(filters []string) ([]string, error) {
+	var parts []string
+	for _, f := range filters {
+		if strings.Contains(f, "==") || strings.Contains(f, "!=") || strings.Contains(f, "~=") {
+			parts = append(parts, f)
+			continue
+		}
+		kv := strings.SplitN(f, "=", 2)
+		if kv[0] == "" {
+			return nil, errors.Errorf("invalid cache filter %q", f)
+		}
+		if len(kv) == 1 {
+			// Boolean keys, such as inuse or shared.
+			parts = append(parts, kv[0])
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s==%s", kv[0], kv[1]))
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	return []string{strings.Join(parts, ",")}, nil
+}
+
+func printCacheUsageByType(out io.Writer, records []*client.UsageInfo) {
+	type typeUsage struct {
+		count       int
+		size        int64
+		reclaimable int64
+	}
+	usage := make(map[client.UsageRecordType]*typeUsage)
+	var types []string
+	var total, reclaimable int64
+	for _, r := range records {
+		u, ok := usage[r.RecordType]
+		if !ok {
+			u = &typeUsage{}
+			usage[r.RecordType] = u
+			types = append(types, string(r.RecordType))
+		}
+		u.count++
+		u.size += r.Size
+		total += r.Size
+		if !r.InUse {
+			u.reclaimable += r.Size
+			reclaimable += r.Size
+		}
+	}
+	sort.Strings(types)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TYPE\tRECORDS\tSIZE\tRECLAIMABLE\n")
+	for _, t := range types {
+		u := usage[client.UsageRecordType(t)]
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", t, u.count, humanize.Bytes(uint64(u.size)), humanize.Bytes(uint64(u.reclaimable)))
+	}
+	fmt.Fprintf(w, "total\t%d\t%s\t%s\n", len(records), humanize.Bytes(uint64(total)), humanize.Bytes(uint64(reclaimable)))
+	w.Flush()
+}
+
+func shortCacheID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
 func (app *earthlyApp) actionDocker(c *cli.Context) error {
 	app.commandName = "docker"
 
@@ -2485,7 +3169,7 @@ func (app *earthlyApp) actionDocker(c *cli.Context) error {
 	}
 	defer os.Remove(earthfilePath)
 
-	err := docker2earthly.Docker2Earthly(app.dockerfilePath, earthfilePath, app.earthfileFinalImage)
+	err := docker2earthly.Docker2Earthly(app.dockerfilePath, earthfilePath, app.earthfileFinalImage, app.console.Warnf)
 	if err != nil {
 		return err
 	}
@@ -2503,7 +3187,7 @@ func (app *earthlyApp) actionDocker(c *cli.Context) error {
 
 func (app *earthlyApp) actionDocker2Earthly(c *cli.Context) error {
 	app.commandName = "docker2earthly"
-	err := docker2earthly.Docker2Earthly(app.dockerfilePath, app.earthfilePath, app.earthfileFinalImage)
+	err := docker2earthly.Docker2Earthly(app.dockerfilePath, app.earthfilePath, app.earthfileFinalImage, app.console.Warnf)
 	if err != nil {
 		return err
 	}
@@ -2513,6 +3197,21 @@ func (app *earthlyApp) actionDocker2Earthly(c *cli.Context) error {
 
 func (app *earthlyApp) actionConfig(c *cli.Context) error {
 	app.commandName = "config"
+	if app.configShowEffective {
+		if c.NArg() != 0 {
+			return errors.New("--show-effective does not take any arguments")
+		}
+		values, err := config.EffectiveValues(app.cfg, app.cfgOrigins)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tORIGIN")
+		for _, v := range values {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, v.Value, v.Origin)
+		}
+		return w.Flush()
+	}
 	if c.NArg() != 2 {
 		return errors.New("invalid number of arguments provided")
 	}
@@ -2541,6 +3240,342 @@ func (app *earthlyApp) actionConfig(c *cli.Context) error {
 	return nil
 }
 
+func (app *earthlyApp) actionConfigSchema(c *cli.Context) error {
+	app.commandName = "configSchema"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+	schema, err := config.JSONSchema()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(schema))
+	return nil
+}
+
+func (app *earthlyApp) actionLock(c *cli.Context) error {
+	app.commandName = "lock"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	target, err := domain.ParseTarget(c.Args().First())
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", c.Args().First())
+	}
+	if target.IsRemote() {
+		return errors.Errorf("cannot lock remote target %s; lock files are only supported for local Earthfiles", target)
+	}
+	app.updateLock = true
+	app.lockOnly = true
+	app.imageMode = false
+	app.artifactMode = false
+	return app.actionBuildImp(c, nil, []string{c.Args().First()})
+}
+
+func (app *earthlyApp) actionPrefetch(c *cli.Context) error {
+	app.commandName = "prefetch"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	if app.offline {
+		return errors.New("cannot prefetch with --offline")
+	}
+	app.prefetch = true
+	app.imageMode = false
+	app.artifactMode = false
+	return app.actionBuildImp(c, nil, []string{c.Args().First()})
+}
+
+func (app *earthlyApp) actionShell(c *cli.Context) error {
+	app.commandName = "shell"
+	if app.ci {
+		return errors.New("unable to use --ci flag in combination with the shell command")
+	}
+	if !termutil.IsTTY() {
+		return errors.New("A tty-terminal must be present in order to open an interactive shell")
+	}
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(nonFlagArgs) != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	app.interactiveShell = true
+	app.noOutput = true
+	app.imageMode = false
+	app.artifactMode = false
+	return app.actionBuildImp(c, flagArgs, nonFlagArgs)
+}
+
+func (app *earthlyApp) actionRun(c *cli.Context) error {
+	app.commandName = "run"
+	args := c.Args().Slice()
+	for i, arg := range args {
+		if arg == "--" {
+			app.runArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(args)
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(args, " "))
+	}
+	if len(nonFlagArgs) != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	app.runImage = true
+	app.noOutput = true
+	app.imageMode = false
+	app.artifactMode = false
+	return app.actionBuildImp(c, flagArgs, nonFlagArgs)
+}
+
+// shellRepeaterAddr returns the address at which builds reach the shell repeater. For a buildkitd
+// managed by earthly, whose IP is known, that is the port the shell repeater listens on within its
+// container. For a remote buildkitd, it is the same address as for this client.
+func shellRepeaterAddr(bkIP string, debuggerURL *url.URL, port int) string {
+	if bkIP == "" {
+		return debuggerURL.Host
+	}
+	return fmt.Sprintf("%s:%d", bkIP, port)
+}
+
+// runTargetImage loads the image of the target into the container frontend, and runs it until it
+// exits or the context is done.
+func (app *earthlyApp) runTargetImage(ctx context.Context, b *builder.Builder, mts *states.MultiTarget) error {
+	saveImage := mts.Final.LastSaveImage()
+	imageName := saveImage.DockerTag
+	if imageName == "" {
+		imageName = fmt.Sprintf("earthly-run-%s:latest", containerNameSafe(mts.Final.Target.Target))
+	}
+	envs, err := parseRunEnvs(app.runEnvs.Value(), os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	mounts, err := parseRunVolumes(app.runVolumes.Value())
+	if err != nil {
+		return err
+	}
+	ports, err := exposedPorts(saveImage.Image)
+	if err != nil {
+		return err
+	}
+
+	tarFile, err := ioutil.TempFile("", "earthly-run-*.tar")
+	if err != nil {
+		return errors.Wrap(err, "create temp file for image")
+	}
+	tarFile.Close()
+	defer os.Remove(tarFile.Name())
+	err = b.MakeImageAsTarBuilderFun()(ctx, mts, imageName, tarFile.Name())
+	if err != nil {
+		return errors.Wrapf(err, "build image %s", imageName)
+	}
+	r, err := os.Open(tarFile.Name())
+	if err != nil {
+		return errors.Wrapf(err, "open %s", tarFile.Name())
+	}
+	defer r.Close()
+	err = app.containerFrontend.ImageLoad(ctx, r)
+	if err != nil {
+		return errors.Wrapf(err, "load image %s", imageName)
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return errors.Wrap(err, "generate container name")
+	}
+	containerName := fmt.Sprintf("earthly-run-%s-%x", containerNameSafe(mts.Final.Target.Target), suffix)
+	for _, p := range ports {
+		app.console.Printf("Publishing port %d/%s on port %d of the host\n", p.ContainerPort, p.Protocol, p.HostPort)
+	}
+	// The container may have been created even if running it fails, so the cleanup is registered first.
+	var runErr error
+	defer func() {
+		// Use a fresh context, as ctx is canceled on Ctrl-C.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := app.containerFrontend.ContainerStop(cleanupCtx, 10, containerName)
+		if err != nil {
+			app.console.VerbosePrintf("failed to stop container %s: %s\n", containerName, err.Error())
+		}
+		err = app.containerFrontend.ContainerRemove(cleanupCtx, true, containerName)
+		if err != nil {
+			if runErr != nil {
+				// The container was most likely never created.
+				app.console.VerbosePrintf("failed to remove container %s: %s\n", containerName, err.Error())
+			} else {
+				app.console.Warnf("Warning: failed to remove container %s: %s\n", containerName, err.Error())
+			}
+		}
+	}()
+	runErr = app.containerFrontend.ContainerRun(ctx, containerutil.ContainerRun{
+		NameOrID:      containerName,
+		ImageRef:      imageName,
+		Envs:          envs,
+		Mounts:        mounts,
+		Ports:         ports,
+		ContainerArgs: app.runArgs,
+	})
+	if runErr != nil {
+		return errors.Wrapf(runErr, "run image %s", imageName)
+	}
+	app.console.Printf("Running %s in container %s; press Ctrl-C to stop it\n", imageName, containerName)
+
+	err = app.containerFrontend.ContainerLogsFollow(ctx, containerName, os.Stdout, os.Stderr)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "follow logs of container %s", containerName)
+	}
+	infos, err := app.containerFrontend.ContainerInfo(ctx, containerName)
+	if err != nil {
+		return errors.Wrapf(err, "get info of container %s", containerName)
+	}
+	if info, ok := infos[containerName]; ok && info.ExitCode != 0 {
+		return errors.Errorf("container %s exited with code %d", containerName, info.ExitCode)
+	}
+	return nil
+}
+
+// parseRunEnvs parses <key>=<value> environment variables. The value of <key> alone is looked up.
+func parseRunEnvs(envs []string, lookupEnv func(string) (string, bool)) (containerutil.EnvMap, error) {
+	envMap := containerutil.EnvMap{}
+	for _, env := range envs {
+		parts := strings.SplitN(env, "=", 2)
+		if parts[0] == "" {
+			return nil, errors.Errorf("invalid env %s", env)
+		}
+		if len(parts) == 2 {
+			envMap[parts[0]] = parts[1]
+			continue
+		}
+		value, ok := lookupEnv(parts[0])
+		if !ok {
+			return nil, errors.Errorf("env %s is not set", parts[0])
+		}
+		envMap[parts[0]] = value
+	}
+	return envMap, nil
+}
+
+// parseRunVolumes parses <host-path>:<container-path>[:ro] volumes.
+func parseRunVolumes(volumes []string) (containerutil.MountOpt, error) {
+	var mounts containerutil.MountOpt
+	for _, volume := range volumes {
+		parts := strings.Split(volume, ":")
+		readOnly := false
+		if len(parts) == 3 {
+			if parts[2] != "ro" && parts[2] != "rw" {
+				return nil, errors.Errorf("invalid volume %s; the only supported options are ro and rw", volume)
+			}
+			readOnly = parts[2] == "ro"
+			parts = parts[:2]
+		}
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid volume %s; expected <host-path>:<container-path>[:ro]", volume)
+		}
+		source, err := filepath.Abs(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "get absolute path of %s", parts[0])
+		}
+		mounts = append(mounts, containerutil.Mount{
+			Type:     containerutil.MountBind,
+			Source:   source,
+			Dest:     parts[1],
+			ReadOnly: readOnly,
+		})
+	}
+	return mounts, nil
+}
+
+// exposedPorts publishes each port EXPOSEd by the image on the same port of the host.
+func exposedPorts(img *image.Image) (containerutil.PortOpt, error) {
+	if img == nil {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(img.Config.ExposedPorts))
+	for k := range img.Config.ExposedPorts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var ports containerutil.PortOpt
+	for _, k := range keys {
+		portStr, protocol := k, containerutil.ProtocolTCP
+		if i := strings.Index(k, "/"); i != -1 {
+			portStr, protocol = k[:i], containerutil.ProtocolType(k[i+1:])
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, errors.Errorf("invalid exposed port %s", k)
+		}
+		ports = append(ports, containerutil.Port{
+			HostPort:      port,
+			ContainerPort: port,
+			Protocol:      protocol,
+		})
+	}
+	return ports, nil
+}
+
+// containerNameSafe replaces the characters which are not allowed in container names.
+func containerNameSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, name)
+}
+
+func (app *earthlyApp) actionExportDockerfile(c *cli.Context) error {
+	app.commandName = "export-dockerfile"
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(nonFlagArgs) != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	target, err := domain.ParseTarget(nonFlagArgs[0])
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", nonFlagArgs[0])
+	}
+	buildArgs := append([]string{}, app.buildArgs.Value()...)
+	buildArgs = append(buildArgs, flagArgs...)
+	overridingVars, err := variables.ParseCommandLineArgs(buildArgs)
+	if err != nil {
+		return errors.Wrap(err, "parse build args")
+	}
+	dockerfile, warnings, err := earthfile2dockerfile.Export(c.Context, target, earthfile2dockerfile.Opt{
+		Console:        app.console,
+		OverridingVars: overridingVars,
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		app.console.Warnf("Warning: %s\n", w)
+	}
+	if app.exportDockerfilePath == "-" {
+		_, err = os.Stdout.Write(dockerfile)
+		return errors.Wrap(err, "write dockerfile")
+	}
+	err = ioutil.WriteFile(app.exportDockerfilePath, dockerfile, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write dockerfile %s", app.exportDockerfilePath)
+	}
+	return nil
+}
+
 func (app *earthlyApp) actionBuild(c *cli.Context) error {
 	app.commandName = "build"
 
@@ -2555,10 +3590,25 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 		if app.interactiveDebugging {
 			return errors.New("unable to use --ci flag in combination with --interactive flag")
 		}
+		if len(app.breakpoints.Value()) > 0 {
+			return errors.New("unable to use --ci flag in combination with --break flag")
+		}
 	}
 	if !termutil.IsTTY() && app.interactiveDebugging {
 		return errors.New("A tty-terminal must be present in order to the --interactive flag")
 	}
+	if !termutil.IsTTY() && len(app.breakpoints.Value()) > 0 {
+		return errors.New("A tty-terminal must be present in order to use the --break flag")
+	}
+	if app.outputMode != outputModeInterleaved && app.outputMode != outputModeGrouped {
+		return errors.Errorf("invalid --output-mode %s: must be %s or %s", app.outputMode, outputModeInterleaved, outputModeGrouped)
+	}
+	if app.saveFailedImage != "" {
+		_, err := reference.ParseNormalizedNamed(app.saveFailedImage)
+		if err != nil {
+			return errors.Wrapf(err, "invalid --save-failed-image %s", app.saveFailedImage)
+		}
+	}
 	if app.imageMode && app.artifactMode {
 		return errors.New("both image and artifact modes cannot be active at the same time")
 	}
@@ -2679,11 +3729,27 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		return err
 	}
 
+	debuggerURL, err := url.Parse(app.debuggerHost)
+	if err != nil {
+		return errors.Wrap(err, "parse debugger host")
+	}
+	repeaterAddr := shellRepeaterAddr(bkIP, debuggerURL, app.buildkitdSettings.ShellRepeaterPort)
+	debuggerTLSConfig, debuggerCA, err := buildkitd.DebuggerTLSConfig(app.buildkitdSettings, debuggerURL.Hostname())
+	if err != nil {
+		return errors.Wrap(err, "debugger tls config")
+	}
+	debuggerSessionToken, err := debuggercommon.NewSessionToken()
+	if err != nil {
+		return err
+	}
 	debuggerSettings := debuggercommon.DebuggerSettings{
 		DebugLevelLogging: app.debug,
 		Enabled:           app.interactiveDebugging,
-		RepeaterAddr:      fmt.Sprintf("%s:8373", bkIP),
+		RepeaterAddr:      repeaterAddr,
 		Term:              os.Getenv("TERM"),
+		SessionToken:      debuggerSessionToken,
+		TLSCA:             string(debuggerCA),
+		TLSServerName:     debuggerURL.Hostname(),
 	}
 	if app.interactiveDebugging {
 		analytics.Count("features", "interactive-debugging")
@@ -2714,19 +3780,20 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 	defaultLocalDirs["earthly-cache"] = cacheLocalDir
 	buildContextProvider := provider.NewBuildContextProvider(app.console)
 	buildContextProvider.AddDirs(defaultLocalDirs)
-	attachables := []session.Attachable{
-		llbutil.NewSecretProvider(sc, secretsMap),
-		authprovider.NewDockerAuthProvider(os.Stderr),
-		buildContextProvider,
-		localhostProvider,
-	}
-
 	gitLookup := buildcontext.NewGitLookup(app.console, app.sshAuthSock)
 	err = app.updateGitLookupConfig(gitLookup)
 	if err != nil {
 		return err
 	}
 
+	attachables := []session.Attachable{
+		// The git lookup serves the credentials of https repositories, which are kept out of the clone URLs.
+		llbutil.NewSecretProvider(sc, secretsMap, gitLookup),
+		authprovider.NewDockerAuthProvider(os.Stderr),
+		buildContextProvider,
+		localhostProvider,
+	}
+
 	if app.sshAuthSock != "" {
 		ssh, err := sshprovider.NewSSHAgentProvider([]sshprovider.AgentConfig{{
 			Paths: []string{app.sshAuthSock},
@@ -2748,14 +3815,8 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		go func() {
 			// Dialing doesnt accept URLs, it accepts an address and a "network". These cannot be handled as URL schemes.
 			// Since Shellrepeater hard-codes TCP, we drop it here and log the error if we fail to connect.
-
-			u, err := url.Parse(app.debuggerHost)
-			if err != nil {
-				panic("debugger host was not a URL")
-			}
-
 			debugTermConsole := app.console.WithPrefix("internal-term")
-			err = terminal.ConnectTerm(c.Context, u.Host, debugTermConsole)
+			err := terminal.ConnectTerm(c.Context, debuggerURL.Host, debuggerTLSConfig, debuggerSessionToken, debugTermConsole, app.debuggerRecordPath)
 			if err != nil {
 				debugTermConsole.VerbosePrintf("unable to connect to terminal: %s", err.Error())
 			}
@@ -2775,6 +3836,9 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 	overridingVars = variables.CombineScopes(overridingVars, dotEnvVars)
 	imageResolveMode := llb.ResolveModePreferLocal
 	if app.pull {
+		if app.offline {
+			return errors.New("--pull cannot be used with --offline")
+		}
 		imageResolveMode = llb.ResolveModeForcePull
 	}
 
@@ -2803,6 +3867,10 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		}
 		localRegistryAddr = lrURL.Host
 	}
+	breakpoints, err := earthfile2llb.ParseBreakpoints(app.breakpoints.Value())
+	if err != nil {
+		return err
+	}
 	builderOpts := builder.Opt{
 		BkClient:               bkClient,
 		Console:                app.console,
@@ -2823,12 +3891,43 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		GitLookup:              gitLookup,
 		UseFakeDep:             !app.noFakeDep,
 		Strict:                 app.strict,
-		DisableNoOutputUpdates: app.interactiveDebugging,
+		DisableNoOutputUpdates: app.interactiveDebugging || app.interactiveShell || len(app.breakpoints.Value()) > 0,
 		ParallelConversion:     (app.conversionParllelism != 0),
 		Parallelism:            parallelism,
 		LocalRegistryAddr:      localRegistryAddr,
 		FeatureFlagOverrides:   app.featureFlagOverrides,
 		ContainerFrontend:      app.containerFrontend,
+		Breakpoints:            breakpoints,
+		SaveFailedImage:        app.saveFailedImage,
+		LogsDir:                app.logsDir,
+		// The dashboard needs the terminal, which is used by interactive sessions.
+		Dashboard: app.dashboard && termutil.IsTTY() && !app.ci && !app.interactiveDebugging &&
+			!app.interactiveShell && len(app.breakpoints.Value()) == 0 && app.outputMode != outputModeGrouped,
+		GroupedOutput: app.outputMode == outputModeGrouped,
+		CacheMounts:   earthfile2llb.NewCacheMountsIndex(),
+	}
+	defer app.mergeCacheMountsIndex(builderOpts.CacheMounts)
+	lockPath := ""
+	if !target.IsRemote() {
+		lockPath = filepath.Join(target.GetLocalPath(), lockfile.FileName)
+		builderOpts.Lock, err = lockfile.Read(lockPath, app.updateLock)
+		if err != nil {
+			return err
+		}
+	}
+	if builderOpts.Lock == nil && (app.offline || app.prefetch) {
+		builderOpts.Lock = lockfile.New()
+	}
+	prefetchPath := filepath.Join(cliutil.GetEarthlyDir(), prefetchIndexFileName)
+	if app.offline {
+		if app.updateLock {
+			return errors.New("--update-lock cannot be used with --offline")
+		}
+		prefetched, err := lockfile.Read(prefetchPath, false)
+		if err != nil {
+			return err
+		}
+		builderOpts.Lock.SetOffline(prefetched)
 	}
 	b, err := builder.NewBuilder(c.Context, builderOpts)
 	if err != nil {
@@ -2847,6 +3946,9 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		OnlyFinalTargetImages:      app.imageMode,
 		Platform:                   platformsSlice[0],
 		EnableGatewayClientLogging: app.debug,
+		OnlyConvert:                app.lockOnly,
+		Prefetch:                   app.prefetch,
+		InteractiveShell:           app.interactiveShell,
 
 		// explicitly set this to true at the top level (without granting the entitlements.EntitlementSecurityInsecure buildkit option),
 		// to differentiate between a user forgetting to run earthly -P, versus a remotely referening an earthfile that requires privileged.
@@ -2856,10 +3958,41 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		buildOpts.OnlyArtifact = &artifact
 		buildOpts.OnlyArtifactDestPath = destPath
 	}
-	_, err = b.BuildTarget(c.Context, target, buildOpts)
+	mts, err := b.BuildTarget(c.Context, target, buildOpts)
 	if err != nil {
+		if missing := builderOpts.Lock.Missing(); len(missing) > 0 {
+			return errors.Errorf(
+				"the following inputs are not available offline; run earthly prefetch %s while online:\n\t%s",
+				target, strings.Join(missing, "\n\t"))
+		}
 		return errors.Wrap(err, "build target")
 	}
+	if app.prefetch {
+		_, err = cliutil.GetOrCreateEarthlyDir()
+		if err != nil {
+			return errors.Wrap(err, "get earthly dir")
+		}
+		err = builderOpts.Lock.Merge(prefetchPath)
+		if err != nil {
+			return err
+		}
+	}
+	if builderOpts.Lock != nil && !target.IsRemote() {
+		if app.updateLock {
+			err = builderOpts.Lock.Write(lockPath)
+			if err != nil {
+				return err
+			}
+			app.console.Printf("Wrote %s\n", lockPath)
+		} else if builderOpts.Lock.Exists() {
+			for _, unpinned := range builderOpts.Lock.Unpinned() {
+				app.console.Warnf("Warning: %s is not pinned in %s; use --update-lock to pin it\n", unpinned, lockPath)
+			}
+		}
+	}
+	if app.runImage {
+		return app.runTargetImage(c.Context, b, mts)
+	}
 	return nil
 }
 
@@ -2953,6 +4086,31 @@ func processSecrets(secrets, secretFiles []string, dotEnvMap map[string]string)
 	return finalSecrets, nil
 }
 
+// projectConfigDir returns the directory from which to search upward for a project-level config file:
+// the directory of the Earthfile of the first local target or artifact among the args (which may follow
+// a subcommand name, as in earthly prefetch ./dir+target), or the working directory otherwise.
+func projectConfigDir(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || !strings.Contains(arg, "+") {
+			continue
+		}
+		localPath := ""
+		if target, err := domain.ParseTarget(arg); err == nil {
+			localPath = target.GetLocalPath()
+		} else if artifact, err := domain.ParseArtifact(arg); err == nil {
+			localPath = artifact.Target.GetLocalPath()
+		} else {
+			continue
+		}
+		if localPath == "" || !fileutil.DirExists(localPath) {
+			// A remote target, or one whose Earthfile does not exist.
+			return "."
+		}
+		return localPath
+	}
+	return "."
+}
+
 func defaultConfigPath() string {
 	earthlyDir := cliutil.GetEarthlyDir()
 	oldConfig := filepath.Join(earthlyDir, "config.yaml")
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/states/image"
+	"github.com/earthly/earthly/util/containerutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRunEnvs(t *testing.T) {
+	lookupEnv := func(key string) (string, bool) {
+		if key == "HOME" {
+			return "/home/me", true
+		}
+		return "", false
+	}
+
+	envs, err := parseRunEnvs([]string{"A=1", "B=x=y", "C=", "HOME"}, lookupEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, containerutil.EnvMap{"A": "1", "B": "x=y", "C": "", "HOME": "/home/me"}, envs)
+
+	_, err = parseRunEnvs([]string{"UNSET"}, lookupEnv)
+	assert.Error(t, err)
+	_, err = parseRunEnvs([]string{"=1"}, lookupEnv)
+	assert.Error(t, err)
+}
+
+func TestParseRunVolumes(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	mounts, err := parseRunVolumes([]string{"data:/data", "/etc/app:/etc/app:ro"})
+	assert.NoError(t, err)
+	assert.Equal(t, containerutil.MountOpt{
+		{Type: containerutil.MountBind, Source: filepath.Join(wd, "data"), Dest: "/data"},
+		{Type: containerutil.MountBind, Source: "/etc/app", Dest: "/etc/app", ReadOnly: true},
+	}, mounts)
+
+	for _, volume := range []string{"data", ":/data", "data:", "data:/data:z", "a:b:ro:x"} {
+		_, err := parseRunVolumes([]string{volume})
+		assert.Error(t, err, volume)
+	}
+}
+
+func TestExposedPorts(t *testing.T) {
+	img := image.NewImage()
+	img.Config.ExposedPorts = map[string]struct{}{"8080/tcp": {}, "53/udp": {}, "9000": {}}
+	ports, err := exposedPorts(img)
+	assert.NoError(t, err)
+	assert.Equal(t, containerutil.PortOpt{
+		{HostPort: 53, ContainerPort: 53, Protocol: containerutil.ProtocolType("udp")},
+		{HostPort: 8080, ContainerPort: 8080, Protocol: containerutil.ProtocolTCP},
+		{HostPort: 9000, ContainerPort: 9000, Protocol: containerutil.ProtocolTCP},
+	}, ports)
+
+	img.Config.ExposedPorts = map[string]struct{}{"http/tcp": {}}
+	_, err = exposedPorts(img)
+	assert.Error(t, err)
+}
+
+func TestContainerNameSafe(t *testing.T) {
+	assert.Equal(t, "my-server_v1.2", containerNameSafe("My+Server_v1.2"))
+}
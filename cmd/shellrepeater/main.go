@@ -2,20 +2,86 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
 
+	"github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/debugger/server"
 	"github.com/earthly/earthly/slog"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-const addr = "0.0.0.0:8373"
+// These are the certificates mounted for buildkitd, which the shell repeater reuses when TLS is enabled.
+const (
+	caPath   = "/etc/ca.pem"
+	certPath = "/etc/cert.pem"
+	keyPath  = "/etc/key.pem"
+)
+
+func getPort() (int, error) {
+	portStr, ok := os.LookupEnv("SHELLREPEATER_PORT")
+	if !ok || portStr == "" {
+		return common.DefaultPort, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, errors.Errorf("invalid SHELLREPEATER_PORT %q", portStr)
+	}
+	return port, nil
+}
+
+func getTLSConfig() (*tls.Config, error) {
+	if os.Getenv("BUILDKIT_TLS_ENABLED") != "true" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "load server certificate")
+	}
+	ca, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read CA certificate")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.Errorf("%s does not contain a PEM encoded certificate", caPath)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		// The debugger connects from within the build without a client certificate. The server
+		// requires one for terminal connections only.
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
 
 func main() {
 	logrus.SetLevel(logrus.DebugLevel)
 	ctx := context.Background()
 	log := slog.GetLogger(ctx).With("app", "shellrepeater")
 
-	x := server.NewServer(addr, log)
-	x.Start()
+	port, err := getPort()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	tlsConfig, err := getTLSConfig()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	x := server.NewServer(fmt.Sprintf("0.0.0.0:%d", port), tlsConfig, log)
+	err = x.Start()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
 }
@@ -20,6 +20,9 @@ const (
 	// DefaultLocalRegistryPort is the default user-facing port for the local registry used for exports.
 	DefaultLocalRegistryPort = 8371
 
+	// DefaultDindCacheSizeMb is the default size limit of the docker state kept by WITH DOCKER --cache-state.
+	DefaultDindCacheSizeMb = 10000
+
 	// DefaultBuildkitScheme is the default scheme earthly uses to connect to its buildkitd. tcp or docker-container.
 	DefaultBuildkitScheme = "docker-container"
 
@@ -54,6 +57,7 @@ var (
 type GlobalConfig struct {
 	DisableAnalytics         bool     `yaml:"disable_analytics"          help:"Controls Earthly telemetry."`
 	BuildkitCacheSizeMb      int      `yaml:"cache_size_mb"              help:"Size of the buildkit cache in Megabytes."`
+	DindCacheSizeMb          int      `yaml:"dind_cache_size_mb"         help:"Size of the docker state kept by WITH DOCKER --cache-state in Megabytes. The least recently used state is removed once it is exceeded."`
 	BuildkitImage            string   `yaml:"buildkit_image"             help:"Choose a specific image for your buildkitd."`
 	BuildkitRestartTimeoutS  int      `yaml:"buildkit_restart_timeout_s" help:"How long to wait for buildkit to (re)start, in seconds."`
 	BuildkitAdditionalArgs   []string `yaml:"buildkit_additional_args"   help:"Additional args to pass to buildkit when it starts. Useful for custom/self-signed certs, or user namespace complications."`
@@ -68,8 +72,9 @@ type GlobalConfig struct {
 	ServerTLSCert            string   `yaml:"buildkitd_tlscert"          help:"The path to the server cert for verification. Relative paths are interpreted as relative to ~/.earthly. Only used when Earthly manages buildkit."`
 	ServerTLSKey             string   `yaml:"buildkitd_tlskey"           help:"The path to the server key for verification. Relative paths are interpreted as relative to ~/.earthly. Only used when Earthly manages buildkit."`
 	TLSEnabled               bool     `yaml:"tls_enabled"                help:"If TLS should be used to communicate with Buildkit. Only honored when BuildkitScheme is 'tcp'."`
-	ContainerFrontend        string   `yaml:"container_frontend"         help:"What program should be used to start and stop buildkitd, save images. Default is 'docker'. Valid options are 'docker' and 'podman' (experimental)."`
+	ContainerFrontend        string   `yaml:"container_frontend"         help:"What program should be used to start and stop buildkitd, save images. Default is 'docker'. Valid options are 'docker', 'podman' (experimental) and 'nerdctl-shell' (experimental)."`
 	IPTables                 string   `yaml:"ip_tables"                  help:"Which iptables binary to use. Valid values are iptables-legacy or iptables-nft. Bypasses any autodetection."`
+	ShellRepeaterPort        int      `yaml:"shell_repeater_port"        help:"The port the interactive debugger listens on within the buildkitd container. Only used when Earthly manages buildkit."`
 
 	// Obsolete.
 	CachePath      string `yaml:"cache_path"         help:" *Deprecated* The path to keep Earthly's cache."`
@@ -100,10 +105,21 @@ type Config struct {
 
 // ParseConfigFile parse config data
 func ParseConfigFile(yamlData []byte) (*Config, error) {
-	// pre-populate defaults
-	config := Config{
+	config := defaultConfig()
+
+	err := yaml.Unmarshal(yamlData, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func defaultConfig() Config {
+	return Config{
 		Global: GlobalConfig{
 			BuildkitCacheSizeMb: 0,
+			DindCacheSizeMb:     DefaultDindCacheSizeMb,
 			DebuggerPort:        DefaultDebuggerPort,
 			// LocalRegistryHost:       fmt.Sprintf("tcp://127.0.0.1:%d", DefaultLocalRegistryPort), // TODO: Uncomment when feature is ready.
 			BuildkitScheme:          DefaultBuildkitScheme,
@@ -117,13 +133,6 @@ func ParseConfigFile(yamlData []byte) (*Config, error) {
 			ContainerFrontend:       DefaultContainerFrontend,
 		},
 	}
-
-	err := yaml.Unmarshal(yamlData, &config)
-	if err != nil {
-		return nil, err
-	}
-
-	return &config, nil
 }
 
 func keyAndValueCompatible(key reflect.Type, value *yaml.Node) bool {
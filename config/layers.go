@@ -0,0 +1,358 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// OriginDefault is the origin reported for config values which have not been set anywhere.
+	OriginDefault = "default"
+
+	// EnvOverridePrefix is the prefix of the environment variables which override individual config values,
+	// as in EARTHLY_CONFIG_GLOBAL_CACHE_SIZE_MB.
+	EnvOverridePrefix = "EARTHLY_CONFIG_"
+)
+
+// ProjectConfigPath is the location of a project-level config file, relative to the directory it applies to.
+var ProjectConfigPath = filepath.Join(".earthly", "config.yml")
+
+// ProjectConfigKeys are the only global keys which may be set by a project-level config file. A project config
+// is committed to a repository, and so it must not be able to change where and how buildkit runs, or
+// where git credentials are sent. Neither may it change settings which would restart buildkitd when
+// switching between projects.
+var ProjectConfigKeys = []string{
+	"global.buildkit_restart_timeout_s",
+	"global.disable_analytics",
+}
+
+// ProjectGitConfigKeys are the keys which may be set in the git entries of a project-level config file,
+// which allows a team to share how the repositories of its git hosts are cloned. A project config may only
+// add git entries for sites which are not already configured, and may not set any credentials.
+var ProjectGitConfigKeys = []string{
+	"pattern",
+	"substitute",
+	"suffix",
+	"auth",
+}
+
+// Layer is a single source of config values, such as a config file.
+type Layer struct {
+	// Origin describes where the values came from (e.g. the path of the file).
+	Origin string
+	Data   []byte
+	// Project is set for project-level config files, which may only set ProjectConfigKeys.
+	Project bool
+}
+
+// Origins maps config keys (e.g. global.cache_size_mb or git."github.com") to the origin of their value.
+type Origins map[string]string
+
+// Get returns the origin of the value of the given key.
+func (o Origins) Get(key string) string {
+	origin, ok := o[key]
+	if !ok {
+		return OriginDefault
+	}
+	return origin
+}
+
+// EffectiveValue is a single config value, together with where it was set.
+type EffectiveValue struct {
+	Key    string
+	Value  string
+	Origin string
+}
+
+// FindProjectConfig searches dir and its parents for a project-level config file (.earthly/config.yml),
+// and returns the absolute path of the closest one. An empty string is returned if none is found.
+// The file at ignorePath (typically the user's own config file) is never returned.
+func FindProjectConfig(dir, ignorePath string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "get absolute path of %s", dir)
+	}
+	if ignorePath != "" {
+		ignorePath, err = filepath.Abs(ignorePath)
+		if err != nil {
+			return "", errors.Wrapf(err, "get absolute path of %s", ignorePath)
+		}
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfigPath)
+		if path != ignorePath {
+			fi, err := os.Stat(path)
+			if err == nil && !fi.IsDir() {
+				return path, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// ParseConfigLayers parses the given layers on top of the defaults, in order, such that values in later layers
+// take precedence over those in earlier ones. EARTHLY_CONFIG_GLOBAL_<KEY> overrides found in environ
+// (as returned by os.Environ) are then applied on top of all the layers. An error is returned if a project
+// layer sets a key which is not one of ProjectConfigKeys.
+func ParseConfigLayers(layers []Layer, environ []string) (*Config, Origins, error) {
+	config := defaultConfig()
+	origins := Origins{}
+	for _, layer := range layers {
+		keys, err := layerKeys(layer.Data)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to parse %s", layer.Origin)
+		}
+		if layer.Project {
+			err = checkProjectKeys(layer, keys, origins)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		err = yaml.Unmarshal(layer.Data, &config)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to parse %s", layer.Origin)
+		}
+		for _, key := range keys {
+			origins[key] = layer.Origin
+		}
+	}
+
+	err := applyEnvOverrides(&config.Global, environ, origins)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &config, origins, nil
+}
+
+// layerKeys returns the keys set by a single layer. Global values are tracked individually, whereas git
+// entries are tracked as a whole, since a later layer replaces the entire entry for a site.
+func layerKeys(data []byte) ([]string, error) {
+	doc := &yaml.Node{}
+	err := yaml.Unmarshal(data, doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	var keys []string
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		section, value := root.Content[i].Value, root.Content[i+1]
+		if value.Kind != yaml.MappingNode {
+			keys = append(keys, section)
+			continue
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			keys = append(keys, joinKey(section, value.Content[j].Value))
+		}
+	}
+	return keys, nil
+}
+
+func checkProjectKeys(layer Layer, keys []string, origins Origins) error {
+	allowed := map[string]bool{}
+	for _, key := range ProjectConfigKeys {
+		allowed[key] = true
+	}
+	for _, key := range keys {
+		if !allowed[key] && !strings.HasPrefix(key, "git.") {
+			return errors.Errorf(
+				"%s: %s cannot be set in a project config; set it in the user config instead (allowed keys: %s)",
+				layer.Origin, key, strings.Join(ProjectConfigKeys, ", "))
+		}
+	}
+
+	allowedGit := map[string]bool{}
+	for _, key := range ProjectGitConfigKeys {
+		allowedGit[key] = true
+	}
+	sites, err := layerGitSites(layer.Data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s", layer.Origin)
+	}
+	for site, siteKeys := range sites {
+		siteKey := joinKey("git", site)
+		if origin, ok := origins[siteKey]; ok {
+			return errors.Errorf(
+				"%s: %s is already configured in %s, and cannot be changed by a project config",
+				layer.Origin, siteKey, origin)
+		}
+		for _, key := range siteKeys {
+			if !allowedGit[key] {
+				return errors.Errorf(
+					"%s: %s cannot be set in a project config; set it in the user config instead (allowed git keys: %s)",
+					layer.Origin, joinKey(siteKey, key), strings.Join(ProjectGitConfigKeys, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// layerGitSites returns the keys set for each git site by a single layer.
+func layerGitSites(data []byte) (map[string][]string, error) {
+	doc := &yaml.Node{}
+	err := yaml.Unmarshal(data, doc)
+	if err != nil {
+		return nil, err
+	}
+	sites := map[string][]string{}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return sites, nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "git" || root.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		git := root.Content[i+1]
+		for j := 0; j+1 < len(git.Content); j += 2 {
+			site, entry := git.Content[j].Value, git.Content[j+1]
+			sites[site] = nil
+			if entry.Kind != yaml.MappingNode {
+				continue
+			}
+			for k := 0; k+1 < len(entry.Content); k += 2 {
+				sites[site] = append(sites[site], entry.Content[k].Value)
+			}
+		}
+	}
+	return sites, nil
+}
+
+// EnvOverrideName returns the name of the environment variable which overrides the given global config key.
+func EnvOverrideName(key string) string {
+	return EnvOverridePrefix + "GLOBAL_" + strings.ToUpper(key)
+}
+
+// UnknownEnvOverrides returns the names of the EARTHLY_CONFIG_* variables in environ which do not override any config value.
+func UnknownEnvOverrides(environ []string) []string {
+	known := map[string]bool{}
+	t := reflect.TypeOf(GlobalConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		known[EnvOverrideName(yamlName(t.Field(i)))] = true
+	}
+	var ret []string
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, EnvOverridePrefix) && !known[name] {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func applyEnvOverrides(global *GlobalConfig, environ []string, origins Origins) error {
+	env := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], EnvOverridePrefix) {
+			env[parts[0]] = parts[1]
+		}
+	}
+	if len(env) == 0 {
+		return nil
+	}
+
+	v := reflect.ValueOf(global).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlName(t.Field(i))
+		name := EnvOverrideName(key)
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+		node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+		if value != "" {
+			parsed, err := valueToYaml(value)
+			if err != nil {
+				return errors.Wrapf(err, "invalid value for %s", name)
+			}
+			node = parsed
+		}
+		field := reflect.New(t.Field(i).Type)
+		err := node.Decode(field.Interface())
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for %s", name)
+		}
+		v.Field(i).Set(field.Elem())
+		origins[joinKey("global", key)] = fmt.Sprintf("env %s", name)
+	}
+	return nil
+}
+
+// EffectiveValues lists every global config value and every git config value which has been set,
+// in the form accepted by earthly config, together with the origin of each value. Passwords are masked.
+func EffectiveValues(config *Config, origins Origins) ([]EffectiveValue, error) {
+	var ret []EffectiveValue
+	v := reflect.ValueOf(config.Global)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := joinKey("global", yamlName(t.Field(i)))
+		dt, err := json.Marshal(v.Field(i).Interface())
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal %s", key)
+		}
+		ret = append(ret, EffectiveValue{
+			Key:    key,
+			Value:  string(dt),
+			Origin: origins.Get(key),
+		})
+	}
+
+	sites := make([]string, 0, len(config.Git))
+	for site := range config.Git {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+	for _, site := range sites {
+		siteKey := joinKey("git", site)
+		gv := reflect.ValueOf(config.Git[site])
+		gt := gv.Type()
+		for i := 0; i < gt.NumField(); i++ {
+			value := gv.Field(i).String()
+			if value == "" {
+				continue
+			}
+			tag := yamlName(gt.Field(i))
+			if tag == "password" {
+				value = "****"
+			}
+			dt, err := json.Marshal(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "marshal %s", siteKey)
+			}
+			ret = append(ret, EffectiveValue{
+				Key:    joinKey(siteKey, tag),
+				Value:  string(dt),
+				Origin: origins.Get(siteKey),
+			})
+		}
+	}
+	return ret, nil
+}
+
+// joinKey joins config key parts using the syntax accepted by earthly config, quoting parts which contain a period.
+func joinKey(parts ...string) string {
+	quoted := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if strings.Contains(part, ".") {
+			part = fmt.Sprintf("%q", part)
+		}
+		quoted = append(quoted, part)
+	}
+	return strings.Join(quoted, ".")
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseConfigLayers(t *testing.T) {
+	user := Layer{Origin: "user.yml", Data: []byte(`
+global:
+  cache_size_mb: 1000
+  buildkit_image: user/buildkitd
+git:
+  github.com:
+    auth: ssh
+`)}
+	project := Layer{Origin: "project.yml", Project: true, Data: []byte(`
+global:
+  buildkit_restart_timeout_s: 120
+git:
+  git.example.com:
+    pattern: git.example.com/([^/]+)/([^/]+)
+    auth: https
+`)}
+	environ := []string{
+		"EARTHLY_CONFIG_GLOBAL_CNI_MTU=1400",
+		"EARTHLY_CONFIG_GLOBAL_BUILDKIT_ADDITIONAL_ARGS=[--foo, --bar]",
+		"EARTHLY_CONFIG=/some/other/path",
+	}
+
+	cfg, origins, err := ParseConfigLayers([]Layer{user, project}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Global.BuildkitCacheSizeMb != 1000 {
+		t.Errorf("cache_size_mb = %d; want 1000", cfg.Global.BuildkitCacheSizeMb)
+	}
+	if cfg.Global.BuildkitRestartTimeoutS != 120 {
+		t.Errorf("buildkit_restart_timeout_s = %d; want 120", cfg.Global.BuildkitRestartTimeoutS)
+	}
+	if cfg.Global.BuildkitImage != "user/buildkitd" {
+		t.Errorf("buildkit_image = %q; want user/buildkitd", cfg.Global.BuildkitImage)
+	}
+	if cfg.Global.CniMtu != 1400 {
+		t.Errorf("cni_mtu = %d; want 1400", cfg.Global.CniMtu)
+	}
+	if len(cfg.Global.BuildkitAdditionalArgs) != 2 || cfg.Global.BuildkitAdditionalArgs[1] != "--bar" {
+		t.Errorf("buildkit_additional_args = %v; want [--foo --bar]", cfg.Global.BuildkitAdditionalArgs)
+	}
+	if cfg.Global.ContainerFrontend != DefaultContainerFrontend {
+		t.Errorf("container_frontend = %q; want %q", cfg.Global.ContainerFrontend, DefaultContainerFrontend)
+	}
+	if cfg.Git["github.com"].Auth != "ssh" {
+		t.Errorf("git.github.com = %+v; want auth ssh", cfg.Git["github.com"])
+	}
+	if cfg.Git["git.example.com"].Auth != "https" {
+		t.Errorf("git.git.example.com = %+v; want auth https", cfg.Git["git.example.com"])
+	}
+
+	var tests = []struct {
+		key    string
+		origin string
+	}{
+		{"global.cache_size_mb", "user.yml"},
+		{"global.buildkit_restart_timeout_s", "project.yml"},
+		{`git."git.example.com"`, "project.yml"},
+		{"global.buildkit_image", "user.yml"},
+		{"global.cni_mtu", "env EARTHLY_CONFIG_GLOBAL_CNI_MTU"},
+		{"global.container_frontend", OriginDefault},
+		{`git."github.com"`, "user.yml"},
+	}
+	for _, tt := range tests {
+		if origin := origins.Get(tt.key); origin != tt.origin {
+			t.Errorf("origin of %s = %q; want %q", tt.key, origin, tt.origin)
+		}
+	}
+}
+
+func TestParseConfigLayersProjectKeys(t *testing.T) {
+	var tests = []string{
+		"global:\n  buildkit_additional_args: [-v, /:/host]\n",
+		"global:\n  buildkit_host: tcp://example.com:8372\n",
+		"global:\n  tlsca: /tmp/ca.pem\n",
+		"global:\n  cache_size_mb: 2000\n",
+		"git:\n  github.com:\n    auth: https\n",
+		"git:\n  git.example.com:\n    user: me\n    password: secret\n",
+		"git:\n  git.example.com:\n    serverkey: \"git.example.com ssh-rsa AAAA\"\n",
+		"git:\n  global:\n    url_instead_of: \"x=y\"\n",
+		"git: github.com\n",
+	}
+	user := Layer{Origin: "user.yml", Data: []byte("global:\n  buildkit_host: tcp://example.com:8372\ngit:\n  github.com:\n    auth: ssh\n")}
+	for _, data := range tests {
+		project := Layer{Origin: "project.yml", Project: true, Data: []byte(data)}
+		_, _, err := ParseConfigLayers([]Layer{user, project}, nil)
+		if err == nil {
+			t.Errorf("expected an error for project config %q", data)
+		}
+	}
+
+	_, _, err := ParseConfigLayers([]Layer{user}, nil)
+	if err != nil {
+		t.Errorf("unexpected error for user config: %v", err)
+	}
+}
+
+func TestParseConfigLayersInvalidEnv(t *testing.T) {
+	_, _, err := ParseConfigLayers(nil, []string{"EARTHLY_CONFIG_GLOBAL_CACHE_SIZE_MB=lots"})
+	if err == nil {
+		t.Error("expected an error for a non-integer cache_size_mb override")
+	}
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "earthly-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	projectConfig := filepath.Join(root, "repo", ProjectConfigPath)
+	nested := filepath.Join(root, "repo", "services", "api")
+	for _, dir := range []string{filepath.Dir(projectConfig), nested} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(projectConfig, []byte("global: {}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := FindProjectConfig(nested, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != projectConfig {
+		t.Errorf("FindProjectConfig(%s) = %q; want %q", nested, path, projectConfig)
+	}
+
+	path, err = FindProjectConfig(nested, projectConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "" {
+		t.Errorf("FindProjectConfig(%s) = %q; want the ignored path to be skipped", nested, path)
+	}
+}
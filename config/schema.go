@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// schemaDraft is the JSON Schema draft which the generated schema conforms to.
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// UnknownKey is a key in a config file which does not correspond to any config value.
+type UnknownKey struct {
+	Key  string
+	Line int
+}
+
+// Schema is a (partial) JSON Schema document, as generated from the config structs.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// UnknownKeys returns the keys in the config file data which are not known config values (e.g. due to typos),
+// together with the line they appear on.
+func UnknownKeys(yamlData []byte) ([]UnknownKey, error) {
+	doc := &yaml.Node{}
+	err := yaml.Unmarshal(yamlData, doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return unknownKeys(reflect.TypeOf(Config{}), doc.Content[0], nil), nil
+}
+
+func unknownKeys(t reflect.Type, node *yaml.Node, path []string) []UnknownKey {
+	if node.Kind != yaml.MappingNode {
+		// Values of the wrong type are reported when decoding.
+		return nil
+	}
+	var ret []UnknownKey
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		keyPath := append(append([]string{}, path...), key.Value)
+		switch t.Kind() {
+		case reflect.Map:
+			ret = append(ret, unknownKeys(t.Elem(), value, keyPath)...)
+		case reflect.Struct:
+			field, ok := fieldForKey(t, key.Value)
+			if !ok {
+				ret = append(ret, UnknownKey{Key: joinKey(keyPath...), Line: key.Line})
+				continue
+			}
+			ret = append(ret, unknownKeys(field.Type, value, keyPath)...)
+		}
+	}
+	return ret
+}
+
+func fieldForKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if yamlName(field) == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func yamlName(field reflect.StructField) string {
+	return strings.SplitN(field.Tag.Get("yaml"), ",", 2)[0]
+}
+
+// JSONSchema generates a JSON Schema for the config file from the config structs and their help tags,
+// which editors can use to validate and complete config files.
+func JSONSchema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema.Schema = schemaDraft
+	schema.Title = "Earthly configuration file"
+	// The help text contains placeholders like <site>, which should not be escaped.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal config schema")
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func typeSchema(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{
+			Type:                 "object",
+			Properties:           map[string]*Schema{},
+			AdditionalProperties: false,
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			prop := typeSchema(field.Type)
+			prop.Description = strings.TrimSpace(field.Tag.Get("help"))
+			s.Properties[yamlName(field)] = prop
+		}
+		return s
+	case reflect.Map:
+		return &Schema{
+			Type:                 "object",
+			AdditionalProperties: typeSchema(t.Elem()),
+		}
+	case reflect.Slice:
+		return &Schema{
+			Type:  "array",
+			Items: typeSchema(t.Elem()),
+		}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		min := float64(0)
+		s := &Schema{Type: "integer", Minimum: &min}
+		if t.Bits() < 64 {
+			max := math.Pow(2, float64(t.Bits())) - 1
+			s.Maximum = &max
+		}
+		return s
+	default:
+		return &Schema{Type: "string"}
+	}
+}
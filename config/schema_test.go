@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestUnknownKeys(t *testing.T) {
+	data := []byte(`global:
+  cache_size_mb: 1000
+  cache_sizemb: 2000
+git:
+  github.com:
+    auth: ssh
+    usr: alice
+unknown: true
+`)
+	expected := []UnknownKey{
+		{Key: "global.cache_sizemb", Line: 3},
+		{Key: `git."github.com".usr`, Line: 7},
+		{Key: "unknown", Line: 8},
+	}
+
+	actual, err := UnknownKeys(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("UnknownKeys() = %v; want %v", actual, expected)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	dt, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &Schema{}
+	err = json.Unmarshal(dt, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	global, ok := schema.Properties["global"]
+	if !ok {
+		t.Fatal("expected a global property")
+	}
+	cniMtu := global.Properties["cni_mtu"]
+	if cniMtu == nil || cniMtu.Type != "integer" || cniMtu.Maximum == nil || *cniMtu.Maximum != 65535 {
+		t.Errorf("cni_mtu = %+v; want an integer with a maximum of 65535", cniMtu)
+	}
+	args := global.Properties["buildkit_additional_args"]
+	if args == nil || args.Type != "array" || args.Items.Type != "string" {
+		t.Errorf("buildkit_additional_args = %+v; want an array of strings", args)
+	}
+	if global.AdditionalProperties != false {
+		t.Errorf("global.additionalProperties = %v; want false", global.AdditionalProperties)
+	}
+}
@@ -1,31 +1,57 @@
 package conslogging
 
 import (
-	"fmt"
+	"bytes"
 )
 
 // BufferedLogger is a logger that queues up messages until Flush is called.
 type BufferedLogger struct {
-	cl    *ConsoleLogger
-	queue []string
+	cl *ConsoleLogger
+	// buf is protected by the mutex of the console.
+	buf *bytes.Buffer
 }
 
 // NewBufferedLogger creates a new BufferedLogger.
 func NewBufferedLogger(cl *ConsoleLogger) *BufferedLogger {
 	return &BufferedLogger{
-		cl: cl,
+		cl:  cl,
+		buf: new(bytes.Buffer),
 	}
 }
 
 // Printf prints a formatted string to the delayed console.
 func (bl *BufferedLogger) Printf(format string, v ...interface{}) {
-	bl.queue = append(bl.queue, fmt.Sprintf(format, v...))
+	bl.Console(*bl.cl).Printf(format, v...)
+}
+
+// Console returns a ConsoleLogger which prints like the given one, but whose output is queued up
+// until Flush is called.
+func (bl *BufferedLogger) Console(cl ConsoleLogger) ConsoleLogger {
+	ret := cl.clone()
+	ret.errW = bl.buf
+	// Log groups and the footer apply once the output is flushed.
+	ret.groups = nil
+	ret.footer = nil
+	return ret
+}
+
+// Len returns the number of bytes queued up.
+func (bl *BufferedLogger) Len() int {
+	bl.cl.mu.Lock()
+	defer bl.cl.mu.Unlock()
+	return bl.buf.Len()
 }
 
 // Flush prints the queued up messages to the underlying console.
 func (bl *BufferedLogger) Flush() {
-	for _, s := range bl.queue {
-		bl.cl.Printf("%s", s)
+	bl.cl.mu.Lock()
+	defer bl.cl.mu.Unlock()
+	if bl.buf.Len() == 0 {
+		return
 	}
-	bl.queue = nil
+	bl.cl.clearFooter()
+	defer bl.cl.drawFooter()
+	bl.cl.enterGroup()
+	bl.cl.errW.Write(bl.buf.Bytes())
+	bl.buf.Reset()
 }
@@ -0,0 +1,32 @@
+package conslogging
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferedLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cl := Current(NoColor, NoPadding, false).WithWriter(&buf).WithLogGroups(GitHubActionsLogGroups)
+	a := cl.WithPrefixAndSalt("+a", "a")
+	bl := NewBufferedLogger(&a)
+
+	bl.Console(a).Printf("one\n")
+	cl.WithPrefixAndSalt("+b", "b").Printf("two\n")
+	bl.Console(a).WithCached(true).Printf("three\n")
+	assert.Equal(t, len("+a | one\n+a | *cached* three\n"), bl.Len())
+
+	bl.Flush()
+	bl.Flush()
+	assert.Equal(t, ""+
+		"::group::+b\n"+
+		"+b | two\n"+
+		"::endgroup::\n"+
+		"::group::+a\n"+
+		"+a | one\n"+
+		"+a | *cached* three\n",
+		buf.String())
+	assert.Equal(t, 0, bl.Len())
+}
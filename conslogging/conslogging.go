@@ -3,6 +3,7 @@ package conslogging
 import (
 	"fmt"
 	"io"
+	"os"
 	"strings"
 	"sync"
 	"unicode/utf8"
@@ -49,6 +50,8 @@ type ConsoleLogger struct {
 	isCached  bool
 	isFailed  bool
 	verbose   bool
+	// groupless prints within whichever log group is open, rather than in a group of its own.
+	groupless bool
 
 	// The following are shared between instances and are protected by the mutex.
 	mu             *sync.Mutex
@@ -57,6 +60,8 @@ type ConsoleLogger struct {
 	errW           io.Writer
 	trailingLine   bool
 	prefixPadding  int
+	groups         *logGroups
+	footer         *footer
 }
 
 func (cl ConsoleLogger) clone() ConsoleLogger {
@@ -74,6 +79,9 @@ func (cl ConsoleLogger) clone() ConsoleLogger {
 		nextColorIndex: cl.nextColorIndex,
 		prefixPadding:  cl.prefixPadding,
 		mu:             cl.mu,
+		groups:         cl.groups,
+		footer:         cl.footer,
+		groupless:      cl.groupless,
 	}
 }
 
@@ -138,10 +146,28 @@ func (cl ConsoleLogger) WithWriter(w io.Writer) ConsoleLogger {
 	return ret
 }
 
+// WithLogGroups returns a ConsoleLogger which groups the output of each target into a collapsible
+// section, using the syntax of the given CI provider.
+func (cl ConsoleLogger) WithLogGroups(mode LogGroupMode) ConsoleLogger {
+	ret := cl.clone()
+	ret.groups = newLogGroups(mode)
+	return ret
+}
+
+// WithGroupless returns a ConsoleLogger which prints within whichever log group is open, rather than
+// in a group of its own.
+func (cl ConsoleLogger) WithGroupless(groupless bool) ConsoleLogger {
+	ret := cl.clone()
+	ret.groupless = groupless
+	return ret
+}
+
 // PrintPhaseHeader prints the phase header.
 func (cl ConsoleLogger) PrintPhaseHeader(phase string, disabled bool, special string) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
+	cl.clearFooter()
+	defer cl.drawFooter()
 	msg := phase
 	c := cl.color(phaseColor)
 	if disabled {
@@ -155,6 +181,7 @@ func (cl ConsoleLogger) PrintPhaseHeader(phase string, disabled bool, special st
 	if underlineLength < barWidth {
 		underlineLength = barWidth
 	}
+	cl.endGroup()
 	cl.errW.Write([]byte("\n"))
 	c.Fprintf(cl.errW, " %s", msg)
 	cl.errW.Write([]byte("\n"))
@@ -166,6 +193,8 @@ func (cl ConsoleLogger) PrintPhaseHeader(phase string, disabled bool, special st
 func (cl ConsoleLogger) PrintPhaseFooter(phase string, disabled bool, special string) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
+	cl.clearFooter()
+	defer cl.drawFooter()
 	c := cl.color(noColor)
 	c.Fprintf(cl.errW, "\n")
 }
@@ -174,7 +203,7 @@ func (cl ConsoleLogger) PrintPhaseFooter(phase string, disabled bool, special st
 func (cl ConsoleLogger) PrintSuccess() {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
-	cl.PrintBar(successColor, "🌍 Earthly Build  ✅ SUCCESS", "")
+	cl.printBar(successColor, "🌍 Earthly Build  ✅ SUCCESS", "")
 }
 
 // PrintFailure prints the failure message.
@@ -182,7 +211,23 @@ func (cl ConsoleLogger) PrintFailure(phase string) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
 
-	cl.PrintBar(warnColor, "❌ FAILURE", phase)
+	cl.printBar(warnColor, "❌ FAILURE", phase)
+}
+
+// PrintErrorAnnotation prints an error annotation pointing at the given line of an Earthfile, for
+// the CI providers which display annotations. It does nothing otherwise.
+func (cl ConsoleLogger) PrintErrorAnnotation(file string, line int, msg string) {
+	if cl.groups == nil {
+		return
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	annotation := cl.groups.errorAnnotation(os.Getenv("GITHUB_WORKSPACE"), file, line, msg)
+	if annotation != "" {
+		cl.clearFooter()
+		cl.errW.Write([]byte(annotation))
+		cl.drawFooter()
+	}
 }
 
 // PrefixColor returns the color used for the prefix.
@@ -198,6 +243,15 @@ func (cl ConsoleLogger) PrefixColor() *color.Color {
 
 // PrintBar prints an earthly message bar.
 func (cl ConsoleLogger) PrintBar(c *color.Color, msg, phase string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.printBar(c, msg, phase)
+}
+
+func (cl ConsoleLogger) printBar(c *color.Color, msg, phase string) {
+	// Assumes mu locked.
+	cl.clearFooter()
+	defer cl.drawFooter()
 	c = cl.color(c)
 	center := msg
 	if phase != "" {
@@ -209,6 +263,7 @@ func (cl ConsoleLogger) PrintBar(c *color.Color, msg, phase string) {
 	if sideWidth < 0 {
 		sideWidth = 0
 	}
+	cl.endGroup()
 	eqBar := strings.Repeat("=", sideWidth)
 	leftBar := eqBar
 	rightBar := eqBar
@@ -226,11 +281,17 @@ func (cl ConsoleLogger) PrintBar(c *color.Color, msg, phase string) {
 func (cl ConsoleLogger) Warnf(format string, args ...interface{}) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
+	cl.clearFooter()
+	defer cl.drawFooter()
 
 	c := cl.color(warnColor)
 	text := fmt.Sprintf(format, args...)
 	text = strings.TrimSuffix(text, "\n")
 
+	cl.enterGroup()
+	if cl.groups != nil {
+		cl.groups.expand(cl.errW)
+	}
 	for _, line := range strings.Split(text, "\n") {
 		cl.printPrefix()
 		c.Fprintf(cl.errW, "%s\n", line)
@@ -241,12 +302,15 @@ func (cl ConsoleLogger) Warnf(format string, args ...interface{}) {
 func (cl ConsoleLogger) Printf(format string, args ...interface{}) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
+	cl.clearFooter()
+	defer cl.drawFooter()
 	c := cl.color(noColor)
 	if cl.metadataMode {
 		c = cl.color(metadataModeColor)
 	}
 	text := fmt.Sprintf(format, args...)
 	text = strings.TrimSuffix(text, "\n")
+	cl.enterGroup()
 	for _, line := range strings.Split(text, "\n") {
 		cl.printPrefix()
 		c.Fprintf(cl.errW, "%s", line)
@@ -259,11 +323,14 @@ func (cl ConsoleLogger) Printf(format string, args ...interface{}) {
 func (cl ConsoleLogger) PrintBytes(data []byte) {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
+	cl.clearFooter()
+	defer cl.drawFooter()
 	c := cl.color(noColor)
 	if cl.metadataMode {
 		c = cl.color(metadataModeColor)
 	}
 
+	cl.enterGroup()
 	output := make([]byte, 0, len(data))
 	for len(data) > 0 {
 		r, size := utf8.DecodeRune(data)
@@ -308,6 +375,27 @@ func (cl ConsoleLogger) VerboseBytes(data []byte) {
 	}
 }
 
+func (cl ConsoleLogger) enterGroup() {
+	// Assumes mu locked.
+	if cl.groups == nil || cl.groupless {
+		return
+	}
+	if cl.prefix == "" || cl.isFailed {
+		// Output which is not specific to a target, as well as the output of failures, is
+		// never collapsed.
+		cl.groups.end(cl.errW)
+		return
+	}
+	cl.groups.start(cl.errW, cl.salt, cl.prefix)
+}
+
+func (cl ConsoleLogger) endGroup() {
+	// Assumes mu locked.
+	if cl.groups != nil {
+		cl.groups.end(cl.errW)
+	}
+}
+
 func (cl ConsoleLogger) printPrefix() {
 	// Assumes mu locked.
 	if cl.prefix == "" {
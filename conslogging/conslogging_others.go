@@ -18,5 +18,6 @@ func Current(colorMode ColorMode, prefixPadding int, verbose bool) ConsoleLogger
 		prefixPadding:  prefixPadding,
 		mu:             &currentConsoleMutex,
 		verbose:        verbose,
+		footer:         &footer{},
 	}
 }
@@ -0,0 +1,46 @@
+package conslogging
+
+import (
+	"fmt"
+	"strings"
+)
+
+// footer holds the lines which are kept at the bottom of the console, below any other output.
+type footer struct {
+	lines []string
+	// drawn is the number of lines of the footer currently on screen.
+	drawn int
+}
+
+// SetFooter sets lines which are kept at the bottom of the console, below any other output, until
+// they are cleared via SetFooter(nil). The lines must fit within the width of the terminal, which
+// must support ANSI escape sequences.
+func (cl ConsoleLogger) SetFooter(lines []string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.footer == nil {
+		return
+	}
+	cl.clearFooter()
+	cl.footer.lines = lines
+	cl.drawFooter()
+}
+
+func (cl ConsoleLogger) clearFooter() {
+	// Assumes mu locked.
+	if cl.footer == nil || cl.footer.drawn == 0 {
+		return
+	}
+	// Move to the start of the first line of the footer, and erase everything below.
+	fmt.Fprintf(cl.errW, "\x1b[%dA\r\x1b[J", cl.footer.drawn)
+	cl.footer.drawn = 0
+}
+
+func (cl ConsoleLogger) drawFooter() {
+	// Assumes mu locked.
+	if cl.footer == nil || len(cl.footer.lines) == 0 {
+		return
+	}
+	cl.errW.Write([]byte(strings.Join(cl.footer.lines, "\n") + "\n"))
+	cl.footer.drawn = len(cl.footer.lines)
+}
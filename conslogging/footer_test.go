@@ -0,0 +1,53 @@
+package conslogging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFooter(t *testing.T) {
+	var buf bytes.Buffer
+	cl := Current(NoColor, NoPadding, false).WithWriter(&buf)
+
+	cl.SetFooter([]string{"status", "progress"})
+	cl.WithPrefix("+a").Printf("one\n")
+	cl.SetFooter([]string{"done"})
+	cl.SetFooter(nil)
+	cl.Printf("two\n")
+
+	assert.Equal(t, ""+
+		"status\nprogress\n"+
+		"\x1b[2A\r\x1b[J"+"+a | one\n"+"status\nprogress\n"+
+		"\x1b[2A\r\x1b[J"+"done\n"+
+		"\x1b[1A\r\x1b[J"+
+		"two\n",
+		buf.String())
+}
+
+func TestFooterPrintBar(t *testing.T) {
+	var buf bytes.Buffer
+	cl := Current(NoColor, NoPadding, false).WithWriter(&buf)
+
+	cl.SetFooter([]string{"status"})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cl.SetFooter([]string{"status"})
+		}
+	}()
+	cl.PrintBar(phaseColor, "bar", "")
+	<-done
+	cl.SetFooter(nil)
+
+	// The bar is printed in between clearing and redrawing the footer, and never interleaved with
+	// a concurrent update of the footer.
+	bar := "\n" + strings.Repeat("=", 37) + " bar " + strings.Repeat("=", 38) + "\n\n"
+	clear := "\x1b[1A\r\x1b[J"
+	out := buf.String()
+	assert.Contains(t, out, clear+bar+"status\n")
+	assert.Equal(t, "status\n"+strings.Repeat(clear+"status\n", 101)+clear, strings.Replace(out, bar, "", 1))
+}
@@ -0,0 +1,155 @@
+package conslogging
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LogGroupMode is the syntax used to group the output of each target into a collapsible section,
+// as supported by the log viewer of a CI provider.
+type LogGroupMode int
+
+const (
+	// NoLogGroups disables the grouping of the output.
+	NoLogGroups LogGroupMode = iota
+	// GitHubActionsLogGroups uses the ::group:: workflow commands of GitHub Actions.
+	GitHubActionsLogGroups
+	// GitLabLogGroups uses the section markers of GitLab CI.
+	GitLabLogGroups
+	// BuildkiteLogGroups uses the ~~~ group headers of Buildkite.
+	BuildkiteLogGroups
+)
+
+// LogGroupModeForCI returns the log group mode supported by the CI provider with the given name,
+// as returned by analytics.DetectCI. Providers without collapsible sections, such as CircleCI or
+// Jenkins, use NoLogGroups.
+func LogGroupModeForCI(ciName string) LogGroupMode {
+	switch ciName {
+	case "github-actions":
+		return GitHubActionsLogGroups
+	case "gitlab":
+		return GitLabLogGroups
+	case "buildkite":
+		return BuildkiteLogGroups
+	default:
+		return NoLogGroups
+	}
+}
+
+// logGroups tracks the group which is currently open. Groups cannot be nested, so the output of
+// targets which run in parallel results in a new group each time the output switches target.
+type logGroups struct {
+	mode     LogGroupMode
+	open     bool
+	salt     string
+	section  string
+	expanded bool
+	count    int
+	now      func() time.Time
+}
+
+func newLogGroups(mode LogGroupMode) *logGroups {
+	if mode == NoLogGroups {
+		return nil
+	}
+	return &logGroups{
+		mode: mode,
+		now:  time.Now,
+	}
+}
+
+// start opens a group for the output of the target with the given salt, unless it is already open.
+func (g *logGroups) start(w io.Writer, salt, title string) {
+	if g.open && g.salt == salt {
+		return
+	}
+	if g.mode != BuildkiteLogGroups {
+		// Buildkite groups end where the next one starts.
+		g.end(w)
+	}
+	g.count++
+	g.open = true
+	g.salt = salt
+	g.section = fmt.Sprintf("earthly_%d", g.count)
+	g.expanded = false
+	switch g.mode {
+	case GitHubActionsLogGroups:
+		fmt.Fprintf(w, "::group::%s\n", escapeWorkflowData(title))
+	case GitLabLogGroups:
+		fmt.Fprintf(w, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", g.now().Unix(), g.section, title)
+	case BuildkiteLogGroups:
+		fmt.Fprintf(w, "~~~ %s\n", title)
+	}
+}
+
+// end closes the group which is open, if any.
+func (g *logGroups) end(w io.Writer) {
+	if !g.open {
+		return
+	}
+	g.open = false
+	switch g.mode {
+	case GitHubActionsLogGroups:
+		fmt.Fprintf(w, "::endgroup::\n")
+	case GitLabLogGroups:
+		fmt.Fprintf(w, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", g.now().Unix(), g.section)
+	case BuildkiteLogGroups:
+		fmt.Fprintf(w, "--- earthly\n")
+	}
+}
+
+// expand expands the group which is open, for the CI providers which support doing so after the
+// group has started.
+func (g *logGroups) expand(w io.Writer) {
+	if !g.open || g.expanded {
+		return
+	}
+	g.expanded = true
+	if g.mode == BuildkiteLogGroups {
+		fmt.Fprintf(w, "^^^ +++\n")
+	}
+}
+
+// errorAnnotation returns the workflow command which annotates the given line of an Earthfile with
+// an error, or "" if the mode does not support annotations. The file is made relative to root,
+// which is the root of the repository checked out by the CI provider, if possible.
+func (g *logGroups) errorAnnotation(root, file string, line int, msg string) string {
+	if g.mode != GitHubActionsLogGroups {
+		return ""
+	}
+	var props []string
+	if file != "" {
+		if root != "" {
+			abs, err := filepath.Abs(file)
+			if err == nil {
+				rel, err := filepath.Rel(root, abs)
+				if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+					file = filepath.ToSlash(rel)
+				}
+			}
+		}
+		props = append(props, fmt.Sprintf("file=%s", escapeWorkflowProperty(file)))
+		if line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", line))
+		}
+	}
+	if len(props) == 0 {
+		return fmt.Sprintf("::error::%s\n", escapeWorkflowData(msg))
+	}
+	return fmt.Sprintf("::error %s::%s\n", strings.Join(props, ","), escapeWorkflowData(msg))
+}
+
+func escapeWorkflowData(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	return strings.ReplaceAll(s, "\n", "%0A")
+}
+
+func escapeWorkflowProperty(s string) string {
+	s = escapeWorkflowData(s)
+	s = strings.ReplaceAll(s, ":", "%3A")
+	return strings.ReplaceAll(s, ",", "%2C")
+}
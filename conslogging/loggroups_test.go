@@ -0,0 +1,127 @@
+package conslogging
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testConsole(buf *bytes.Buffer, mode LogGroupMode) ConsoleLogger {
+	cl := Current(NoColor, NoPadding, false).WithWriter(buf).WithLogGroups(mode)
+	if cl.groups != nil {
+		cl.groups.now = func() time.Time { return time.Unix(1600000000, 0) }
+	}
+	return cl
+}
+
+func TestLogGroupsGitHubActions(t *testing.T) {
+	var buf bytes.Buffer
+	cl := testConsole(&buf, GitHubActionsLogGroups)
+	a := cl.WithPrefixAndSalt("+a", "a")
+	b := cl.WithPrefixAndSalt("+b", "b")
+
+	a.Printf("one\n")
+	a.PrintBytes([]byte("two\n"))
+	cl.WithPrefix("ongoing").WithGroupless(true).Printf("+a, +b\n")
+	b.Printf("three\n")
+	a.WithFailed(true).Printf("four\n")
+	cl.Printf("done\n")
+
+	assert.Equal(t, ""+
+		"::group::+a\n"+
+		"+a | one\n"+
+		"+a | two\n"+
+		"ongoing | +a, +b\n"+
+		"::endgroup::\n"+
+		"::group::+b\n"+
+		"+b | three\n"+
+		"::endgroup::\n"+
+		"+a *failed* | four\n"+
+		"done\n",
+		buf.String())
+}
+
+func TestLogGroupsGitLab(t *testing.T) {
+	var buf bytes.Buffer
+	cl := testConsole(&buf, GitLabLogGroups)
+	cl.WithPrefixAndSalt("+a", "a").Printf("one\n")
+	cl.PrintSuccess()
+
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, ""+
+		"\x1b[0Ksection_start:1600000000:earthly_1[collapsed=true]\r\x1b[0K+a\n"+
+		"+a | one\n"+
+		"\x1b[0Ksection_end:1600000000:earthly_1\r\x1b[0K\n"), out)
+	assert.Contains(t, out, "SUCCESS")
+}
+
+func TestLogGroupsBuildkite(t *testing.T) {
+	var buf bytes.Buffer
+	cl := testConsole(&buf, BuildkiteLogGroups)
+	cl.WithPrefixAndSalt("+a", "a").Printf("one\n")
+	cl.WithPrefixAndSalt("+b", "b").Warnf("ERROR: two\n")
+	cl.WithPrefixAndSalt("+b", "b").Warnf("ERROR: three\n")
+
+	assert.Equal(t, ""+
+		"~~~ +a\n"+
+		"+a | one\n"+
+		"~~~ +b\n"+
+		"^^^ +++\n"+
+		"+b | ERROR: two\n"+
+		"+b | ERROR: three\n",
+		buf.String())
+}
+
+func TestLogGroupsDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	cl := testConsole(&buf, NoLogGroups)
+	cl.WithPrefixAndSalt("+a", "a").Printf("one\n")
+	cl.PrintErrorAnnotation("Earthfile", 3, "failed")
+
+	assert.Equal(t, "+a | one\n", buf.String())
+}
+
+func TestErrorAnnotation(t *testing.T) {
+	root, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+	g := newLogGroups(GitHubActionsLogGroups)
+
+	assert.Equal(t,
+		"::error file=sub/Earthfile,line=12::[+build] Command exited with non-zero code: RUN echo 100%25%0Adone\n",
+		g.errorAnnotation(root, filepath.Join(root, "sub", "Earthfile"),
+			12, "[+build] Command exited with non-zero code: RUN echo 100%\ndone"))
+	assert.Equal(t,
+		"::error file=/other/Earthfile::failed\n",
+		g.errorAnnotation(root, "/other/Earthfile", 0, "failed"))
+	assert.Equal(t,
+		"::error::failed\n",
+		g.errorAnnotation(root, "", 0, "failed"))
+	assert.Equal(t, "", newLogGroups(GitLabLogGroups).errorAnnotation(root, "Earthfile", 1, "failed"))
+}
+
+func TestLogGroupsPrintBar(t *testing.T) {
+	var buf bytes.Buffer
+	cl := testConsole(&buf, GitHubActionsLogGroups)
+	a := cl.WithPrefixAndSalt("+a", "a")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			a.Printf("one\n")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		cl.PrintBar(phaseColor, "bar", "")
+	}
+	<-done
+	cl.PrintSuccess()
+
+	// Every group is ended exactly once, even when bars are printed concurrently.
+	out := buf.String()
+	assert.Equal(t, strings.Count(out, "::group::"), strings.Count(out, "::endgroup::"), out)
+	assert.True(t, strings.HasSuffix(out, "::endgroup::\n\n"+strings.Repeat("=", 26)+" 🌍 Earthly Build  ✅ SUCCESS "+strings.Repeat("=", 26)+"\n\n"), out)
+}
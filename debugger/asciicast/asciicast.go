@@ -0,0 +1,243 @@
+// Package asciicast records and replays terminal sessions in the asciicast v2 format
+// (https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md).
+package asciicast
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Version is the version of the asciicast format.
+const Version = 2
+
+const (
+	// EventOutput is the type of events holding data written to the terminal.
+	EventOutput = "o"
+	// EventInput is the type of events holding data read from the terminal.
+	EventInput = "i"
+	// EventResize is the type of events holding the new size of the terminal, as <width>x<height>.
+	EventResize = "r"
+)
+
+// Header is the first line of an asciicast file.
+type Header struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+}
+
+// Event is a line of an asciicast file, following the header.
+type Event struct {
+	// Time is the time elapsed since the start of the recording.
+	Time time.Duration
+	Type string
+	Data string
+}
+
+// MarshalJSON encodes the event as [<seconds>, <type>, <data>].
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time.Seconds(), e.Type, e.Data})
+}
+
+// UnmarshalJSON decodes an event from [<seconds>, <type>, <data>].
+func (e *Event) UnmarshalJSON(dt []byte) error {
+	var fields []json.RawMessage
+	err := json.Unmarshal(dt, &fields)
+	if err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return errors.Errorf("expected 3 fields in event, got %d", len(fields))
+	}
+	var seconds float64
+	err = json.Unmarshal(fields[0], &seconds)
+	if err != nil {
+		return errors.Wrap(err, "event time")
+	}
+	err = json.Unmarshal(fields[1], &e.Type)
+	if err != nil {
+		return errors.Wrap(err, "event type")
+	}
+	err = json.Unmarshal(fields[2], &e.Data)
+	if err != nil {
+		return errors.Wrap(err, "event data")
+	}
+	e.Time = time.Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// Recorder writes an asciicast recording. It is safe for concurrent use.
+type Recorder struct {
+	w     io.WriteCloser
+	enc   *json.Encoder
+	start time.Time
+	// pending holds the trailing bytes of an incomplete UTF-8 sequence, per event type, as pty data
+	// may be split at any byte.
+	pending map[string][]byte
+
+	mu sync.Mutex
+}
+
+// NewRecorder writes the header of a recording to w, and returns a recorder for its events.
+// The recorder closes w when it is closed.
+func NewRecorder(w io.WriteCloser, width, height int, env map[string]string) (*Recorder, error) {
+	r := &Recorder{
+		w:       w,
+		enc:     json.NewEncoder(w),
+		start:   time.Now(),
+		pending: make(map[string][]byte),
+	}
+	r.enc.SetEscapeHTML(false)
+	err := r.enc.Encode(Header{
+		Version:   Version,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Env:       env,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "write asciicast header")
+	}
+	return r, nil
+}
+
+// Output records data written to the terminal.
+func (r *Recorder) Output(data []byte) error {
+	return r.write(EventOutput, data)
+}
+
+// Input records data read from the terminal.
+func (r *Recorder) Input(data []byte) error {
+	return r.write(EventInput, data)
+}
+
+// Resize records a change of the size of the terminal.
+func (r *Recorder) Resize(width, height int) error {
+	return r.write(EventResize, []byte(fmt.Sprintf("%dx%d", width, height)))
+}
+
+func (r *Recorder) write(eventType string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data = append(r.pending[eventType], data...)
+	complete := completeUTF8(data)
+	r.pending[eventType] = append([]byte{}, data[complete:]...)
+	if complete == 0 {
+		return nil
+	}
+	err := r.enc.Encode(Event{
+		Time: time.Since(r.start),
+		Type: eventType,
+		Data: string(data[:complete]),
+	})
+	if err != nil {
+		return errors.Wrap(err, "write asciicast event")
+	}
+	return nil
+}
+
+// Close closes the underlying writer.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Close()
+}
+
+// completeUTF8 returns the length of the prefix of data which does not end within a UTF-8 sequence.
+func completeUTF8(data []byte) int {
+	// A UTF-8 sequence is at most utf8.UTFMax bytes long, so only the tail needs to be checked.
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+// ReplayOpt contains the options of a replay.
+type ReplayOpt struct {
+	// Speed is the factor by which the replay is sped up. Defaults to 1.
+	Speed float64
+	// IdleTimeLimit caps the time between events. Defaults to the limit in the header of the
+	// recording, if any.
+	IdleTimeLimit time.Duration
+}
+
+// Replay writes the output events of the recording read from r to w, respecting their timing.
+// It returns the header of the recording.
+func Replay(ctx context.Context, r io.Reader, w io.Writer, opt ReplayOpt) (Header, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return Header{}, errors.Wrap(scanner.Err(), "read asciicast header")
+		}
+		return Header{}, errors.New("empty asciicast recording")
+	}
+	var header Header
+	err := json.Unmarshal(scanner.Bytes(), &header)
+	if err != nil {
+		return Header{}, errors.Wrap(err, "parse asciicast header")
+	}
+	if header.Version != Version {
+		return Header{}, errors.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	speed := opt.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	idleTimeLimit := opt.IdleTimeLimit
+	if idleTimeLimit == 0 && header.IdleTimeLimit > 0 {
+		idleTimeLimit = time.Duration(header.IdleTimeLimit * float64(time.Second))
+	}
+
+	var prev time.Duration
+	for line := 2; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return header, errors.Wrapf(err, "parse asciicast event on line %d", line)
+		}
+		if event.Type != EventOutput {
+			continue
+		}
+		wait := event.Time - prev
+		prev = event.Time
+		if idleTimeLimit > 0 && wait > idleTimeLimit {
+			wait = idleTimeLimit
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return header, ctx.Err()
+			case <-time.After(time.Duration(float64(wait) / speed)):
+			}
+		}
+		_, err = io.WriteString(w, event.Data)
+		if err != nil {
+			return header, errors.Wrap(err, "write replayed output")
+		}
+	}
+	if scanner.Err() != nil {
+		return header, errors.Wrap(scanner.Err(), "read asciicast recording")
+	}
+	return header, nil
+}
@@ -0,0 +1,83 @@
+package asciicast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestRecordAndReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(nopCloser{&buf}, 120, 40, map[string]string{"TERM": "xterm"})
+	assert.NoError(t, err)
+
+	euro := []byte("€") // A three byte UTF-8 sequence, split across two writes.
+	assert.NoError(t, rec.Output([]byte("$ ls\r\n")))
+	assert.NoError(t, rec.Output(append([]byte("price: "), euro[:1]...)))
+	assert.NoError(t, rec.Output(append(euro[1:], []byte("5\r\n")...)))
+	assert.NoError(t, rec.Resize(100, 30))
+	assert.NoError(t, rec.Input([]byte("exit\r")))
+	assert.NoError(t, rec.Close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 6)
+	var header Header
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, 2, header.Version)
+	assert.Equal(t, 120, header.Width)
+	assert.Equal(t, 40, header.Height)
+	assert.Equal(t, "xterm", header.Env["TERM"])
+
+	var events []Event
+	for _, line := range lines[1:] {
+		var event Event
+		assert.NoError(t, json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+	assert.Equal(t, "price: ", events[1].Data)
+	assert.Equal(t, "€5\r\n", events[2].Data)
+	assert.Equal(t, Event{Time: events[3].Time, Type: EventResize, Data: "100x30"}, events[3])
+	assert.Equal(t, EventInput, events[4].Type)
+
+	var out bytes.Buffer
+	_, err = Replay(context.Background(), &buf, &out, ReplayOpt{Speed: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, "$ ls\r\nprice: €5\r\n", out.String())
+}
+
+func TestReplayTiming(t *testing.T) {
+	recording := `{"version": 2, "width": 80, "height": 24, "idle_time_limit": 0.05}
+[0.0, "o", "a"]
+[10.0, "o", "b"]
+[10.02, "o", "c"]
+`
+	start := time.Now()
+	var out bytes.Buffer
+	_, err := Replay(context.Background(), strings.NewReader(recording), &out, ReplayOpt{})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", out.String())
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestReplayInvalid(t *testing.T) {
+	for _, recording := range []string{
+		"",
+		`{"version": 1}`,
+		"{\"version\": 2}\n[0.1, \"o\"]\n",
+	} {
+		_, err := Replay(context.Background(), strings.NewReader(recording), ioutil.Discard, ReplayOpt{})
+		assert.Error(t, err, recording)
+	}
+}
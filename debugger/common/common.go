@@ -2,9 +2,16 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
+	"net"
+
+	"github.com/pkg/errors"
 )
 
 //******************************************************************************************
@@ -12,6 +19,7 @@ import (
 
 // Protocol handshake:
 // <byte:connection_type> The first byte identifies the type of connection (i.e. is it a terminal or the shell)
+// <uint16:n><n bytes of session token> The session token pairs the terminal and the shell of the same build
 // next comes any number of data packets of the form:
 // <byte:data_packet_type><uint16:n><n bytes of data>
 
@@ -39,6 +47,9 @@ const PtyData = 0x03
 // WinSizeData identifies the terminal window data payload packet
 const WinSizeData = 0x04
 
+// DefaultPort is the port the shell repeater listens on by default
+const DefaultPort = 8373
+
 // End of network protocol magic numbers
 //******************************************************************************************
 
@@ -93,3 +104,46 @@ func SerializeDataPacket(payloadID int, data []byte) ([]byte, error) {
 	}
 	return b.Bytes(), nil
 }
+
+// WriteHandshake writes the handshake identifying the type of the connection and the session it belongs to
+func WriteHandshake(w io.Writer, connType byte, sessionToken string) error {
+	_, err := w.Write([]byte{connType})
+	if err != nil {
+		return err
+	}
+	return writeUint16PrefixedData(w, []byte(sessionToken))
+}
+
+// ReadHandshake decodes the handshake of a connection, returning its type and session token
+func ReadHandshake(r io.Reader) (byte, string, error) {
+	buf := make([]byte, 1)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return 0, "", err
+	}
+	sessionToken, err := readUint16PrefixedData(r)
+	if err != nil {
+		return 0, "", err
+	}
+	return buf[0], string(sessionToken), nil
+}
+
+// NewSessionToken returns a random session token
+func NewSessionToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "generate session token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Dial connects to the shell repeater, using TLS if tlsConfig is not nil
+func Dial(ctx context.Context, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig != nil {
+		d := tls.Dialer{Config: tlsConfig}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
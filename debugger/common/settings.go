@@ -1,5 +1,12 @@
 package common
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
 // DebuggerSettingsSecretsKey stores the secrets key name
 const DebuggerSettingsSecretsKey = "earthly_debugger_settings"
 
@@ -9,4 +16,24 @@ type DebuggerSettings struct {
 	Enabled           bool   `json:"enabled"`
 	RepeaterAddr      string `json:"repeaterAddr"`
 	Term              string `json:"term"`
+	SessionToken      string `json:"sessionToken"`
+	// TLSCA is the PEM encoded CA used to verify the shell repeater. TLS is disabled if empty.
+	TLSCA         string `json:"tlsCA,omitempty"`
+	TLSServerName string `json:"tlsServerName,omitempty"`
+}
+
+// TLSConfig returns the TLS config used by the debugger to connect to the shell repeater, or nil if TLS is disabled
+func (s *DebuggerSettings) TLSConfig() (*tls.Config, error) {
+	if s.TLSCA == "" {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(s.TLSCA)) {
+		return nil, errors.New("invalid debugger CA certificate")
+	}
+	return &tls.Config{
+		RootCAs:    pool,
+		ServerName: s.TLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}, nil
 }
@@ -2,11 +2,13 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
 	"sync"
 
+	"github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/slog"
 
 	"github.com/pkg/errors"
@@ -14,15 +16,22 @@ import (
 
 // Server provides a debugger server
 type Server struct {
+	sessions map[string]*session
+	mux      sync.Mutex
+
+	addr      string
+	tlsConfig *tls.Config
+	log       slog.Logger
+}
+
+// session pairs the shell and the terminal connections sharing the same session token
+type session struct {
 	shellConn    net.Conn
 	terminalConn net.Conn
-	mux          sync.Mutex
+	numConns     int
 
 	dataForShell    chan []byte
 	dataForTerminal chan []byte
-
-	addr string
-	log  slog.Logger
 }
 
 func (s *Server) handleConn(conn net.Conn, readFrom, writeTo chan []byte) {
@@ -66,12 +75,66 @@ func (s *Server) handleConn(conn net.Conn, readFrom, writeTo chan []byte) {
 	wg.Wait()
 }
 
-func (s *Server) handleTermConn(conn net.Conn) {
-	s.handleConn(conn, s.dataForShell, s.dataForTerminal)
+// hasClientCert returns true if the connection presented a client certificate, which the TLS
+// handshake verified against the CA.
+func hasClientCert(conn net.Conn) (bool, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return false, nil
+	}
+	err := tlsConn.Handshake()
+	if err != nil {
+		return false, errors.Wrap(err, "tls handshake")
+	}
+	return len(tlsConn.ConnectionState().PeerCertificates) > 0, nil
 }
 
-func (s *Server) handleShellConn(conn net.Conn) {
-	s.handleConn(conn, s.dataForTerminal, s.dataForShell)
+// attach registers the connection with the session of the given token, replacing any previous
+// connection of the same type.
+func (s *Server) attach(conn net.Conn, sessionToken string, isShellConn bool, connLog slog.Logger) *session {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	sess, ok := s.sessions[sessionToken]
+	if !ok {
+		sess = &session{
+			dataForShell:    make(chan []byte, 100),
+			dataForTerminal: make(chan []byte, 100),
+		}
+		s.sessions[sessionToken] = sess
+	}
+	sess.numConns++
+	if isShellConn {
+		connLog.Debug("received shell connection")
+		if sess.shellConn != nil {
+			connLog.Debug("closing existing shell connection")
+			sess.shellConn.Close()
+		}
+		sess.shellConn = conn
+	} else {
+		connLog.Debug("received term connection")
+		if sess.terminalConn != nil {
+			connLog.Debug("closing existing term connection")
+			sess.terminalConn.Close()
+		}
+		sess.terminalConn = conn
+	}
+	return sess
+}
+
+// detach unregisters the connection, and forgets the session once it has no connections left.
+func (s *Server) detach(conn net.Conn, sessionToken string, sess *session) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if sess.shellConn == conn {
+		sess.shellConn = nil
+	}
+	if sess.terminalConn == conn {
+		sess.terminalConn = nil
+	}
+	sess.numConns--
+	if sess.numConns == 0 {
+		delete(s.sessions, sessionToken)
+	}
 }
 
 func (s *Server) handleRequest(conn net.Conn) {
@@ -79,53 +142,60 @@ func (s *Server) handleRequest(conn net.Conn) {
 
 	connLog := s.log.With("remote.addr", conn.RemoteAddr().String())
 
-	buf := make([]byte, 1)
-	conn.Read(buf)
+	connType, sessionToken, err := common.ReadHandshake(conn)
+	if err != nil {
+		connLog.Error(errors.Wrap(err, "failed to read handshake"))
+		return
+	}
+	if sessionToken == "" {
+		connLog.Error(errors.New("connection without a session token"))
+		return
+	}
 
 	var isShellConn bool
-	switch buf[0] {
-	case 0x01:
+	switch connType {
+	case common.ShellID:
 		isShellConn = true
-	case 0x02:
+	case common.TermID:
 		isShellConn = false
+		if s.tlsConfig != nil {
+			// Only the earthly client, which holds a client certificate, may attach a terminal.
+			// Shells connect from within the build, and are only identified by their session token.
+			ok, err := hasClientCert(conn)
+			if err != nil {
+				connLog.Error(err)
+				return
+			}
+			if !ok {
+				connLog.Error(errors.New("term connection without a client certificate"))
+				return
+			}
+		}
 	default:
-		fmt.Fprintf(os.Stderr, "unexpected data: %v", buf[0])
+		fmt.Fprintf(os.Stderr, "unexpected data: %v", connType)
 		return
 	}
 
-	func() {
-		s.mux.Lock()
-		defer s.mux.Unlock()
-		if isShellConn {
-			connLog.Debug("received shell connection")
-			if s.shellConn != nil {
-				connLog.Debug("closing existing shell connection")
-				s.shellConn.Close()
-				s.shellConn = nil
-			}
-			s.shellConn = conn
-		} else {
-			connLog.Debug("received term connection")
-			if s.terminalConn != nil {
-				connLog.Debug("closing existing term connection")
-				s.terminalConn.Close()
-				s.terminalConn = nil
-			}
-			s.terminalConn = conn
-		}
-	}()
+	sess := s.attach(conn, sessionToken, isShellConn, connLog)
+	defer s.detach(conn, sessionToken, sess)
 
 	if isShellConn {
-		s.handleShellConn(conn)
+		s.handleConn(conn, sess.dataForShell, sess.dataForTerminal)
 	} else {
-		s.handleTermConn(conn)
+		s.handleConn(conn, sess.dataForTerminal, sess.dataForShell)
 	}
 }
 
 // Start starts the debug server listener
 func (s *Server) Start() error {
-	s.log.With("addr", s.addr).Debug("starting debugger server")
-	l, err := net.Listen("tcp", s.addr)
+	s.log.With("addr", s.addr).With("tls", s.tlsConfig != nil).Debug("starting debugger server")
+	var l net.Listener
+	var err error
+	if s.tlsConfig != nil {
+		l, err = tls.Listen("tcp", s.addr, s.tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", s.addr)
+	}
 	if err != nil {
 		return err
 	}
@@ -142,12 +212,13 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// NewServer returns a new server
-func NewServer(addr string, log slog.Logger) *Server {
+// NewServer returns a new server. If tlsConfig is not nil, connections are served over TLS, and
+// terminal connections must present a client certificate.
+func NewServer(addr string, tlsConfig *tls.Config, log slog.Logger) *Server {
 	return &Server{
-		addr:            addr,
-		dataForShell:    make(chan []byte, 100),
-		dataForTerminal: make(chan []byte, 100),
-		log:             log,
+		addr:      addr,
+		tlsConfig: tlsConfig,
+		sessions:  make(map[string]*session),
+		log:       log,
 	}
 }
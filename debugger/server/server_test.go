@@ -2,84 +2,182 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/earthly/earthly/buildkitd"
 	"github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/slog"
 
 	"github.com/sirupsen/logrus"
 )
 
-func TestServer(t *testing.T) {
+func startServer(t *testing.T, addr string, tlsConfig *tls.Config) {
 	logrus.SetLevel(logrus.DebugLevel)
 	ctx := context.TODO()
 	log := slog.GetLogger(ctx).With("test.name", t.Name())
 
-	addr := "127.0.0.1:9834"
-	s := NewServer(addr, log)
+	s := NewServer(addr, tlsConfig, log)
 	go s.Start()
 
 	time.Sleep(10 * time.Millisecond)
+}
 
+func dial(t *testing.T, addr string, tlsConfig *tls.Config) net.Conn {
 	const numRetries = 3
-	attempts := 0
-	var termConn net.Conn
-	var err error
-
-	for attempts < numRetries {
-		termConn, err = net.Dial("tcp", addr)
-
-		if err != nil {
-			// Retry since the connection is rejected sometimes.
-			fmt.Printf("Dial failed. Attempt: %v/%v, Error: %s", attempts, numRetries, err.Error())
-			time.Sleep(time.Second)
-
-			attempts++
-			err = nil
-			continue
+	for attempts := 0; attempts < numRetries; attempts++ {
+		conn, err := common.Dial(context.TODO(), addr, tlsConfig)
+		if err == nil {
+			return conn
 		}
-		break
-	}
-	if attempts >= numRetries {
-		t.Fatal("Retries exhausted")
+		// Retry since the connection is rejected sometimes.
+		fmt.Printf("Dial failed. Attempt: %v/%v, Error: %s", attempts, numRetries, err.Error())
+		time.Sleep(time.Second)
 	}
+	t.Fatal("Retries exhausted")
+	return nil
+}
 
-	_, err = termConn.Write([]byte{common.TermID})
+func connect(t *testing.T, addr string, tlsConfig *tls.Config, connType byte, sessionToken string) net.Conn {
+	conn := dial(t, addr, tlsConfig)
+	err := common.WriteHandshake(conn, connType, sessionToken)
 	if err != nil {
 		t.Fatal(err)
 	}
+	// Give the server time to register the connection.
+	time.Sleep(10 * time.Millisecond)
+	return conn
+}
 
-	// then the shell terminal
-	shellConn, err := net.Dial("tcp", addr)
+func assertRepeated(t *testing.T, from, to net.Conn, inputStr string) {
+	_, err := from.Write([]byte(inputStr))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = shellConn.Write([]byte{common.ShellID})
+	buf := make([]byte, 100)
+	err = to.SetReadDeadline(time.Now().Add(5 * time.Second))
 	if err != nil {
 		t.Fatal(err)
 	}
+	n, err := to.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputStr := string(buf[:n])
 
-	inputStr := "hello world"
+	if inputStr != outputStr {
+		t.Fatal(fmt.Sprintf("want %v; got %v", inputStr, outputStr))
+	}
+}
 
-	// send data from shell to term
-	_, err = shellConn.Write([]byte(inputStr))
+func assertClosed(t *testing.T, conn net.Conn) {
+	err := conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("expected the connection to be closed; timed out instead")
+	}
+}
 
-	buf := make([]byte, 100)
-	n, err := termConn.Read(buf)
+func TestServer(t *testing.T) {
+	addr := "127.0.0.1:9834"
+	startServer(t, addr, nil)
+
+	termConn := connect(t, addr, nil, common.TermID, "token")
+	defer termConn.Close()
+
+	// then the shell terminal
+	shellConn := connect(t, addr, nil, common.ShellID, "token")
+	defer shellConn.Close()
+
+	// send data from shell to term, and back
+	assertRepeated(t, shellConn, termConn, "hello world")
+	assertRepeated(t, termConn, shellConn, "hello shell")
+}
+
+func TestServerSessions(t *testing.T) {
+	addr := "127.0.0.1:9835"
+	startServer(t, addr, nil)
+
+	termConn1 := connect(t, addr, nil, common.TermID, "token1")
+	defer termConn1.Close()
+	termConn2 := connect(t, addr, nil, common.TermID, "token2")
+	defer termConn2.Close()
+	shellConn2 := connect(t, addr, nil, common.ShellID, "token2")
+	defer shellConn2.Close()
+	shellConn1 := connect(t, addr, nil, common.ShellID, "token1")
+	defer shellConn1.Close()
+
+	assertRepeated(t, shellConn1, termConn1, "hello session 1")
+	assertRepeated(t, shellConn2, termConn2, "hello session 2")
+
+	// Connections without a session token are rejected.
+	anonConn := connect(t, addr, nil, common.TermID, "")
+	defer anonConn.Close()
+	assertClosed(t, anonConn)
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	err := buildkitd.GenerateCertificates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(dir, "ca_cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "buildkit_cert.pem"), filepath.Join(dir, "buildkit_key.pem"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	outputStr := string(buf[:n])
 
-	if inputStr != outputStr {
-		t.Fatal(fmt.Sprintf("want %v; got %v", inputStr, outputStr))
+	addr := "127.0.0.1:9836"
+	startServer(t, addr, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+
+	termTLSConfig, _, err := buildkitd.DebuggerTLSConfig(buildkitd.Settings{
+		UseTCP:        true,
+		UseTLS:        true,
+		TLSCA:         filepath.Join(dir, "ca_cert.pem"),
+		ClientTLSCert: filepath.Join(dir, "earthly_cert.pem"),
+		ClientTLSKey:  filepath.Join(dir, "earthly_key.pem"),
+	}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shellSettings := &common.DebuggerSettings{TLSCA: string(ca), TLSServerName: "127.0.0.1"}
+	shellTLSConfig, err := shellSettings.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	termConn := connect(t, addr, termTLSConfig, common.TermID, "token")
+	defer termConn.Close()
+	shellConn := connect(t, addr, shellTLSConfig, common.ShellID, "token")
+	defer shellConn.Close()
+
+	assertRepeated(t, shellConn, termConn, "hello world")
+
+	// A terminal cannot attach without a client certificate.
+	anonTermConn := connect(t, addr, shellTLSConfig, common.TermID, "token")
+	defer anonTermConn.Close()
+	assertClosed(t, anonTermConn)
 }
@@ -4,14 +4,18 @@ package terminal
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
-	"net"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/debugger/asciicast"
 	"github.com/earthly/earthly/debugger/common"
 
 	"github.com/creack/pty"
@@ -27,30 +31,34 @@ func handlePtyData(data []byte) error {
 	return nil
 }
 
-func getWindowSizePayload() ([]byte, error) {
+func getWindowSizePayload() ([]byte, *pty.Winsize, error) {
 	size, err := pty.GetsizeFull(os.Stdin)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	b, err := json.Marshal(size)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return common.SerializeDataPacket(common.WinSizeData, b)
+	payload, err := common.SerializeDataPacket(common.WinSizeData, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return payload, size, nil
 }
 
-// ConnectTerm presents a terminal to the shell repeater
-func ConnectTerm(ctx context.Context, addr string, console conslogging.ConsoleLogger) error {
-	var d net.Dialer
-
+// ConnectTerm presents a terminal to the shell repeater, for the shells of the session identified by
+// sessionToken. The connection uses TLS if tlsConfig is not nil. If recordPath is not empty, each interactive
+// session is recorded as an asciicast file; the first one at recordPath, and subsequent ones next to it.
+func ConnectTerm(ctx context.Context, addr string, tlsConfig *tls.Config, sessionToken string, console conslogging.ConsoleLogger, recordPath string) error {
 	console.VerbosePrintf("connecting to shellrepeater on %v\n", addr)
-	conn, err := d.DialContext(ctx, "tcp", addr)
+	conn, err := common.Dial(ctx, addr, tlsConfig)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	_, err = conn.Write([]byte{common.TermID})
+	err = common.WriteHandshake(conn, common.TermID, sessionToken)
 	if err != nil {
 		return errors.Wrap(err, "failed to write TermID connection")
 	}
@@ -63,6 +71,8 @@ func ConnectTerm(ctx context.Context, addr string, console conslogging.ConsoleLo
 	ctx, cancel := context.WithCancel(ctx)
 
 	ts := &termState{}
+	sr := &sessionRecorder{path: recordPath, console: console}
+	defer sr.stop()
 	go func() {
 	outer:
 		for {
@@ -79,8 +89,10 @@ func ConnectTerm(ctx context.Context, addr string, console conslogging.ConsoleLo
 					console.VerbosePrintf("makeRaw failed: %s\n", err.Error())
 					break outer
 				}
+				sr.start()
 				sigs <- syscall.SIGWINCH
 			case common.EndShellSession:
+				sr.stop()
 				err := ts.restore()
 				if err != nil {
 					console.VerbosePrintf("restore failed: %s\n", err.Error())
@@ -92,6 +104,7 @@ func ConnectTerm(ctx context.Context, addr string, console conslogging.ConsoleLo
 					console.VerbosePrintf("handlePtyData failed: %s\n", err.Error())
 					break outer
 				}
+				sr.output(data)
 			default:
 				console.VerbosePrintf("unhandled terminal data type: %q\n", connDataType)
 				break outer
@@ -105,11 +118,12 @@ func ConnectTerm(ctx context.Context, addr string, console conslogging.ConsoleLo
 			if len(sigs) > 0 {
 				continue
 			}
-			data, err := getWindowSizePayload()
+			data, size, err := getWindowSizePayload()
 			if err != nil {
 				console.VerbosePrintf("failed to get window size payload: %s\n", err.Error())
 				break
 			}
+			sr.resize(size)
 			writeCh <- data
 		}
 		cancel()
@@ -186,3 +200,87 @@ func (ts *termState) restore() error {
 	}
 	return nil
 }
+
+// sessionRecorder records the interactive sessions of a terminal, if a path is set.
+type sessionRecorder struct {
+	path     string
+	console  conslogging.ConsoleLogger
+	sessions int
+	rec      *asciicast.Recorder
+
+	mu sync.Mutex
+}
+
+func (sr *sessionRecorder) start() {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.path == "" || sr.rec != nil {
+		return
+	}
+	sr.sessions++
+	path := sessionRecordingPath(sr.path, sr.sessions)
+	f, err := os.Create(path)
+	if err != nil {
+		sr.console.Warnf("Failed to record interactive session: %s\n", err.Error())
+		return
+	}
+	width, height := 80, 24
+	size, err := pty.GetsizeFull(os.Stdin)
+	if err == nil {
+		width, height = int(size.Cols), int(size.Rows)
+	}
+	sr.rec, err = asciicast.NewRecorder(f, width, height, map[string]string{"TERM": os.Getenv("TERM")})
+	if err != nil {
+		_ = f.Close()
+		sr.console.Warnf("Failed to record interactive session: %s\n", err.Error())
+		return
+	}
+	sr.console.VerbosePrintf("recording interactive session to %s\n", path)
+}
+
+func (sr *sessionRecorder) output(data []byte) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.rec == nil {
+		return
+	}
+	err := sr.rec.Output(data)
+	if err != nil {
+		sr.console.VerbosePrintf("failed to record output: %s\n", err.Error())
+	}
+}
+
+func (sr *sessionRecorder) resize(size *pty.Winsize) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.rec == nil {
+		return
+	}
+	err := sr.rec.Resize(int(size.Cols), int(size.Rows))
+	if err != nil {
+		sr.console.VerbosePrintf("failed to record resize: %s\n", err.Error())
+	}
+}
+
+func (sr *sessionRecorder) stop() {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.rec == nil {
+		return
+	}
+	err := sr.rec.Close()
+	if err != nil {
+		sr.console.Warnf("Failed to close recording of interactive session: %s\n", err.Error())
+	}
+	sr.rec = nil
+}
+
+// sessionRecordingPath returns the path of the recording of the n-th session (starting at 1);
+// session.cast, session-2.cast, session-3.cast and so on.
+func sessionRecordingPath(path string, n int) string {
+	if n <= 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
@@ -4,12 +4,14 @@ package terminal
 
 import (
 	"context"
+	"crypto/tls"
 
 	"github.com/earthly/earthly/conslogging"
 
 	"github.com/pkg/errors"
 )
 
-func ConnectTerm(ctx context.Context, addr string, console conslogging.ConsoleLogger) error {
+// ConnectTerm presents a terminal to the shell repeater
+func ConnectTerm(ctx context.Context, addr string, tlsConfig *tls.Config, sessionToken string, console conslogging.ConsoleLogger, recordPath string) error {
 	return errors.New("debugger not supported on Windows yet")
 }
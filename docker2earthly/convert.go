@@ -2,9 +2,12 @@ package docker2earthly
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com/earthly/earthly/util/fileutil"
@@ -14,15 +17,30 @@ import (
 	"github.com/pkg/errors"
 )
 
-func getArtifactName(s string) string {
-	split := strings.Split(s, "/")
-	n := len(split)
-	return split[n-1]
+// buildTargetName is the name of the target which builds the final stage of the Dockerfile.
+const buildTargetName = "build"
+
+var header = []string{
+	"# This Earthfile was generated using docker2earthly",
+	"# the conversion is done on a best-effort basis",
+	"# and might not follow best practices, please",
+	"# visit http://docs.earthly.dev for Earthfile guides",
+}
+
+// Warning is a Dockerfile construct which could not be translated faithfully.
+type Warning struct {
+	// Line is the line of the Dockerfile where the construct is found.
+	Line    int
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("line %d: %s", w.Line, w.Message)
 }
 
 // Docker2Earthly converts an existing Dockerfile in the current directory and writes out an Earthfile in the current directory
-// and error is returned if an Earthfile already exists.
-func Docker2Earthly(dockerfilePath, earthfilePath, imageTag string) error {
+// and error is returned if an Earthfile already exists. Constructs which could not be translated are reported via warnf.
+func Docker2Earthly(dockerfilePath, earthfilePath, imageTag string, warnf func(format string, args ...interface{})) error {
 	if fileutil.FileExists(earthfilePath) {
 		return errors.Errorf("earthfile already exists; please delete it if you wish to continue")
 	}
@@ -39,92 +57,409 @@ func Docker2Earthly(dockerfilePath, earthfilePath, imageTag string) error {
 		in = in2
 	}
 
-	targets := [][]string{
-		{
-			"# This Earthfile was generated using docker2earthly",
-			"# the conversion is done on a best-effort basis",
-			"# and might not follow best practices, please",
-			"# visit http://docs.earthly.dev for Earthfile guides",
-		},
+	earthfile, warnings, err := Convert(in, imageTag)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert Dockerfile located at %q", dockerfilePath)
+	}
+	for _, w := range warnings {
+		warnf("Warning: %s: %s\n", dockerfilePath, w)
 	}
 
-	dockerfile, err := parser.Parse(in)
+	var out io.Writer
+	if earthfilePath == "-" {
+		out2 := bufio.NewWriter(os.Stdout)
+		defer out2.Flush()
+		out = out2
+	} else {
+		out2, err := os.Create(earthfilePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create Earthfile under %q", earthfilePath)
+		}
+		defer out2.Close()
+		out = out2
+	}
+	_, err = out.Write(earthfile)
 	if err != nil {
-		return errors.Wrapf(err, "failed to parse Dockerfile located at %q", dockerfilePath)
+		return errors.Wrapf(err, "failed to write Earthfile under %q", earthfilePath)
 	}
+	return nil
+}
 
-	stages, _, err := instructions.Parse(dockerfile.AST)
+// Convert converts the Dockerfile read from in into an Earthfile. Each stage becomes a target of the same name,
+// and the final stage is built by the build target, which saves it as imageTag.
+func Convert(in io.Reader, imageTag string) ([]byte, []Warning, error) {
+	dockerfile, err := parser.Parse(in)
 	if err != nil {
-		return errors.Wrapf(err, "failed to parse Dockerfile located at %q", dockerfilePath)
+		return nil, nil, errors.Wrap(err, "parse")
 	}
+	stages, metaArgs, err := instructions.Parse(dockerfile.AST)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse")
+	}
+	if len(stages) == 0 {
+		return nil, nil, errors.New("no stages found")
+	}
+
+	c := newConverter(stages)
+	for i := range stages {
+		c.convertStage(i)
+	}
+	final := c.targets[len(stages)-1]
+	final.lines = append(final.lines, fmt.Sprintf("SAVE IMAGE %s", quote(imageTag)))
+	return c.render(metaArgs), c.warnings, nil
+}
 
-	names := map[string]int{}
+// target is a target of the generated Earthfile.
+type target struct {
+	name  string
+	lines []string
+	// artifacts are the SAVE ARTIFACT commands needed by COPY --from, in the order they were first needed.
+	artifacts    []string
+	hasArtifacts map[string]bool
+}
 
+func (t *target) saveArtifact(src, dest string) {
+	line := fmt.Sprintf("SAVE ARTIFACT %s %s", quote(src), quote(dest))
+	if !t.hasArtifacts[line] {
+		t.hasArtifacts[line] = true
+		t.artifacts = append(t.artifacts, line)
+	}
+}
+
+type converter struct {
+	stages  []instructions.Stage
+	targets []*target // one per stage, followed by one per image used with COPY --from
+	// stageIndex maps stage names and indexes, as used in FROM and COPY --from, to the index of the stage.
+	stageIndex   map[string]int
+	imageTargets map[string]*target
+	usedNames    map[string]bool
+	warnings     []Warning
+}
+
+func newConverter(stages []instructions.Stage) *converter {
+	c := &converter{
+		stages:       stages,
+		stageIndex:   make(map[string]int),
+		imageTargets: make(map[string]*target),
+		usedNames:    map[string]bool{buildTargetName: true},
+	}
+	last := len(stages) - 1
 	for i, stage := range stages {
-		targets = append(targets, []string{
-			fmt.Sprintf("FROM %s", stage.BaseName),
-		})
-		if stage.Name == "" {
-			names[fmt.Sprintf("%d", i)] = i
+		var name string
+		if i == last && (stage.Name == "" || targetName(stage.Name) == buildTargetName) {
+			name = buildTargetName
+		} else if stage.Name != "" {
+			name = c.uniqueName(targetName(stage.Name))
 		} else {
-			names[stage.Name] = i
-		}
-
-		for _, cmd := range stage.Commands {
-			l := fmt.Sprintf("%v", cmd)
-			if strings.HasPrefix(l, "COPY ") && strings.Contains(l, "--from") {
-				parts := strings.Split(l, " ")
-				if len(parts) != 4 {
-					return errors.Errorf("failed to parse %q", l)
-				}
-				kv := strings.Split(parts[1], "=")
-				if len(kv) != 2 {
-					return errors.Errorf("failed to parse %q", l)
-				}
-				fromStageName := kv[1]
-				n := names[fromStageName]
-				artifactName := getArtifactName(parts[2])
-				l = fmt.Sprintf("COPY +subbuild%d/%s %s", n+1, artifactName, parts[3])
-				targets[n+1] = append(targets[n+1], fmt.Sprintf("SAVE ARTIFACT %s %s\n", parts[2], artifactName))
+			name = c.uniqueName(fmt.Sprintf("stage-%d", i))
+		}
+		c.targets = append(c.targets, &target{name: name, hasArtifacts: make(map[string]bool)})
+	}
+	return c
+}
+
+func (c *converter) uniqueName(name string) string {
+	unique := name
+	for i := 2; c.usedNames[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	c.usedNames[unique] = true
+	return unique
+}
+
+func (c *converter) warn(cmd instructions.Command, msg string, args ...interface{}) {
+	c.warnings = append(c.warnings, Warning{Line: line(cmd.Location()), Message: fmt.Sprintf(msg, args...)})
+}
+
+// untranslated records a warning for a command which could not be translated at all, and keeps the original
+// command as a comment in the Earthfile.
+func (c *converter) untranslated(t *target, cmd instructions.Command, msg string, args ...interface{}) {
+	c.warn(cmd, msg, args...)
+	t.lines = append(t.lines, fmt.Sprintf("# docker2earthly: %s", fmt.Sprintf(msg, args...)))
+	if s, ok := cmd.(fmt.Stringer); ok {
+		for _, l := range strings.Split(s.String(), "\n") {
+			t.lines = append(t.lines, "# "+l)
+		}
+	}
+}
+
+func (c *converter) convertStage(i int) {
+	stage := c.stages[i]
+	t := c.targets[i]
+
+	from := []string{"FROM"}
+	if stage.Platform != "" {
+		if strings.Contains(stage.Platform, "BUILDPLATFORM") || strings.Contains(stage.Platform, "TARGETPLATFORM") {
+			c.warnings = append(c.warnings, Warning{
+				Line:    line(stage.Location),
+				Message: fmt.Sprintf("FROM --platform=%s is not supported; the target is built for the default platform", stage.Platform),
+			})
+		} else {
+			from = append(from, fmt.Sprintf("--platform=%s", stage.Platform))
+		}
+	}
+	if base, ok := c.stageIndex[strings.ToLower(stage.BaseName)]; ok {
+		from = append(from, "+"+c.targets[base].name)
+	} else {
+		from = append(from, stage.BaseName)
+	}
+	t.lines = append(t.lines, strings.Join(from, " "))
+
+	for _, cmd := range stage.Commands {
+		c.convertCommand(t, cmd)
+	}
+
+	// The stage may only be referenced by the stages after it.
+	c.stageIndex[strconv.Itoa(i)] = i
+	if stage.Name != "" {
+		c.stageIndex[strings.ToLower(stage.Name)] = i
+	}
+}
+
+func (c *converter) convertCommand(t *target, cmd instructions.Command) {
+	switch cmd := cmd.(type) {
+	case *instructions.ArgCommand:
+		for _, arg := range cmd.Args {
+			t.lines = append(t.lines, "ARG "+argString(arg))
+		}
+	case *instructions.EnvCommand:
+		for _, kv := range cmd.Env {
+			t.lines = append(t.lines, envLine(kv))
+		}
+	case *instructions.LabelCommand:
+		for _, kv := range cmd.Labels {
+			t.lines = append(t.lines, fmt.Sprintf("LABEL %s=%s", kv.Key, kv.Value))
+		}
+	case *instructions.MaintainerCommand:
+		t.lines = append(t.lines, fmt.Sprintf("LABEL maintainer=%s", quote(cmd.Maintainer)))
+	case *instructions.WorkdirCommand:
+		t.lines = append(t.lines, "WORKDIR "+cmd.Path)
+	case *instructions.UserCommand:
+		t.lines = append(t.lines, "USER "+cmd.User)
+	case *instructions.ExposeCommand:
+		t.lines = append(t.lines, "EXPOSE "+strings.Join(cmd.Ports, " "))
+	case *instructions.VolumeCommand:
+		t.lines = append(t.lines, "VOLUME "+quoteAll(cmd.Volumes))
+	case *instructions.CmdCommand:
+		t.lines = append(t.lines, "CMD "+cmdLine(cmd.ShellDependantCmdLine))
+	case *instructions.EntrypointCommand:
+		t.lines = append(t.lines, "ENTRYPOINT "+cmdLine(cmd.ShellDependantCmdLine))
+	case *instructions.HealthCheckCommand:
+		c.convertHealthcheck(t, cmd)
+	case *instructions.RunCommand:
+		c.convertRun(t, cmd)
+	case *instructions.CopyCommand:
+		c.convertCopy(t, cmd)
+	case *instructions.AddCommand:
+		c.convertAdd(t, cmd)
+	case *instructions.ShellCommand:
+		c.untranslated(t, cmd, "SHELL is not supported; shell-form commands are run using /bin/sh -c")
+	case *instructions.StopSignalCommand:
+		c.untranslated(t, cmd, "STOPSIGNAL is not supported")
+	case *instructions.OnbuildCommand:
+		c.untranslated(t, cmd, "ONBUILD is not supported")
+	default:
+		c.untranslated(t, cmd, "%s is not supported", strings.ToUpper(cmd.Name()))
+	}
+}
+
+func (c *converter) convertHealthcheck(t *target, cmd *instructions.HealthCheckCommand) {
+	test := cmd.Health.Test
+	if len(test) == 0 || test[0] == "NONE" {
+		t.lines = append(t.lines, "HEALTHCHECK NONE")
+		return
+	}
+	parts := []string{"HEALTHCHECK"}
+	if cmd.Health.Interval != 0 {
+		parts = append(parts, fmt.Sprintf("--interval=%s", cmd.Health.Interval))
+	}
+	if cmd.Health.Timeout != 0 {
+		parts = append(parts, fmt.Sprintf("--timeout=%s", cmd.Health.Timeout))
+	}
+	if cmd.Health.StartPeriod != 0 {
+		parts = append(parts, fmt.Sprintf("--start-period=%s", cmd.Health.StartPeriod))
+	}
+	if cmd.Health.Retries != 0 {
+		parts = append(parts, fmt.Sprintf("--retries=%d", cmd.Health.Retries))
+	}
+	parts = append(parts, "CMD")
+	if test[0] == "CMD-SHELL" {
+		parts = append(parts, strings.Join(test[1:], " "))
+	} else {
+		parts = append(parts, jsonArray(test[1:]))
+	}
+	t.lines = append(t.lines, strings.Join(parts, " "))
+}
+
+func (c *converter) convertRun(t *target, cmd *instructions.RunCommand) {
+	if len(cmd.Files) > 0 {
+		c.untranslated(t, cmd, "RUN with heredocs is not supported")
+		return
+	}
+	// The mount flags are only fully parsed upon expansion. Expand without substituting anything.
+	err := cmd.Expand(func(word string) (string, error) { return word, nil })
+	if err != nil {
+		c.untranslated(t, cmd, "failed to parse RUN flags: %s", err.Error())
+		return
+	}
+
+	parts := []string{"RUN"}
+	withSSH := false
+	for _, m := range instructions.GetMounts(cmd) {
+		switch m.Type {
+		case instructions.MountTypeCache:
+			if m.From != "" || m.Source != "" {
+				c.warn(cmd, "cache mounts seeded from another stage are not supported; the cache at %s starts out empty", m.Target)
+			}
+			if m.Mode != nil || m.UID != nil || m.GID != nil {
+				c.warn(cmd, "mode, uid and gid are not supported for mounts; they have been dropped from the mount at %s", m.Target)
+			}
+			spec := []string{"type=cache", "target=" + m.Target}
+			if m.CacheID != "" {
+				spec = append(spec, "id="+m.CacheID)
+			}
+			if m.CacheSharing != "" && m.CacheSharing != instructions.MountSharingShared {
+				spec = append(spec, "sharing="+m.CacheSharing)
+			}
+			if m.ReadOnly {
+				spec = append(spec, "ro")
+			}
+			parts = append(parts, "--mount="+quote(strings.Join(spec, ",")))
+		case instructions.MountTypeTmpfs:
+			parts = append(parts, "--mount="+quote("type=tmpfs,target="+m.Target))
+		case instructions.MountTypeSecret:
+			id := m.CacheID
+			if id == "" {
+				id = path.Base(m.Target)
+			}
+			target := m.Target
+			if target == "" {
+				target = "/run/secrets/" + id
 			}
-			if strings.HasPrefix(l, "ADD ") {
-				return errors.Errorf("earthly does not support ADD, please convert to COPY instead")
+			if m.Mode != nil || m.UID != nil || m.GID != nil {
+				c.warn(cmd, "mode, uid and gid are not supported for mounts; they have been dropped from the secret %s", id)
 			}
-			targets[i+1] = append(targets[i+1], l)
+			parts = append(parts, "--mount="+quote(fmt.Sprintf("type=secret,id=+secrets/%s,target=%s", id, target)))
+		case instructions.MountTypeSSH:
+			withSSH = true
+		default:
+			c.warn(cmd, "%s mounts are not supported; the mount at %s has been dropped", m.Type, m.Target)
 		}
 	}
-	i := len(targets) - 1
-	targets[i] = append(targets[i], fmt.Sprintf("SAVE IMAGE %s", imageTag))
+	if withSSH {
+		parts = append(parts, "--ssh")
+	}
+	if network := instructions.GetNetwork(cmd); network != instructions.NetworkDefault {
+		c.warn(cmd, "RUN --network=%s is not supported; the command is run with the default network", network)
+	}
+	parts = append(parts, cmdLine(cmd.ShellDependantCmdLine))
+	t.lines = append(t.lines, strings.Join(parts, " "))
+}
 
-	var out io.Writer
-	if earthfilePath == "-" {
-		out2 := bufio.NewWriter(os.Stdout)
-		defer out2.Flush()
-		out = out2
+func (c *converter) convertCopy(t *target, cmd *instructions.CopyCommand) {
+	c.copy(t, cmd, cmd.SourcesAndDest, cmd.From, cmd.Chown, cmd.Chmod)
+}
+
+// copy translates a COPY (or an equivalent ADD) command.
+func (c *converter) copy(t *target, cmd instructions.Command, sd instructions.SourcesAndDest, fromStage, chown, chmod string) {
+	if len(sd.SourceContents) > 0 {
+		c.untranslated(t, cmd, "%s with heredocs is not supported", strings.ToUpper(cmd.Name()))
+		return
+	}
+	if chmod != "" {
+		c.warn(cmd, "--chmod is not supported; the permissions of the source files are kept")
+	}
+	parts := []string{"COPY"}
+	if chown != "" {
+		parts = append(parts, "--chown="+quote(chown))
+	}
+	if fromStage == "" {
+		parts = append(parts, quoteAll(sd.SourcePaths), quote(sd.DestPath))
+		t.lines = append(t.lines, strings.Join(parts, " "))
+		return
+	}
+
+	var from *target
+	if i, ok := c.stageIndex[strings.ToLower(fromStage)]; ok {
+		from = c.targets[i]
 	} else {
-		out2, err := os.Create(earthfilePath)
-		if err != nil {
-			return errors.Wrapf(err, "failed to create Earthfile under %q", earthfilePath)
+		from = c.imageTarget(fromStage)
+	}
+	for _, src := range sd.SourcePaths {
+		// Sources are relative to the root of the stage (or image) copied from, rather than to its WORKDIR.
+		abs := path.Join("/", src)
+		artifact := strings.TrimPrefix(abs, "/")
+		dest := artifact
+		if hasWildcard(artifact) {
+			dest = path.Dir(artifact) + "/"
 		}
-		defer out2.Close()
-		out = out2
+		from.saveArtifact(abs, dest)
+		parts = append(parts, quote(fmt.Sprintf("+%s/%s", from.name, artifact)))
 	}
+	parts = append(parts, quote(sd.DestPath))
+	t.lines = append(t.lines, strings.Join(parts, " "))
+}
 
-	fmt.Fprintf(out, "\n")
+// imageTarget returns the target used to copy files from an image (as in COPY --from=<image>).
+func (c *converter) imageTarget(image string) *target {
+	it, ok := c.imageTargets[image]
+	if !ok {
+		it = &target{
+			name:         c.uniqueName("image-" + targetName(image)),
+			lines:        []string{"FROM " + quote(image)},
+			hasArtifacts: make(map[string]bool),
+		}
+		c.imageTargets[image] = it
+		c.targets = append(c.targets, it)
+	}
+	return it
+}
 
-	for i, lines := range targets {
-		for j, l := range lines {
-			if i == 0 {
-				fmt.Fprintf(out, "%s\n", l)
-			} else {
-				if j == 0 {
-					fmt.Fprintf(out, "subbuild%d:\n", i)
-				}
-				fmt.Fprintf(out, "    %s\n", l)
-			}
+func (c *converter) convertAdd(t *target, cmd *instructions.AddCommand) {
+	for _, src := range cmd.SourcePaths {
+		if isURL(src) {
+			c.untranslated(t, cmd, "ADD of remote URLs is not supported; download %s using RUN instead", src)
+			return
+		}
+		if isArchive(src) {
+			c.untranslated(t, cmd, "ADD of archives is not supported; COPY %s and extract it using RUN instead", src)
+			return
 		}
 	}
+	// Without URLs and archives, ADD is equivalent to COPY.
+	c.copy(t, cmd, cmd.SourcesAndDest, "", cmd.Chown, cmd.Chmod)
+}
 
-	fmt.Fprintf(out, "\nbuild:\n    BUILD +subbuild%d\n", i)
-	return nil
+func (c *converter) render(metaArgs []instructions.ArgCommand) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\n")
+	for _, l := range header {
+		fmt.Fprintf(&buf, "%s\n", l)
+	}
+	for _, cmd := range metaArgs {
+		for _, arg := range cmd.Args {
+			fmt.Fprintf(&buf, "ARG %s\n", argString(arg))
+		}
+	}
+	for _, t := range c.targets {
+		fmt.Fprintf(&buf, "\n%s:\n", t.name)
+		for _, l := range t.lines {
+			fmt.Fprintf(&buf, "    %s\n", l)
+		}
+		for _, l := range t.artifacts {
+			fmt.Fprintf(&buf, "    %s\n", l)
+		}
+	}
+	final := c.targets[len(c.stages)-1]
+	if final.name != buildTargetName {
+		fmt.Fprintf(&buf, "\n%s:\n    BUILD +%s\n", buildTargetName, final.name)
+	}
+	return buf.Bytes()
+}
+
+func line(location []parser.Range) int {
+	if len(location) == 0 {
+		return 0
+	}
+	return location[0].Start.Line
 }
@@ -0,0 +1,95 @@
+package docker2earthly
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDockerfile = `ARG GO_VERSION=1.16
+FROM golang:${GO_VERSION} AS builder
+ENV CGO_ENABLED=0 GOFLAGS="-mod=vendor -trimpath"
+WORKDIR /src
+RUN --mount=type=cache,target=/root/.cache/go-build,sharing=locked \
+    --mount=type=secret,id=netrc,target=/root/.netrc \
+    --mount=type=ssh \
+    go build -o /out/app ./cmd/app
+RUN --mount=type=bind,source=/x,target=/y echo hi
+
+FROM node:16 AS web
+COPY --chown=node:node package.json yarn.lock /web/
+
+FROM alpine:3.13
+LABEL org.opencontainers.image.title="my app"
+ADD https://example.com/x.tgz /tmp/
+COPY --from=builder /out/app /usr/local/bin/app
+COPY --from=web /web/a.js /web/b.js /srv/
+COPY --from=nginx:1.21 /etc/nginx/nginx.conf /etc/nginx/
+COPY --from=0 /src/go.mod /
+STOPSIGNAL SIGINT
+ENTRYPOINT ["/usr/local/bin/app"]
+`
+
+func TestConvert(t *testing.T) {
+	earthfile, warnings, err := Convert(strings.NewReader(testDockerfile), "myimage:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(earthfile)
+	for _, want := range []string{
+		"ARG GO_VERSION=1.16\n",
+		"builder:\n    FROM golang:${GO_VERSION}\n",
+		"    ENV GOFLAGS=\"-mod=vendor -trimpath\"\n",
+		"    RUN --mount=type=cache,target=/root/.cache/go-build,sharing=locked --mount=type=secret,id=+secrets/netrc,target=/root/.netrc --ssh go build -o /out/app ./cmd/app\n",
+		"    RUN echo hi\n",
+		"    SAVE ARTIFACT /out/app out/app\n",
+		"    SAVE ARTIFACT /src/go.mod src/go.mod\n",
+		"web:\n    FROM node:16\n    COPY --chown=node:node package.json yarn.lock /web/\n",
+		"build:\n    FROM alpine:3.13\n",
+		"    LABEL org.opencontainers.image.title=\"my app\"\n",
+		"    # ADD https://example.com/x.tgz /tmp/\n",
+		"    COPY +builder/out/app /usr/local/bin/app\n",
+		"    COPY +web/web/a.js +web/web/b.js /srv/\n",
+		"    COPY +image-nginx-1.21/etc/nginx/nginx.conf /etc/nginx/\n",
+		"    COPY +builder/src/go.mod /\n",
+		"    # STOPSIGNAL SIGINT\n",
+		"    ENTRYPOINT [\"/usr/local/bin/app\"]\n    SAVE IMAGE myimage:latest\n",
+		"image-nginx-1.21:\n    FROM nginx:1.21\n    SAVE ARTIFACT /etc/nginx/nginx.conf etc/nginx/nginx.conf\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	wantLines := []int{9, 16, 21}
+	if len(warnings) != len(wantLines) {
+		t.Fatalf("got %d warnings, want %d: %v", len(warnings), len(wantLines), warnings)
+	}
+	for i, w := range warnings {
+		if w.Line != wantLines[i] {
+			t.Errorf("warning %q is on line %d, want %d", w.Message, w.Line, wantLines[i])
+		}
+	}
+}
+
+func TestConvertBuildWrapper(t *testing.T) {
+	earthfile, _, err := Convert(strings.NewReader("FROM alpine AS release\nRUN true\n"), "myimage:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "release:\n    FROM alpine\n    RUN true\n    SAVE IMAGE myimage:latest\n\nbuild:\n    BUILD +release\n"
+	if !strings.Contains(string(earthfile), want) {
+		t.Errorf("output does not contain %q:\n%s", want, earthfile)
+	}
+}
+
+func TestTargetName(t *testing.T) {
+	for in, want := range map[string]string{
+		"builder":   "builder",
+		"Build_Env": "build-env",
+		"1st":       "stage-1st",
+	} {
+		if got := targetName(in); got != want {
+			t.Errorf("targetName(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
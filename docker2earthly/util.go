@@ -0,0 +1,104 @@
+package docker2earthly
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+)
+
+var archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz"}
+
+// quote quotes s for use as a single Earthfile argument, if needed. Variable references are kept as they are.
+// Words which are already quoted, as they appear in the Dockerfile, are kept as they are.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\#") {
+		return s
+	}
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func quoteAll(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, quote(a))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// argString returns an ARG declaration. Default values are kept as they appear in the Dockerfile.
+func argString(arg instructions.KeyValuePairOptional) string {
+	if arg.Value == nil {
+		return arg.Key
+	}
+	return arg.Key + "=" + *arg.Value
+}
+
+// envLine returns an ENV command. Values are kept as they appear in the Dockerfile; the legacy
+// ENV <key> <value> form is used for values which span several words.
+func envLine(kv instructions.KeyValuePair) string {
+	if strings.ContainsAny(kv.Value, " \t") && quote(kv.Value) != kv.Value {
+		return fmt.Sprintf("ENV %s %s", kv.Key, kv.Value)
+	}
+	return fmt.Sprintf("ENV %s=%s", kv.Key, kv.Value)
+}
+
+// cmdLine returns the shell form or the exec form of a RUN, CMD or ENTRYPOINT command line.
+func cmdLine(cl instructions.ShellDependantCmdLine) string {
+	if cl.PrependShell {
+		return strings.Join(cl.CmdLine, " ")
+	}
+	return jsonArray(cl.CmdLine)
+}
+
+func jsonArray(args []string) string {
+	if args == nil {
+		args = []string{}
+	}
+	dt, err := json.Marshal(args)
+	if err != nil {
+		// Marshalling a string slice cannot fail.
+		panic(err)
+	}
+	return string(dt)
+}
+
+// targetName returns a valid Earthfile target name, based on a Dockerfile stage name or an image name.
+func targetName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	ret := b.String()
+	if ret == "" || ret[0] < 'a' || ret[0] > 'z' {
+		ret = "stage-" + ret
+	}
+	return ret
+}
+
+func hasWildcard(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+func isURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+func isArchive(src string) bool {
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(strings.ToLower(src), suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,540 @@
+package doctor
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+
+	"github.com/earthly/earthly/buildkitd"
+	"github.com/earthly/earthly/util/containerutil"
+)
+
+// Status is the outcome of a single check.
+type Status string
+
+const (
+	// StatusOK means that the check passed.
+	StatusOK = Status("ok")
+	// StatusWarning means that the check found something which may cause problems.
+	StatusWarning = Status("warning")
+	// StatusError means that the check found something which will cause problems.
+	StatusError = Status("error")
+	// StatusSkipped means that the check does not apply to the current setup.
+	StatusSkipped = Status("skipped")
+)
+
+// certExpiryWarning is how long before a TLS certificate expires that a warning is issued.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// Check is the result of a single diagnostic check.
+type Check struct {
+	Name        string            `json:"name"`
+	Status      Status            `json:"status"`
+	Message     string            `json:"message"`
+	Remediation string            `json:"remediation,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+// Report is the result of running all diagnostic checks.
+type Report struct {
+	Version  string   `json:"version"`
+	Platform string   `json:"platform"`
+	Checks   []*Check `json:"checks"`
+}
+
+// Opt contains the settings the checks are run against.
+type Opt struct {
+	Frontend      containerutil.ContainerFrontend
+	ContainerName string
+	Image         string
+	Settings      buildkitd.Settings
+}
+
+type doctor struct {
+	opt     Opt
+	isLocal bool
+
+	container *containerutil.ContainerInfo
+	logs      string
+	workers   []*client.WorkerInfo
+}
+
+// Run runs all diagnostic checks, in order.
+func Run(ctx context.Context, opt Opt) []*Check {
+	d := &doctor{
+		opt:     opt,
+		isLocal: buildkitd.IsLocal(opt.Settings.BuildkitAddress),
+	}
+	checks := []func(context.Context) *Check{
+		d.checkFrontend,
+		d.checkBuildkitContainer,
+		d.checkSettingsHash,
+		d.checkBuildkitConnection,
+		d.checkTLS,
+		d.checkCacheSize,
+		d.checkIPTables,
+		d.checkMTU,
+		d.checkMultiPlatform,
+	}
+	var ret []*Check
+	for _, check := range checks {
+		ret = append(ret, check(ctx))
+	}
+	return ret
+}
+
+// HasErrors returns true if any of the checks resulted in an error.
+func HasErrors(checks []*Check) bool {
+	for _, c := range checks {
+		if c.Status == StatusError {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintText prints the report in a human readable form.
+func PrintText(out io.Writer, report *Report) {
+	fmt.Fprintf(out, "earthly version: %s\n", report.Version)
+	fmt.Fprintf(out, "platform: %s\n\n", report.Platform)
+	for _, c := range report.Checks {
+		fmt.Fprintf(out, "[%s] %s: %s\n", statusLabel(c.Status), c.Name, c.Message)
+		keys := make([]string, 0, len(c.Details))
+		for k := range c.Details {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(out, "        %s: %s\n", k, c.Details[k])
+		}
+		if c.Remediation != "" {
+			fmt.Fprintf(out, "        => %s\n", c.Remediation)
+		}
+	}
+}
+
+// PrintJSON prints the report as JSON, for attaching to support tickets.
+func PrintJSON(out io.Writer, report *Report) error {
+	dt, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal doctor report")
+	}
+	_, err = fmt.Fprintln(out, string(dt))
+	return err
+}
+
+func statusLabel(s Status) string {
+	switch s {
+	case StatusOK:
+		return " OK "
+	case StatusWarning:
+		return "WARN"
+	case StatusError:
+		return "FAIL"
+	default:
+		return "SKIP"
+	}
+}
+
+func (d *doctor) checkFrontend(ctx context.Context) *Check {
+	c := &Check{Name: "container frontend"}
+	cfg := d.opt.Frontend.Config()
+	if cfg.Setting == containerutil.FrontendStub {
+		c.Status = StatusError
+		c.Message = "no supported container frontend was detected"
+		c.Remediation = "Install docker (or podman or nerdctl) and ensure it is running, or set global.container_frontend in ~/.earthly/config.yml"
+		return c
+	}
+	if !d.opt.Frontend.IsAvaliable(ctx) {
+		c.Status = StatusError
+		c.Message = fmt.Sprintf("%s is not available", cfg.Binary)
+		c.Remediation = fmt.Sprintf("Is %s installed and running? Are you part of any needed groups?", cfg.Binary)
+		return c
+	}
+	info, err := d.opt.Frontend.Information(ctx)
+	if err != nil {
+		c.Status = StatusError
+		c.Message = fmt.Sprintf("failed to query %s: %s", cfg.Binary, err.Error())
+		c.Remediation = fmt.Sprintf("Check that the %s daemon is running and reachable", cfg.Binary)
+		return c
+	}
+	c.Status = StatusOK
+	c.Message = fmt.Sprintf("using %s", cfg.Setting)
+	c.Details = map[string]string{
+		"client version":  info.ClientVersion,
+		"client platform": info.ClientPlatform,
+		"server version":  info.ServerVersion,
+		"server platform": info.ServerPlatform,
+		"server address":  info.ServerAddress,
+	}
+	return c
+}
+
+func (d *doctor) checkBuildkitContainer(ctx context.Context) *Check {
+	c := &Check{Name: "buildkitd container"}
+	if !d.isLocal {
+		c.Status = StatusSkipped
+		c.Message = "buildkitd is not managed by earthly (buildkit_host is remote)"
+		return c
+	}
+	infos, err := d.opt.Frontend.ContainerInfo(ctx, d.opt.ContainerName)
+	if err != nil {
+		c.Status = StatusError
+		c.Message = fmt.Sprintf("failed to inspect %s: %s", d.opt.ContainerName, err.Error())
+		return c
+	}
+	info, ok := infos[d.opt.ContainerName]
+	if !ok || info.Status == containerutil.StatusMissing {
+		c.Status = StatusWarning
+		c.Message = fmt.Sprintf("%s container does not exist", d.opt.ContainerName)
+		c.Remediation = "It will be started by the next build, or run: earthly bootstrap"
+		return c
+	}
+	d.container = info
+	c.Details = map[string]string{
+		"status":   info.Status,
+		"image":    info.Image,
+		"image id": info.ImageID,
+	}
+	if info.Status != containerutil.StatusRunning {
+		c.Status = StatusError
+		c.Message = fmt.Sprintf("%s container is %s", d.opt.ContainerName, info.Status)
+		c.Remediation = fmt.Sprintf("Check the container logs (e.g. %s logs %s) for the reason it stopped", d.opt.Frontend.Config().Binary, d.opt.ContainerName)
+		return c
+	}
+	logs, err := d.opt.Frontend.ContainerLogs(ctx, d.opt.ContainerName)
+	if err == nil && logs[d.opt.ContainerName] != nil {
+		d.logs = logs[d.opt.ContainerName].Stdout + logs[d.opt.ContainerName].Stderr
+	}
+	c.Status = StatusOK
+	c.Message = "running"
+	return c
+}
+
+func (d *doctor) checkSettingsHash(ctx context.Context) *Check {
+	c := &Check{Name: "buildkitd settings"}
+	if d.container == nil || d.container.Status != containerutil.StatusRunning {
+		c.Status = StatusSkipped
+		c.Message = "buildkitd container is not running"
+		return c
+	}
+	availableImageID, err := buildkitd.GetAvailableImageID(ctx, d.opt.Image, d.opt.Frontend)
+	if err != nil || availableImageID != d.container.ImageID {
+		c.Status = StatusWarning
+		c.Message = fmt.Sprintf("the running container does not use the configured image %s", d.opt.Image)
+		c.Remediation = "buildkitd will be restarted on the next build, which makes that build slower to start"
+		return c
+	}
+	hash, err := buildkitd.GetSettingsHash(ctx, d.opt.ContainerName, d.opt.Frontend)
+	if err != nil {
+		c.Status = StatusError
+		c.Message = err.Error()
+		return c
+	}
+	ok, err := d.opt.Settings.VerifyHash(hash)
+	if err != nil || !ok {
+		c.Status = StatusWarning
+		c.Message = "the running container was started with different settings"
+		c.Remediation = "buildkitd will be restarted on the next build. If this happens on every build, check for settings " +
+			"which differ between invocations (e.g. flags or EARTHLY_* environment variables set only in some shells)"
+		return c
+	}
+	c.Status = StatusOK
+	c.Message = "settings and image match; no restart is required"
+	return c
+}
+
+func (d *doctor) checkBuildkitConnection(ctx context.Context) *Check {
+	c := &Check{Name: "buildkitd connection"}
+	c.Details = map[string]string{"address": d.opt.Settings.BuildkitAddress}
+	bkClient, err := buildkitd.NewClientNoStart(ctx, d.opt.Settings)
+	if err != nil {
+		c.Status = StatusError
+		c.Message = err.Error()
+		if d.isLocal {
+			c.Remediation = "Run a build (or earthly bootstrap) to start buildkitd, and check its logs if it fails to start"
+		} else {
+			c.Remediation = "Check that the remote buildkitd is running, that buildkit_host is correct, and that the TLS settings match those of the server"
+		}
+		return c
+	}
+	defer bkClient.Close()
+	workers, err := bkClient.ListWorkers(ctx)
+	if err != nil {
+		c.Status = StatusError
+		c.Message = err.Error()
+		return c
+	}
+	d.workers = workers
+	if len(workers) == 0 {
+		c.Status = StatusError
+		c.Message = "buildkitd is reachable but reports no workers"
+		return c
+	}
+	c.Status = StatusOK
+	c.Message = fmt.Sprintf("reachable, %d worker(s)", len(workers))
+	for k, v := range workers[0].Labels {
+		if strings.HasPrefix(k, "org.mobyproject.buildkit.worker.") {
+			c.Details[strings.TrimPrefix(k, "org.mobyproject.buildkit.worker.")] = v
+		}
+	}
+	if d.container != nil {
+		// The buildkit API does not report its version, so report the image it runs instead.
+		c.Details["image"] = d.container.Image
+	}
+	return c
+}
+
+func (d *doctor) checkTLS(ctx context.Context) *Check {
+	c := &Check{Name: "TLS certificates"}
+	if !d.opt.Settings.UseTCP || !d.opt.Settings.UseTLS {
+		c.Status = StatusSkipped
+		c.Message = "TLS is not enabled"
+		return c
+	}
+	c.Details = map[string]string{}
+	ca, err := buildkitd.LoadCertificate(d.opt.Settings.TLSCA)
+	if err != nil {
+		c.Status = StatusError
+		c.Message = fmt.Sprintf("CA certificate: %s", err.Error())
+		c.Remediation = "Run earthly bootstrap to generate certificates, or point global.tlsca at a valid CA certificate"
+		return c
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	certs := map[string]string{
+		"CA":          d.opt.Settings.TLSCA,
+		"client cert": d.opt.Settings.ClientTLSCert,
+	}
+	if d.isLocal {
+		certs["server cert"] = d.opt.Settings.ServerTLSCert
+	}
+	names := make([]string, 0, len(certs))
+	for name := range certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.Status = StatusOK
+	c.Message = "certificates are valid"
+	now := time.Now()
+	for _, name := range names {
+		cert, err := buildkitd.LoadCertificate(certs[name])
+		if err != nil {
+			c.Status = StatusError
+			c.Message = fmt.Sprintf("%s: %s", name, err.Error())
+			c.Remediation = "Run earthly bootstrap to regenerate the certificates"
+			return c
+		}
+		c.Details[name+" expiry"] = cert.NotAfter.Format(time.RFC3339)
+		if now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+			c.Status = StatusError
+			c.Message = fmt.Sprintf("%s %s is not valid at the current time", name, certs[name])
+			c.Remediation = "Regenerate the certificates (for certificates generated by earthly, delete ~/.earthly/certs and run earthly bootstrap)"
+			return c
+		}
+		if name != "CA" {
+			_, err = cert.Verify(x509.VerifyOptions{
+				Roots:     roots,
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			if err != nil {
+				c.Status = StatusError
+				c.Message = fmt.Sprintf("%s %s is not signed by the CA %s: %s", name, certs[name], d.opt.Settings.TLSCA, err.Error())
+				c.Remediation = "Ensure that the certificates and the CA were generated together"
+				return c
+			}
+		}
+		if cert.NotAfter.Sub(now) < certExpiryWarning && c.Status == StatusOK {
+			c.Status = StatusWarning
+			c.Message = fmt.Sprintf("%s %s expires %s", name, certs[name], humanize.Time(cert.NotAfter))
+			c.Remediation = "Regenerate the certificates before they expire"
+		}
+	}
+	return c
+}
+
+func (d *doctor) checkCacheSize(ctx context.Context) *Check {
+	c := &Check{Name: "cache size"}
+	if !d.isLocal {
+		c.Status = StatusSkipped
+		c.Message = "the cache of a remote buildkitd cannot be inspected"
+		return c
+	}
+	infos, err := d.opt.Frontend.VolumeInfo(ctx, d.opt.Settings.VolumeName)
+	if err != nil {
+		c.Status = StatusWarning
+		c.Message = fmt.Sprintf("failed to inspect cache volume %s: %s", d.opt.Settings.VolumeName, err.Error())
+		return c
+	}
+	info, ok := infos[d.opt.Settings.VolumeName]
+	if !ok || info.Mountpoint == "" {
+		c.Status = StatusSkipped
+		c.Message = fmt.Sprintf("cache volume %s does not exist yet", d.opt.Settings.VolumeName)
+		return c
+	}
+	c.Details = map[string]string{
+		"volume": info.Name,
+		"size":   humanize.Bytes(info.Size),
+	}
+	if d.opt.Settings.CacheSizeMb <= 0 {
+		c.Status = StatusOK
+		c.Message = fmt.Sprintf("%s used; cache_size_mb is not set, so buildkit's default garbage collection policy applies", humanize.Bytes(info.Size))
+		return c
+	}
+	limit := uint64(d.opt.Settings.CacheSizeMb) * 1024 * 1024
+	c.Details["cache_size_mb"] = strconv.Itoa(d.opt.Settings.CacheSizeMb)
+	// Garbage collection only runs periodically, so allow for some overshoot.
+	if info.Size > limit+limit/10 {
+		c.Status = StatusWarning
+		c.Message = fmt.Sprintf("%s used, which exceeds the configured cache_size_mb of %s", humanize.Bytes(info.Size), humanize.Bytes(limit))
+		c.Remediation = "Run earthly cache prune to reclaim space now. If the cache keeps growing past the limit, check that buildkitd has been restarted since cache_size_mb was changed"
+		return c
+	}
+	c.Status = StatusOK
+	c.Message = fmt.Sprintf("%s used of %s", humanize.Bytes(info.Size), humanize.Bytes(limit))
+	return c
+}
+
+var (
+	iptablesDetectedRegexp = regexp.MustCompile(`Detected (iptables-\w+)`)
+	iptablesManualRegexp   = regexp.MustCompile(`Manual iptables specified \(([^)]+)\)`)
+	cniMtuRegexp           = regexp.MustCompile(`(?m)^CNI_MTU=(\d+)`)
+)
+
+func (d *doctor) checkIPTables(ctx context.Context) *Check {
+	c := &Check{Name: "iptables"}
+	if d.logs == "" {
+		c.Status = StatusSkipped
+		c.Message = "buildkitd logs are not available"
+		return c
+	}
+	if strings.Contains(d.logs, "both exited abnormally") {
+		c.Status = StatusError
+		c.Message = "buildkitd could not detect a working iptables implementation"
+		c.Remediation = "Set the iptables implementation explicitly, e.g. earthly config global.ip_tables iptables-legacy (or iptables-nft)"
+		return c
+	}
+	if m := iptablesManualRegexp.FindStringSubmatch(d.logs); m != nil {
+		c.Status = StatusOK
+		c.Message = fmt.Sprintf("using %s (configured via ip_tables)", m[1])
+		return c
+	}
+	if m := iptablesDetectedRegexp.FindStringSubmatch(d.logs); m != nil {
+		c.Status = StatusOK
+		c.Message = fmt.Sprintf("using %s (autodetected)", m[1])
+		return c
+	}
+	c.Status = StatusWarning
+	c.Message = "could not determine which iptables implementation buildkitd uses"
+	c.Remediation = "If RUN commands have no network access, try setting global.ip_tables to iptables-legacy or iptables-nft"
+	return c
+}
+
+func (d *doctor) checkMTU(ctx context.Context) *Check {
+	c := &Check{Name: "MTU"}
+	m := cniMtuRegexp.FindStringSubmatch(d.logs)
+	if m == nil {
+		c.Status = StatusSkipped
+		c.Message = "the MTU used by buildkitd is not available"
+		return c
+	}
+	mtu, err := strconv.Atoi(m[1])
+	if err != nil {
+		c.Status = StatusWarning
+		c.Message = fmt.Sprintf("invalid MTU %q reported by buildkitd", m[1])
+		return c
+	}
+	c.Details = map[string]string{"cni mtu": m[1]}
+	if d.opt.Settings.CniMtu != 0 {
+		c.Details["configured"] = strconv.Itoa(int(d.opt.Settings.CniMtu))
+	}
+	if runtime.GOOS == "linux" {
+		// Only on linux does the buildkitd container share the host network interfaces.
+		ifaces, _ := net.Interfaces()
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+				continue
+			}
+			if iface.MTU < mtu {
+				c.Status = StatusWarning
+				c.Message = fmt.Sprintf("interface %s has an MTU of %d, which is smaller than the MTU of %d used within buildkitd", iface.Name, iface.MTU, mtu)
+				c.Remediation = fmt.Sprintf("If network requests hang in RUN commands (e.g. when using a VPN), run: earthly config global.cni_mtu %d", iface.MTU)
+				return c
+			}
+		}
+	}
+	c.Status = StatusOK
+	c.Message = fmt.Sprintf("buildkitd uses an MTU of %d", mtu)
+	return c
+}
+
+func (d *doctor) checkMultiPlatform(ctx context.Context) *Check {
+	c := &Check{Name: "multi-platform"}
+	if len(d.workers) == 0 {
+		c.Status = StatusSkipped
+		c.Message = "buildkitd workers are not available"
+		return c
+	}
+	var platforms []string
+	for _, p := range d.workers[0].Platforms {
+		s := p.OS + "/" + p.Architecture
+		if p.Variant != "" {
+			s += "/" + p.Variant
+		}
+		platforms = append(platforms, s)
+	}
+	c.Details = map[string]string{"platforms": strings.Join(platforms, ", ")}
+	if !hasForeignArch(d.workers[0]) {
+		c.Status = StatusWarning
+		c.Message = "QEMU emulation (binfmt_misc) is not available; only the native platform can be built"
+		c.Remediation = fmt.Sprintf("To build for other platforms, install the emulators, e.g.: %s run --rm --privileged tonistiigi/binfmt --install all", d.opt.Frontend.Config().Binary)
+		return c
+	}
+	c.Status = StatusOK
+	c.Message = fmt.Sprintf("%d platforms available", len(platforms))
+	return c
+}
+
+// compatibleArchs lists the architectures which can be run natively (without emulation) by each host architecture.
+var compatibleArchs = map[string][]string{
+	"amd64": {"amd64", "386"},
+	"arm64": {"arm64", "arm"},
+}
+
+// hasForeignArch returns true if the worker supports a CPU architecture which cannot be run natively
+// by its first (native) one, which means that emulation is available.
+func hasForeignArch(w *client.WorkerInfo) bool {
+	if len(w.Platforms) == 0 {
+		return false
+	}
+	native := w.Platforms[0].Architecture
+	compatible, ok := compatibleArchs[native]
+	if !ok {
+		compatible = []string{native}
+	}
+outer:
+	for _, p := range w.Platforms[1:] {
+		for _, arch := range compatible {
+			if p.Architecture == arch {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
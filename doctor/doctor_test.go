@@ -0,0 +1,52 @@
+package doctor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCheckIPTables(t *testing.T) {
+	var tests = []struct {
+		logs    string
+		status  Status
+		message string
+	}{
+		{"", StatusSkipped, "buildkitd logs are not available"},
+		{"Detected iptables-legacy module\n", StatusOK, "using iptables-legacy (autodetected)"},
+		{"Manual iptables specified (iptables-nft)\n", StatusOK, "using iptables-nft (configured via ip_tables)"},
+		{"iptables-legacy and iptables-nft both exited abnormally\n", StatusError, "buildkitd could not detect a working iptables implementation"},
+		{"starting buildkitd\n", StatusWarning, "could not determine which iptables implementation buildkitd uses"},
+	}
+
+	for _, tt := range tests {
+		d := &doctor{logs: tt.logs}
+		c := d.checkIPTables(context.Background())
+		if c.Status != tt.status || c.Message != tt.message {
+			t.Errorf("checkIPTables(%q) = %s %q; want %s %q", tt.logs, c.Status, c.Message, tt.status, tt.message)
+		}
+	}
+}
+
+func TestHasForeignArch(t *testing.T) {
+	var tests = []struct {
+		platforms []specs.Platform
+		expected  bool
+	}{
+		{nil, false},
+		{[]specs.Platform{{OS: "linux", Architecture: "amd64"}}, false},
+		{[]specs.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "386"}}, false},
+		{[]specs.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "386"}, {OS: "linux", Architecture: "arm64"}}, true},
+		{[]specs.Platform{{OS: "linux", Architecture: "arm64"}, {OS: "linux", Architecture: "arm", Variant: "v7"}}, false},
+		{[]specs.Platform{{OS: "linux", Architecture: "arm"}, {OS: "linux", Architecture: "arm", Variant: "v6"}}, false},
+	}
+
+	for _, tt := range tests {
+		actual := hasForeignArch(&client.WorkerInfo{Platforms: tt.platforms})
+		if actual != tt.expected {
+			t.Errorf("hasForeignArch(%v) = %v; want %v", tt.platforms, actual, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package earthfile2dockerfile
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+
+	"github.com/pkg/errors"
+)
+
+// syntaxDirective enables RUN --mount in the generated Dockerfile.
+const syntaxDirective = "# syntax=docker/dockerfile:1.2"
+
+// Opt contains the options of an export.
+type Opt struct {
+	// Console is used to report the use of ineffective flags, in the same way builds do.
+	Console conslogging.ConsoleLogger
+	// OverridingVars are the build args passed to the exported target.
+	OverridingVars *variables.Scope
+}
+
+// Warning is an Earthfile construct which has been left out of the exported Dockerfile.
+type Warning struct {
+	SourceLocation *spec.SourceLocation
+	Message        string
+}
+
+func (w Warning) String() string {
+	if w.SourceLocation == nil {
+		return w.Message
+	}
+	return fmt.Sprintf("%s line %d:%d %s", w.SourceLocation.File, w.SourceLocation.StartLine, w.SourceLocation.StartColumn, w.Message)
+}
+
+// Export lowers a local target, together with the targets it inherits from or copies artifacts from, into a
+// multi-stage Dockerfile whose final stage is the target itself. The build context of the Dockerfile is the
+// directory of the target's Earthfile. User commands are inlined and ARGs are declared with the constant values
+// they have within each stage. Constructs which have no Dockerfile equivalent, such as WITH DOCKER, LOCALLY,
+// IF and FOR, result in an error; outputs which cannot be represented, such as SAVE ARTIFACT AS LOCAL, are left
+// out and reported as warnings.
+func Export(ctx context.Context, target domain.Target, opt Opt) ([]byte, []Warning, error) {
+	if target.IsRemote() {
+		return nil, nil, errors.Errorf("cannot export remote target %s; only targets of local Earthfiles can be exported", target.String())
+	}
+	if target.IsImportReference() {
+		return nil, nil, errors.Errorf("cannot export import reference %s", target.String())
+	}
+	if target.GetLocalPath() == "" {
+		target.LocalPath = "."
+	}
+	contextDir, err := filepath.Abs(target.GetLocalPath())
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "get absolute path of %s", target.GetLocalPath())
+	}
+	overriding := opt.OverridingVars
+	if overriding == nil {
+		overriding = variables.NewScope()
+	}
+	e := &exporter{
+		opt:        opt,
+		contextDir: contextDir,
+		earthfiles: make(map[string]spec.Earthfile),
+		stages:     make(map[string]*stage),
+		signatures: make(map[string]*stage),
+		visiting:   make(map[string]bool),
+		names:      make(map[string]bool),
+	}
+	s, err := e.stage(ctx, target, overriding)
+	if err != nil {
+		return nil, nil, err
+	}
+	e.use(s)
+	return e.render(target, s), e.warnings, nil
+}
+
+// stage is a stage of the generated Dockerfile, resulting from a target and the build args passed to it.
+type stage struct {
+	name string
+	// baseName is the name of the stage before it is made unique.
+	baseName string
+	target   domain.Target
+	// relDir is the directory of the target's Earthfile, relative to the build context.
+	relDir string
+
+	// from is the image the stage starts from, unless it starts from another stage.
+	from      string
+	fromStage *stage
+	lines     []string
+	deps      []*stage
+	// workdir is the final working directory of the stage. It is empty if it is unknown, as is the case
+	// for images which are not built by the Dockerfile.
+	workdir string
+
+	// artifacts contains the source and destination of each SAVE ARTIFACT of the target.
+	artifacts      []string
+	artifactsStage *stage
+	images         []string
+
+	envs          *variables.Scope
+	globals       *variables.Scope
+	globalImports map[string]domain.ImportTrackerVal
+
+	used bool
+}
+
+// signature returns a representation of the content of the stage.
+func (s *stage) signature() string {
+	from := s.from
+	if s.fromStage != nil {
+		from = "stage " + s.fromStage.name
+	}
+	parts := []string{from, s.workdir}
+	parts = append(parts, s.lines...)
+	parts = append(parts, "artifacts")
+	parts = append(parts, s.artifacts...)
+	parts = append(parts, "images")
+	parts = append(parts, s.images...)
+	return strings.Join(parts, "\n")
+}
+
+// empty returns true if the stage is equivalent to scratch, as is the case for a base recipe without a FROM.
+func (s *stage) empty() bool {
+	return s.from == "" && s.fromStage == nil && len(s.lines) == 0
+}
+
+type exporter struct {
+	opt        Opt
+	contextDir string
+	earthfiles map[string]spec.Earthfile
+	stages     map[string]*stage
+	// signatures holds the stages by their content, such that equivalent stages are only emitted once.
+	signatures map[string]*stage
+	visiting   map[string]bool
+	names      map[string]bool
+	order      []*stage
+	warnings   []Warning
+}
+
+func (e *exporter) warn(sl *spec.SourceLocation, format string, args ...interface{}) {
+	w := Warning{SourceLocation: sl, Message: fmt.Sprintf(format, args...)}
+	for _, existing := range e.warnings {
+		if existing.String() == w.String() {
+			// The same target may be converted once for each set of build args it is invoked with.
+			return
+		}
+	}
+	e.warnings = append(e.warnings, w)
+}
+
+// earthfile returns the parsed Earthfile of the given local reference, together with its directory
+// relative to the build context.
+func (e *exporter) earthfile(ctx context.Context, ref domain.Reference) (spec.Earthfile, string, error) {
+	dir, err := filepath.Abs(ref.GetLocalPath())
+	if err != nil {
+		return spec.Earthfile{}, "", errors.Wrapf(err, "get absolute path of %s", ref.GetLocalPath())
+	}
+	relDir, err := filepath.Rel(e.contextDir, dir)
+	if err != nil || relDir == ".." || strings.HasPrefix(relDir, "../") {
+		return spec.Earthfile{}, "", errors.Errorf("%s is outside of the build context %s", ref.String(), e.contextDir)
+	}
+	ef, ok := e.earthfiles[dir]
+	if !ok {
+		ef, err = ast.Parse(ctx, filepath.Join(dir, "Earthfile"), true)
+		if err != nil {
+			return spec.Earthfile{}, "", err
+		}
+		e.earthfiles[dir] = ef
+	}
+	return ef, filepath.ToSlash(relDir), nil
+}
+
+// stage converts the given target, invoked with the given build args, into a stage. Each combination of
+// target and build args is converted only once.
+func (e *exporter) stage(ctx context.Context, target domain.Target, overriding *variables.Scope) (*stage, error) {
+	key := stageKey(target, overriding)
+	if s, ok := e.stages[key]; ok {
+		return s, nil
+	}
+	if e.visiting[key] {
+		return nil, errors.Errorf("cyclic reference to %s", target.String())
+	}
+	e.visiting[key] = true
+	defer delete(e.visiting, key)
+
+	ef, relDir, err := e.earthfile(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	s := &stage{
+		baseName: stageName(relDir, target.GetName()),
+		target:   target,
+		relDir:   relDir,
+		workdir:  "/",
+		envs:     variables.NewScope(),
+	}
+	t := newTargetExporter(e, s, overriding)
+	if target.Target == "base" {
+		t.isBase = true
+		err = t.handleBlock(ctx, ef.BaseRecipe)
+	} else {
+		err = t.handleTarget(ctx, ef)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.envs = t.vars.EnvVars()
+	s.globals = t.vars.Globals()
+	s.globalImports = t.vars.Imports().Global()
+
+	// Targets invoked with build args which they do not use result in the same stage.
+	signature := s.signature()
+	if existing, ok := e.signatures[signature]; ok {
+		s = existing
+	} else {
+		s.name = e.uniqueName(s.baseName)
+		e.signatures[signature] = s
+	}
+	e.stages[key] = s
+	return s, nil
+}
+
+// artifactsStage returns a scratch stage holding the artifacts saved by s, in the same layout as they are
+// referenced by COPY +target/artifact.
+func (e *exporter) artifactsStage(s *stage) *stage {
+	if s.artifactsStage == nil {
+		s.artifactsStage = &stage{
+			name: e.uniqueName(s.name + "-artifacts"),
+			deps: []*stage{s},
+		}
+		for _, artifact := range s.artifacts {
+			s.artifactsStage.lines = append(s.artifactsStage.lines, fmt.Sprintf("COPY --from=%s %s", s.name, artifact))
+		}
+	}
+	return s.artifactsStage
+}
+
+// use marks a stage as part of the output, after the stages it depends on.
+func (e *exporter) use(s *stage) {
+	if s.used {
+		return
+	}
+	s.used = true
+	if s.fromStage != nil {
+		e.use(s.fromStage)
+	}
+	for _, dep := range s.deps {
+		e.use(dep)
+	}
+	e.order = append(e.order, s)
+}
+
+func (e *exporter) uniqueName(name string) string {
+	unique := name
+	for i := 2; e.names[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	e.names[unique] = true
+	return unique
+}
+
+func (e *exporter) render(target domain.Target, final *stage) []byte {
+	lines := []string{
+		syntaxDirective,
+		fmt.Sprintf("# This Dockerfile was generated by earthly export-dockerfile from %s.", target.String()),
+		"# Its build context is the directory of the Earthfile.",
+	}
+	if len(final.images) > 0 {
+		lines = append(lines, fmt.Sprintf("# The target saves the image %s; use docker build -t to tag it.", strings.Join(final.images, ", ")))
+	}
+	for _, s := range e.order {
+		from := s.from
+		if s.fromStage != nil {
+			from = s.fromStage.name
+		}
+		if from == "" {
+			from = "scratch"
+		}
+		lines = append(lines, "", fmt.Sprintf("FROM %s AS %s", from, s.name))
+		lines = append(lines, s.lines...)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func stageKey(target domain.Target, overriding *variables.Scope) string {
+	parts := []string{target.StringCanonical()}
+	for _, name := range overriding.SortedAny() {
+		value, _ := overriding.GetAny(name)
+		parts = append(parts, fmt.Sprintf("%s=%s", name, value))
+	}
+	return strings.Join(parts, " ")
+}
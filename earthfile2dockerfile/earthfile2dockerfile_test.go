@@ -0,0 +1,187 @@
+package earthfile2dockerfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+)
+
+const testEarthfile = `VERSION 0.5
+IMPORT ./lib
+FROM golang:1.16-alpine
+ARG GO_VERSION=1.16
+WORKDIR /src
+
+deps:
+    COPY go.mod go.sum ./
+    RUN --mount=type=cache,target=/root/.cache go mod download
+    SAVE ARTIFACT go.mod AS LOCAL go.mod
+
+build:
+    FROM +deps
+    ARG VERSION=dev
+    DO lib+GO_BUILD --OUT=/out/app
+    RUN --secret TOKEN=+secrets/token --ssh echo "$VERSION"
+    SAVE ARTIFACT /out/app app
+    SAVE ARTIFACT /out/*.txt docs/
+
+docker:
+    FROM alpine:3.14
+    COPY +build/app /usr/local/bin/app
+    COPY --dir (+build/docs --VERSION=1.0) lib+assets/static ./
+    ENV APP_ENV=prod
+    ENTRYPOINT ["/usr/local/bin/app", "$APP_ENV"]
+    BUILD +deps
+    SAVE IMAGE myorg/app:latest
+`
+
+const testLibEarthfile = `VERSION 0.5
+
+GO_BUILD:
+    COMMAND
+    ARG OUT=/out/bin
+    RUN go build -o $OUT ./...
+
+assets:
+    FROM alpine
+    COPY static /static
+    SAVE ARTIFACT /static
+`
+
+func writeEarthfiles(t *testing.T, earthfiles map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range earthfiles {
+		p := filepath.Join(dir, name, "Earthfile")
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func export(t *testing.T, dir, targetName string, overriding *variables.Scope) (string, []Warning, error) {
+	target, err := domain.ParseTarget(dir + "+" + targetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := Opt{
+		Console:        conslogging.Current(conslogging.NoColor, conslogging.DefaultPadding, false),
+		OverridingVars: overriding,
+	}
+	dockerfile, warnings, err := Export(context.Background(), target, opt)
+	return string(dockerfile), warnings, err
+}
+
+func TestExport(t *testing.T) {
+	dir := writeEarthfiles(t, map[string]string{".": testEarthfile, "lib": testLibEarthfile})
+	out, warnings, err := export(t, dir, "docker", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# syntax=docker/dockerfile:1.2\n",
+		"FROM golang:1.16-alpine AS base\nARG GO_VERSION=1.16\nWORKDIR /src\n",
+		"FROM base AS deps\nARG GO_VERSION=1.16\nCOPY go.mod go.sum ./\n",
+		"RUN --mount=type=cache,id=deps/root/.cache,target=/root/.cache go mod download\n",
+		"FROM deps AS build\nARG OUT=/out/app\nRUN go build -o $OUT ./...\n",
+		"ARG VERSION=dev\nRUN --mount=type=ssh --mount=type=secret,id=token export TOKEN=\"$(cat /run/secrets/token)\" && echo \"$VERSION\"\n",
+		"FROM deps AS build-2\n",
+		"ARG VERSION=1.0\n",
+		"FROM scratch AS build-artifacts\nCOPY --from=build /out/app /app\n",
+		"FROM scratch AS build-2-artifacts\nCOPY --from=build-2 /out/app /app\nCOPY --from=build-2 /out/*.txt /docs/\n",
+		"FROM alpine AS lib-assets\nCOPY lib/static /static\n",
+		"FROM alpine:3.14 AS docker\n",
+		"COPY --from=build-artifacts /app /usr/local/bin/app\n",
+		"COPY --from=build-2-artifacts /docs docs/\n",
+		"COPY --from=lib-assets-artifacts /static static/\n",
+		"ENV APP_ENV=prod\n",
+		"ENTRYPOINT [\"/usr/local/bin/app\",\"prod\"]\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	// The base and deps targets do not use VERSION, so they are emitted only once.
+	for _, unwanted := range []string{"AS base-2", "AS deps-2"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, out)
+		}
+	}
+	if !strings.HasSuffix(out, "ENTRYPOINT [\"/usr/local/bin/app\",\"prod\"]\n") {
+		t.Errorf("the exported target is not the final stage:\n%s", out)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("got %d warnings, want 2: %v", len(warnings), warnings)
+	}
+}
+
+func TestExportBuildArgs(t *testing.T) {
+	dir := writeEarthfiles(t, map[string]string{".": testEarthfile, "lib": testLibEarthfile})
+	overriding, err := variables.ParseCommandLineArgs([]string{"VERSION=2.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := export(t, dir, "build", overriding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "ARG VERSION=2.0\n") {
+		t.Errorf("output does not contain the overridden build arg:\n%s", out)
+	}
+}
+
+func TestExportUnsupported(t *testing.T) {
+	for name, recipe := range map[string]string{
+		"with docker": "    WITH DOCKER\n        RUN true\n    END\n",
+		"locally":     "    LOCALLY\n",
+		"if":          "    IF true\n        RUN true\n    END\n",
+		"for":         "    FOR x IN a b\n        RUN true\n    END\n",
+		"run $()":     "    ARG X=$(date)\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeEarthfiles(t, map[string]string{".": "VERSION 0.5\nFROM alpine\n\ntarget:\n" + recipe})
+			_, _, err := export(t, dir, "target", nil)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestStageName(t *testing.T) {
+	for _, tc := range []struct {
+		relDir, target, want string
+	}{
+		{".", "build", "build"},
+		{"lib", "assets", "lib-assets"},
+		{"services/API", "Build_Image", "services-api-build_image"},
+		{".", "1st", "stage-1st"},
+	} {
+		if got := stageName(tc.relDir, tc.target); got != tc.want {
+			t.Errorf("stageName(%q, %q) = %q; want %q", tc.relDir, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"1.16":        "1.16",
+		"my app":      `"my app"`,
+		`$HOME "x"\`:  `"\$HOME \"x\"\\"`,
+		"a=b,c:d@e/f": "a=b,c:d@e/f",
+	} {
+		if got := quote(in); got != want {
+			t.Errorf("quote(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
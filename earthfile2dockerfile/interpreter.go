@@ -0,0 +1,823 @@
+package earthfile2dockerfile
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/util/flagutil"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/variables"
+
+	"github.com/pkg/errors"
+)
+
+// platformArgs are the builtin args which docker build also provides. They are declared without a value,
+// such that the platform of the docker build is used.
+var platformArgs = map[string]bool{
+	"TARGETPLATFORM": true,
+	"TARGETOS":       true,
+	"TARGETARCH":     true,
+	"TARGETVARIANT":  true,
+}
+
+// targetExporter interprets the recipe of a single target into a stage, in the same way as the
+// earthfile2llb interpreter.
+type targetExporter struct {
+	e      *exporter
+	s      *stage
+	vars   *variables.Collection
+	isBase bool
+	// declared holds the ARG lines declared within the current Dockerfile stage, by name.
+	declared map[string]string
+}
+
+func newTargetExporter(e *exporter, s *stage, overriding *variables.Scope) *targetExporter {
+	return &targetExporter{
+		e:        e,
+		s:        s,
+		vars:     variables.NewCollection(e.opt.Console, s.target, llbutil.DefaultPlatform(), nil, overriding, nil),
+		declared: make(map[string]string),
+	}
+}
+
+func (t *targetExporter) handleTarget(ctx context.Context, ef spec.Earthfile) error {
+	for _, target := range ef.Targets {
+		if target.Name == t.s.target.Target {
+			// Apply implicit FROM +base
+			err := t.fromTarget(ctx, "+base", nil, target.SourceLocation)
+			if err != nil {
+				return err
+			}
+			return t.handleBlock(ctx, target.Recipe)
+		}
+	}
+	return t.errorf(ef.SourceLocation, "target %s not found", t.s.target.Target)
+}
+
+func (t *targetExporter) handleBlock(ctx context.Context, b spec.Block) error {
+	for _, stmt := range b {
+		err := t.handleStatement(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *targetExporter) handleStatement(ctx context.Context, stmt spec.Statement) error {
+	switch {
+	case stmt.Command != nil:
+		return t.handleCommand(ctx, *stmt.Command)
+	case stmt.With != nil:
+		return t.errorf(stmt.SourceLocation, "WITH %s has no Dockerfile equivalent", stmt.With.Command.Name)
+	case stmt.If != nil:
+		return t.errorf(stmt.SourceLocation, "IF has no Dockerfile equivalent")
+	case stmt.For != nil:
+		return t.errorf(stmt.SourceLocation, "FOR has no Dockerfile equivalent")
+	default:
+		return t.errorf(stmt.SourceLocation, "unexpected statement type")
+	}
+}
+
+func (t *targetExporter) handleCommand(ctx context.Context, cmd spec.Command) error {
+	switch cmd.Name {
+	case "FROM":
+		return t.handleFrom(ctx, cmd)
+	case "RUN":
+		return t.handleRun(ctx, cmd)
+	case "COPY":
+		return t.handleCopy(ctx, cmd)
+	case "SAVE ARTIFACT":
+		return t.handleSaveArtifact(ctx, cmd)
+	case "SAVE IMAGE":
+		return t.handleSaveImage(ctx, cmd)
+	case "BUILD":
+		t.e.warn(cmd.SourceLocation, "BUILD %s has no Dockerfile equivalent and has been left out", strings.Join(cmd.Args, " "))
+		return nil
+	case "WORKDIR":
+		return t.handleWorkdir(ctx, cmd)
+	case "USER", "EXPOSE", "VOLUME":
+		if len(cmd.Args) == 0 {
+			return t.errorf(cmd.SourceLocation, "no arguments provided to the %s command", cmd.Name)
+		}
+		t.emit(fmt.Sprintf("%s %s", cmd.Name, strings.Join(cmd.Args, " ")))
+		return nil
+	case "CMD", "ENTRYPOINT":
+		return t.handleCmd(ctx, cmd)
+	case "ENV":
+		return t.handleEnv(ctx, cmd)
+	case "ARG":
+		return t.handleArg(ctx, cmd)
+	case "LABEL":
+		return t.handleLabel(ctx, cmd)
+	case "HEALTHCHECK":
+		return t.handleHealthcheck(ctx, cmd)
+	case "DO":
+		return t.handleDo(ctx, cmd)
+	case "IMPORT":
+		return t.handleImport(ctx, cmd)
+	case "COMMAND":
+		return t.errorf(cmd.SourceLocation, "command COMMAND not allowed in a target definition")
+	case "LOCALLY", "FROM DOCKERFILE", "GIT CLONE":
+		return t.errorf(cmd.SourceLocation, "%s has no Dockerfile equivalent", cmd.Name)
+	case "ADD", "STOPSIGNAL", "ONBUILD", "SHELL":
+		return t.errorf(cmd.SourceLocation, "command %s not supported", cmd.Name)
+	default:
+		return t.errorf(cmd.SourceLocation, "unexpected command %s", cmd.Name)
+	}
+}
+
+func (t *targetExporter) handleFrom(ctx context.Context, cmd spec.Command) error {
+	opts := earthfile2llb.FromOpts{}
+	args, err := flagutil.ParseArgs("FROM", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid FROM arguments %v", cmd.Args)
+	}
+	if len(args) < 1 {
+		return t.errorf(cmd.SourceLocation, "invalid number of arguments for FROM: %s", cmd.Args)
+	}
+	if len(args) == 3 && args[1] == "AS" {
+		return t.errorf(cmd.SourceLocation, "AS not supported, use earthly targets instead")
+	}
+	if len(t.s.artifacts) > 0 {
+		return t.errorf(cmd.SourceLocation, "FROM after SAVE ARTIFACT cannot be exported")
+	}
+	imageName := t.expandArgs(args[0], true)
+	platform := t.expandArgs(opts.Platform, false)
+	if !strings.Contains(imageName, "+") {
+		if len(args) > 1 {
+			return t.errorf(cmd.SourceLocation, "invalid number of arguments for FROM: %s", cmd.Args)
+		}
+		from := imageName
+		if platform != "" {
+			from = fmt.Sprintf("--platform=%s %s", platform, imageName)
+		}
+		t.startStage(from, nil, "", variables.NewScope())
+		return nil
+	}
+	if platform != "" {
+		return t.errorf(cmd.SourceLocation, "FROM --platform cannot be exported for targets")
+	}
+	buildArgs, err := t.buildArgs(opts.BuildArgs, args[1:])
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "parse build args")
+	}
+	return t.fromTarget(ctx, imageName, buildArgs, cmd.SourceLocation)
+}
+
+func (t *targetExporter) fromTarget(ctx context.Context, targetName string, buildArgs []string, sl *spec.SourceLocation) error {
+	relTarget, dep, err := t.resolveTarget(ctx, targetName, buildArgs)
+	if err != nil {
+		return t.wrapError(err, sl, "apply FROM %s", targetName)
+	}
+	if dep.empty() {
+		t.startStage("", nil, dep.workdir, dep.envs)
+	} else {
+		t.startStage("", dep, dep.workdir, dep.envs)
+	}
+	if !relTarget.IsExternal() {
+		// Propagate globals.
+		t.vars.SetGlobals(dep.globals.Clone())
+		t.vars.Imports().SetGlobal(dep.globalImports)
+	}
+	return nil
+}
+
+// startStage discards the current state of the stage, as a FROM does.
+func (t *targetExporter) startStage(from string, fromStage *stage, workdir string, envs *variables.Scope) {
+	t.s.from = from
+	t.s.fromStage = fromStage
+	t.s.lines = nil
+	t.s.workdir = workdir
+	t.vars.ResetEnvVars(envs.Clone())
+	t.declared = make(map[string]string)
+}
+
+// resolveTarget returns the stage of the given target reference, relative to the current Earthfile.
+func (t *targetExporter) resolveTarget(ctx context.Context, targetName string, buildArgs []string) (domain.Target, *stage, error) {
+	relTarget, err := domain.ParseTarget(targetName)
+	if err != nil {
+		return domain.Target{}, nil, errors.Wrapf(err, "earthly target parse %s", targetName)
+	}
+	derefed, _, _, err := t.vars.Imports().Deref(relTarget)
+	if err != nil {
+		return domain.Target{}, nil, err
+	}
+	ref, err := domain.JoinReferences(t.vars.AbsRef(), derefed)
+	if err != nil {
+		return domain.Target{}, nil, errors.Wrap(err, "join targets")
+	}
+	target := ref.(domain.Target)
+	if target.IsRemote() {
+		return domain.Target{}, nil, errors.Errorf("remote target %s cannot be exported", target.String())
+	}
+	overriding, err := variables.ParseArgs(buildArgs, t.processNonConstantBuildArg, t.vars)
+	if err != nil {
+		return domain.Target{}, nil, errors.Wrap(err, "parse build args")
+	}
+	// Don't allow transitive overriding variables to cross project boundaries.
+	if !relTarget.IsExternal() {
+		overriding = variables.CombineScopes(overriding, t.vars.Overriding())
+	}
+	dep, err := t.e.stage(ctx, target, overriding)
+	if err != nil {
+		return domain.Target{}, nil, err
+	}
+	return relTarget, dep, nil
+}
+
+func (t *targetExporter) handleRun(ctx context.Context, cmd spec.Command) error {
+	if len(cmd.Args) < 1 {
+		return t.errorf(cmd.SourceLocation, "not enough arguments for RUN")
+	}
+	opts := earthfile2llb.RunOpts{}
+	args, err := flagutil.ParseArgs("RUN", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid RUN arguments %v", cmd.Args)
+	}
+	switch {
+	case opts.Push:
+		return t.errorf(cmd.SourceLocation, "RUN --push has no Dockerfile equivalent")
+	case opts.Privileged || opts.WithDocker:
+		return t.errorf(cmd.SourceLocation, "RUN --privileged has no Dockerfile equivalent")
+	case opts.Interactive || opts.InteractiveKeep:
+		return t.errorf(cmd.SourceLocation, "interactive RUN has no Dockerfile equivalent")
+	case opts.WithEntrypoint:
+		return t.errorf(cmd.SourceLocation, "RUN --entrypoint has no Dockerfile equivalent")
+	}
+	if opts.NoCache {
+		t.e.warn(cmd.SourceLocation, "RUN --no-cache has no Dockerfile equivalent and has been left out")
+	}
+
+	var flags []string
+	for _, m := range opts.Mounts {
+		mount, err := t.dockerMount(t.expandArgs(m, false))
+		if err != nil {
+			return t.wrapError(err, cmd.SourceLocation, "parse mount %s", m)
+		}
+		flags = append(flags, "--mount="+mount)
+	}
+	if opts.WithSSH {
+		flags = append(flags, "--mount=type=ssh")
+	}
+	var secretEnvs []string
+	for _, s := range opts.Secrets {
+		s = t.expandArgs(s, true)
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return t.errorf(cmd.SourceLocation, "invalid secret definition %s", s)
+		}
+		if parts[1] == "" {
+			// Optional secret which has not been set.
+			continue
+		}
+		if !strings.HasPrefix(parts[1], "+secrets/") {
+			return t.errorf(cmd.SourceLocation, "secret definition %s not supported. Must start with +secrets/ or be an empty string", s)
+		}
+		secretID := strings.TrimPrefix(parts[1], "+secrets/")
+		flags = append(flags, fmt.Sprintf("--mount=type=secret,id=%s", secretID))
+		secretEnvs = append(secretEnvs, fmt.Sprintf("%s=\"$(cat %s)\"", parts[0], path.Join("/run/secrets", secretID)))
+	}
+
+	var command string
+	if cmd.ExecMode {
+		if len(secretEnvs) > 0 {
+			return t.errorf(cmd.SourceLocation, "RUN --secret cannot be exported for the exec form; use the shell form instead")
+		}
+		command = jsonArray(args)
+	} else {
+		command = strings.Join(args, " ")
+		if len(secretEnvs) > 0 {
+			command = fmt.Sprintf("export %s && %s", strings.Join(secretEnvs, " "), command)
+		}
+	}
+	t.emit(strings.Join(append(append([]string{"RUN"}, flags...), command), " "))
+	return nil
+}
+
+// dockerMount translates an Earthly RUN --mount value into its Dockerfile equivalent.
+func (t *targetExporter) dockerMount(mount string) (string, error) {
+	var mountType, mountID, mountTarget, scope string
+	var rest []string
+	for _, kvPair := range strings.Split(mount, ",") {
+		kvSplit := strings.SplitN(kvPair, "=", 2)
+		switch kvSplit[0] {
+		case "type", "id", "scope", "target":
+			if len(kvSplit) != 2 {
+				return "", errors.Errorf("invalid mount arg %s", kvPair)
+			}
+			switch kvSplit[0] {
+			case "type":
+				mountType = kvSplit[1]
+			case "id":
+				mountID = kvSplit[1]
+			case "scope":
+				scope = kvSplit[1]
+			case "target":
+				mountTarget = kvSplit[1]
+				rest = append(rest, kvPair)
+			}
+		case "source", "ro", "readonly", "sharing":
+			rest = append(rest, kvPair)
+		default:
+			return "", errors.Errorf("mount arg %s cannot be exported", kvPair)
+		}
+	}
+	var parts []string
+	switch mountType {
+	case "cache":
+		parts = append(parts, "type=cache")
+		if mountID == "" {
+			mountID = path.Clean(mountTarget)
+		}
+		if scope != "global" {
+			// Cache mounts are private to the target by default, whereas Dockerfiles share them by id.
+			mountID = path.Join(t.s.baseName, mountID)
+		}
+		parts = append(parts, "id="+mountID)
+	case "tmpfs":
+		parts = append(parts, "type=tmpfs")
+	case "secret":
+		parts = append(parts, "type=secret", "id="+strings.TrimPrefix(mountID, "+secrets/"))
+	case "ssh-experimental":
+		parts = append(parts, "type=ssh")
+		if mountID != "" {
+			parts = append(parts, "id="+mountID)
+		}
+	case "bind-experimental":
+		return "", errors.New("bind mounts of host paths have no Dockerfile equivalent")
+	case "":
+		return "", errors.New("mount type not specified")
+	default:
+		return "", errors.Errorf("mount type %s cannot be exported", mountType)
+	}
+	return strings.Join(append(parts, rest...), ","), nil
+}
+
+func (t *targetExporter) handleCopy(ctx context.Context, cmd spec.Command) error {
+	opts := earthfile2llb.CopyOpts{}
+	args, err := flagutil.ParseArgs("COPY", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid COPY arguments %v", cmd.Args)
+	}
+	if len(args) < 2 {
+		return t.errorf(cmd.SourceLocation, "not enough COPY arguments %v", cmd.Args)
+	}
+	if opts.From != "" {
+		return t.errorf(cmd.SourceLocation, "COPY --from not implemented. Use COPY artifacts form instead")
+	}
+	if opts.IfExists {
+		return t.errorf(cmd.SourceLocation, "COPY --if-exists has no Dockerfile equivalent")
+	}
+	if opts.Platform != "" {
+		return t.errorf(cmd.SourceLocation, "COPY --platform cannot be exported")
+	}
+	if opts.KeepOwn || opts.SymlinkNoFollow {
+		t.e.warn(cmd.SourceLocation, "COPY --keep-own and --symlink-no-follow have no Dockerfile equivalent and have been left out")
+	}
+	srcs := args[:len(args)-1]
+	dest := args[len(args)-1]
+	var flags []string
+	if opts.Chown != "" {
+		flags = append(flags, "--chown="+opts.Chown)
+	}
+
+	artifacts := make([]domain.Artifact, len(srcs))
+	srcFlagArgs := make([][]string, len(srcs))
+	allClassical := true
+	allArtifacts := true
+	for index, src := range srcs {
+		var parseErr error
+		if strings.HasPrefix(src, "(") && strings.HasSuffix(src, ")") {
+			// COPY (<src> <flag-args>) ...
+			artifactStr, extraArgs, err := earthfile2llb.ParseParans(src)
+			if err != nil {
+				return t.wrapError(err, cmd.SourceLocation, "parse parans %s", src)
+			}
+			artifacts[index], parseErr = domain.ParseArtifact(t.expandArgs(artifactStr, true))
+			if parseErr != nil {
+				return t.wrapError(parseErr, cmd.SourceLocation, "parse artifact")
+			}
+			srcFlagArgs[index] = extraArgs
+		} else {
+			artifacts[index], parseErr = domain.ParseArtifact(t.expandArgs(src, true))
+		}
+		if parseErr == nil {
+			allClassical = false
+		} else {
+			allArtifacts = false
+		}
+	}
+	if !allClassical && !allArtifacts {
+		return t.errorf(cmd.SourceLocation, "combining artifacts and build context arguments in a single COPY command is not allowed: %v", srcs)
+	}
+	if dest == "" || dest == "." || (len(srcs) > 1 && !strings.HasSuffix(dest, "/")) {
+		dest += "/"
+	}
+
+	if allClassical {
+		if len(opts.BuildArgs) != 0 {
+			return t.errorf(cmd.SourceLocation, "build args not supported for non +artifact arguments case %v", cmd.Args)
+		}
+		// Paths are relative to the Earthfile, whereas they are relative to the build context in the Dockerfile.
+		for index, src := range srcs {
+			srcs[index] = path.Join(t.s.relDir, src)
+		}
+		t.emitCopy(flags, srcs, dest, opts.IsDirCopy)
+		return nil
+	}
+
+	for index, artifact := range artifacts {
+		buildArgs, err := t.buildArgs(opts.BuildArgs, srcFlagArgs[index])
+		if err != nil {
+			return t.wrapError(err, cmd.SourceLocation, "parse build args")
+		}
+		_, dep, err := t.resolveTarget(ctx, artifact.Target.String(), buildArgs)
+		if err != nil {
+			return t.wrapError(err, cmd.SourceLocation, "copy artifact %s", artifact.String())
+		}
+		artifactsStage := t.e.artifactsStage(dep)
+		t.s.deps = append(t.s.deps, artifactsStage)
+		srcPath := path.Join("/", artifact.Artifact)
+		t.emitCopy(append([]string{"--from=" + artifactsStage.name}, flags...), []string{srcPath}, dest, opts.IsDirCopy)
+	}
+	return nil
+}
+
+// emitCopy emits COPY lines for the given sources. Dockerfiles always copy the contents of directories,
+// so COPY --dir is emitted as a separate COPY per source, into a directory of the same name.
+func (t *targetExporter) emitCopy(flags []string, srcs []string, dest string, isDir bool) {
+	if !isDir {
+		t.emit(fmt.Sprintf("COPY %s", strings.Join(append(append(flags, srcs...), dest), " ")))
+		return
+	}
+	for _, src := range srcs {
+		srcDest := dest
+		if strings.HasSuffix(dest, "/") && !hasWildcard(src) {
+			srcDest = path.Join(dest, path.Base(src)) + "/"
+		}
+		t.emit(fmt.Sprintf("COPY %s", strings.Join(append(append(append([]string{}, flags...), src), srcDest), " ")))
+	}
+}
+
+func (t *targetExporter) handleSaveArtifact(ctx context.Context, cmd spec.Command) error {
+	opts := earthfile2llb.SaveArtifactOpts{}
+	args, err := flagutil.ParseArgs("SAVE ARTIFACT", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid SAVE ARTIFACT arguments %v", cmd.Args)
+	}
+	if len(args) == 0 {
+		return t.errorf(cmd.SourceLocation, "no arguments provided to the SAVE ARTIFACT command")
+	}
+	if len(args) > 5 || len(args) == 3 {
+		return t.errorf(cmd.SourceLocation, "invalid arguments for SAVE ARTIFACT command: %v", cmd.Args)
+	}
+	saveTo := "./"
+	if len(args) >= 4 {
+		if strings.Join(args[len(args)-3:len(args)-1], " ") != "AS LOCAL" {
+			return t.errorf(cmd.SourceLocation, "invalid arguments for SAVE ARTIFACT command: %v", cmd.Args)
+		}
+		t.e.warn(cmd.SourceLocation, "SAVE ARTIFACT AS LOCAL has no Dockerfile equivalent; the artifact is not output")
+		if len(args) == 5 {
+			saveTo = args[1]
+		}
+	} else if len(args) == 2 {
+		saveTo = args[1]
+	}
+	if opts.IfExists {
+		return t.errorf(cmd.SourceLocation, "SAVE ARTIFACT --if-exists has no Dockerfile equivalent")
+	}
+	saveFrom := t.expandArgs(args[0], false)
+	saveTo = t.expandArgs(saveTo, false)
+
+	// Paths copied from another stage are relative to its root, rather than to its working directory.
+	absSaveFrom := saveFrom
+	if !path.IsAbs(saveFrom) {
+		if t.s.workdir == "" {
+			t.e.warn(cmd.SourceLocation, "the working directory of %s is unknown; %s is assumed to be relative to /", t.s.from, saveFrom)
+			t.s.workdir = "/"
+		}
+		absSaveFrom = path.Join(t.s.workdir, saveFrom)
+	}
+	if absSaveFrom == "/" {
+		return t.errorf(cmd.SourceLocation, "cannot save root dir as artifact")
+	}
+	saveToAdjusted := saveTo
+	if saveTo == "" || saveTo == "." || strings.HasSuffix(saveTo, "/") {
+		saveToAdjusted = path.Join(saveTo, path.Base(absSaveFrom))
+	}
+	saveToD, saveToF := splitWildcards(saveToAdjusted)
+	if saveToF != "" {
+		saveToAdjusted = saveToD + "/"
+	}
+	dest := path.Join("/", saveToAdjusted)
+	if strings.HasSuffix(saveToAdjusted, "/") && dest != "/" {
+		dest += "/"
+	}
+	t.s.artifacts = append(t.s.artifacts, fmt.Sprintf("%s %s", absSaveFrom, dest))
+	return nil
+}
+
+func (t *targetExporter) handleSaveImage(ctx context.Context, cmd spec.Command) error {
+	opts := earthfile2llb.SaveImageOpts{}
+	args, err := flagutil.ParseArgs("SAVE IMAGE", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid SAVE IMAGE arguments %v", cmd.Args)
+	}
+	if opts.Push {
+		t.e.warn(cmd.SourceLocation, "SAVE IMAGE --push has no Dockerfile equivalent; push the image after building it")
+	}
+	for _, img := range args {
+		t.s.images = append(t.s.images, t.expandArgs(img, false))
+	}
+	return nil
+}
+
+func (t *targetExporter) handleWorkdir(ctx context.Context, cmd spec.Command) error {
+	if len(cmd.Args) != 1 {
+		return t.errorf(cmd.SourceLocation, "invalid number of arguments for WORKDIR: %v", cmd.Args)
+	}
+	workdir := t.expandArgs(cmd.Args[0], false)
+	if path.IsAbs(workdir) {
+		t.s.workdir = path.Clean(workdir)
+	} else if t.s.workdir != "" {
+		t.s.workdir = path.Join(t.s.workdir, workdir)
+	}
+	t.emit("WORKDIR " + cmd.Args[0])
+	return nil
+}
+
+func (t *targetExporter) handleCmd(ctx context.Context, cmd spec.Command) error {
+	if !cmd.ExecMode {
+		t.emit(fmt.Sprintf("%s %s", cmd.Name, strings.Join(cmd.Args, " ")))
+		return nil
+	}
+	// The exec form is expanded by Earthly, but not by docker build.
+	args := earthfile2llb.GetArgsCopy(cmd)
+	for index, arg := range args {
+		args[index] = t.expandArgs(arg, false)
+	}
+	t.emit(fmt.Sprintf("%s %s", cmd.Name, jsonArray(args)))
+	return nil
+}
+
+func (t *targetExporter) handleEnv(ctx context.Context, cmd spec.Command) error {
+	var key, value, rawValue string
+	switch len(cmd.Args) {
+	case 3:
+		if cmd.Args[1] != "=" {
+			return t.errorf(cmd.SourceLocation, "invalid syntax")
+		}
+		rawValue = cmd.Args[2]
+		value = t.expandArgs(rawValue, false)
+		fallthrough
+	case 1:
+		key = cmd.Args[0]
+	default:
+		return t.errorf(cmd.SourceLocation, "invalid syntax")
+	}
+	if rawValue == "" {
+		rawValue = `""`
+	}
+	t.emit(fmt.Sprintf("ENV %s=%s", key, rawValue))
+	t.vars.DeclareEnv(key, value)
+	return nil
+}
+
+func (t *targetExporter) handleArg(ctx context.Context, cmd spec.Command) error {
+	var key, value string
+	switch len(cmd.Args) {
+	case 3:
+		if cmd.Args[1] != "=" {
+			return t.errorf(cmd.SourceLocation, "invalid syntax")
+		}
+		value = t.expandArgs(cmd.Args[2], true)
+		fallthrough
+	case 1:
+		key = cmd.Args[0]
+	default:
+		return t.errorf(cmd.SourceLocation, "invalid syntax")
+	}
+	// Args declared in the base target are global. They are declared in the Dockerfile before they are used.
+	_, err := t.vars.DeclareArg(key, value, t.isBase, t.processNonConstantBuildArg)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "apply ARG")
+	}
+	return nil
+}
+
+func (t *targetExporter) handleLabel(ctx context.Context, cmd spec.Command) error {
+	var labels []string
+	for i := 0; i < len(cmd.Args); i += 3 {
+		if i+2 >= len(cmd.Args) || cmd.Args[i+1] != "=" {
+			return t.errorf(cmd.SourceLocation, "syntax error")
+		}
+		labels = append(labels, fmt.Sprintf("%s=%s", cmd.Args[i], cmd.Args[i+2]))
+	}
+	if len(labels) == 0 {
+		return t.errorf(cmd.SourceLocation, "no labels provided in LABEL command")
+	}
+	t.emit("LABEL " + strings.Join(labels, " "))
+	return nil
+}
+
+func (t *targetExporter) handleHealthcheck(ctx context.Context, cmd spec.Command) error {
+	opts := earthfile2llb.HealthCheckOpts{}
+	args, err := flagutil.ParseArgs("HEALTHCHECK", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid HEALTHCHECK arguments %v", cmd.Args)
+	}
+	if len(args) == 0 {
+		return t.errorf(cmd.SourceLocation, "invalid number of arguments for HEALTHCHECK: %s", cmd.Args)
+	}
+	switch args[0] {
+	case "NONE":
+		if len(args) != 1 {
+			return t.errorf(cmd.SourceLocation, "invalid arguments for HEALTHCHECK: %s", cmd.Args)
+		}
+		t.emit("HEALTHCHECK NONE")
+		return nil
+	case "CMD":
+		if len(args) == 1 {
+			return t.errorf(cmd.SourceLocation, "invalid number of arguments for HEALTHCHECK CMD: %s", cmd.Args)
+		}
+	default:
+		return t.errorf(cmd.SourceLocation, "invalid arguments for HEALTHCHECK: %s", cmd.Args)
+	}
+	cmdArgs := args[1:]
+	for index, arg := range cmdArgs {
+		cmdArgs[index] = t.expandArgs(arg, false)
+	}
+	line := []string{
+		"HEALTHCHECK",
+		fmt.Sprintf("--interval=%s", opts.Interval),
+		fmt.Sprintf("--timeout=%s", opts.Timeout),
+		fmt.Sprintf("--start-period=%s", opts.StartPeriod),
+		fmt.Sprintf("--retries=%d", opts.Retries),
+		"CMD",
+	}
+	t.emit(strings.Join(append(line, cmdArgs...), " "))
+	return nil
+}
+
+func (t *targetExporter) handleDo(ctx context.Context, cmd spec.Command) error {
+	opts := earthfile2llb.DoOpts{}
+	args, err := flagutil.ParseArgs("DO", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid DO arguments %v", cmd.Args)
+	}
+	if len(args) < 1 {
+		return t.errorf(cmd.SourceLocation, "invalid number of arguments for DO: %s", args)
+	}
+	buildArgs, err := variables.ParseFlagArgs(t.expandArgsSlice(args[1:], true))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "parse flag args")
+	}
+	ucName := t.expandArgs(args[0], false)
+	relCommand, err := domain.ParseCommand(ucName)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "unable to parse user command reference %s", ucName)
+	}
+	derefed, _, _, err := t.vars.Imports().Deref(relCommand)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "unable to resolve user command %s", ucName)
+	}
+	ref, err := domain.JoinReferences(t.vars.AbsRef(), derefed)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "unable to resolve user command %s", ucName)
+	}
+	command := ref.(domain.Command)
+	if command.IsRemote() {
+		return t.errorf(cmd.SourceLocation, "remote user command %s cannot be exported", command.String())
+	}
+	ef, _, err := t.e.earthfile(ctx, command)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "unable to resolve user command %s", ucName)
+	}
+	var uc *spec.UserCommand
+	for index := range ef.UserCommands {
+		if ef.UserCommands[index].Name == command.Command {
+			uc = &ef.UserCommands[index]
+			break
+		}
+	}
+	if uc == nil {
+		return t.errorf(cmd.SourceLocation, "user command %s not found", ucName)
+	}
+	if len(uc.Recipe) == 0 || uc.Recipe[0].Command == nil || uc.Recipe[0].Command.Name != "COMMAND" {
+		return t.errorf(uc.SourceLocation, "command recipes must start with COMMAND")
+	}
+	if len(uc.Recipe[0].Command.Args) > 0 {
+		return t.errorf(uc.Recipe[0].SourceLocation, "COMMAND takes no arguments")
+	}
+
+	// The globals of the user command are those of its own Earthfile.
+	_, base, err := t.resolveTarget(ctx, baseTarget(relCommand).String(), buildArgs)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "enter scope")
+	}
+	overriding, err := variables.ParseArgs(buildArgs, t.processNonConstantBuildArg, t.vars)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "parse build args")
+	}
+	scopeName := fmt.Sprintf(
+		"%s (%s line %d:%d)",
+		command.StringCanonical(), cmd.SourceLocation.File, cmd.SourceLocation.StartLine, cmd.SourceLocation.StartColumn)
+	t.vars.EnterFrame(scopeName, command, overriding, base.globals.Clone(), base.globalImports)
+	err = t.handleBlock(ctx, uc.Recipe[1:])
+	if err != nil {
+		return err
+	}
+	t.vars.ExitFrame()
+	return nil
+}
+
+func (t *targetExporter) handleImport(ctx context.Context, cmd spec.Command) error {
+	opts := earthfile2llb.ImportOpts{}
+	args, err := flagutil.ParseArgs("IMPORT", &opts, earthfile2llb.GetArgsCopy(cmd))
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "invalid IMPORT arguments %v", cmd.Args)
+	}
+	if len(args) != 1 && len(args) != 3 {
+		return t.errorf(cmd.SourceLocation, "invalid number of arguments for IMPORT: %s", args)
+	}
+	if len(args) == 3 && args[1] != "AS" {
+		return t.errorf(cmd.SourceLocation, "invalid arguments for IMPORT: %s", args)
+	}
+	importStr := t.expandArgs(args[0], false)
+	var as string
+	if len(args) == 3 {
+		as = t.expandArgs(args[2], false)
+	}
+	err = t.vars.Imports().Add(importStr, as, t.isBase, false, opts.AllowPrivileged)
+	if err != nil {
+		return t.wrapError(err, cmd.SourceLocation, "apply IMPORT")
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+// emit adds a line to the current Dockerfile stage, preceded by declarations of the ARGs which are in scope,
+// such that docker build expands the line in the same way as Earthly.
+func (t *targetExporter) emit(line string) {
+	envs := t.vars.EnvVars()
+	for _, name := range t.vars.SortedActiveVariables() {
+		if _, isEnv := envs.GetAny(name); isEnv {
+			continue
+		}
+		value, _ := t.vars.GetActive(name)
+		decl := fmt.Sprintf("ARG %s=%s", name, quote(value))
+		if platformArgs[name] {
+			decl = "ARG " + name
+		}
+		if t.declared[name] == decl {
+			continue
+		}
+		t.declared[name] = decl
+		t.s.lines = append(t.s.lines, decl)
+	}
+	t.s.lines = append(t.s.lines, line)
+}
+
+// buildArgs returns the build args passed via --build-arg and via --KEY=VALUE flag args.
+func (t *targetExporter) buildArgs(buildArgFlags []string, flagArgs []string) ([]string, error) {
+	parsedFlagArgs, err := variables.ParseFlagArgs(t.expandArgsSlice(flagArgs, true))
+	if err != nil {
+		return nil, err
+	}
+	return append(parsedFlagArgs, t.expandArgsSlice(buildArgFlags, true)...), nil
+}
+
+func (t *targetExporter) processNonConstantBuildArg(name string, expression string) (string, int, error) {
+	return "", 0, errors.Errorf("the value of %s is the output of a command, which cannot be exported as a constant", name)
+}
+
+func (t *targetExporter) expandArgsSlice(words []string, keepPlusEscape bool) []string {
+	ret := make([]string, 0, len(words))
+	for _, word := range words {
+		ret = append(ret, t.expandArgs(word, keepPlusEscape))
+	}
+	return ret
+}
+
+func (t *targetExporter) expandArgs(word string, keepPlusEscape bool) string {
+	ret := t.vars.Expand(earthfile2llb.EscapeSlashPlus(word))
+	if keepPlusEscape {
+		return ret
+	}
+	return earthfile2llb.UnescapeSlashPlus(ret)
+}
+
+func (t *targetExporter) errorf(sl *spec.SourceLocation, format string, args ...interface{}) *earthfile2llb.InterpreterError {
+	return earthfile2llb.Errorf(sl, t.vars.StackString(), format, args...)
+}
+
+func (t *targetExporter) wrapError(cause error, sl *spec.SourceLocation, format string, args ...interface{}) *earthfile2llb.InterpreterError {
+	return earthfile2llb.WrapError(cause, sl, t.vars.StackString(), format, args...)
+}
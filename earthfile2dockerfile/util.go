@@ -0,0 +1,85 @@
+package earthfile2dockerfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+)
+
+var (
+	// unquotedRegexp matches values which can be used as Dockerfile words as they are.
+	unquotedRegexp = regexp.MustCompile(`^[a-zA-Z0-9_./:@%+,=-]+$`)
+	// invalidStageNameChars matches the characters which are not allowed in Dockerfile stage names.
+	invalidStageNameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+)
+
+// quote quotes a constant value for use as a Dockerfile word, such that it is not subject to variable expansion.
+func quote(s string) string {
+	if unquotedRegexp.MatchString(s) {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// jsonArray returns the exec form of a Dockerfile command.
+func jsonArray(args []string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(args) // Encoding a string slice cannot fail.
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// stageName returns the Dockerfile stage name of a target, given the directory of its Earthfile relative
+// to the build context.
+func stageName(relDir, targetName string) string {
+	name := targetName
+	if relDir != "." {
+		name = relDir + "-" + targetName
+	}
+	name = strings.Trim(invalidStageNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		name = "stage-" + name
+	}
+	return name
+}
+
+func hasWildcard(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// splitWildcards splits a path into the directory before the first wildcard and the remainder.
+func splitWildcards(name string) (string, string) {
+	i := 0
+	for ; i < len(name); i++ {
+		ch := name[i]
+		if ch == '\\' {
+			i++
+		} else if ch == '*' || ch == '?' || ch == '[' {
+			break
+		}
+	}
+	if i == len(name) {
+		return name, ""
+	}
+	base := path.Base(name[:i])
+	if name[:i] == "" || strings.HasSuffix(name[:i], "/") {
+		base = ""
+	}
+	return path.Dir(name[:i]), base + name[i:]
+}
+
+func baseTarget(ref domain.Reference) domain.Target {
+	return domain.Target{
+		GitURL:    ref.GetGitURL(),
+		Tag:       ref.GetTag(),
+		ImportRef: ref.GetImportRef(),
+		LocalPath: ref.GetLocalPath(),
+		Target:    "base",
+	}
+}
@@ -0,0 +1,117 @@
+package earthfile2llb
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+
+	"github.com/pkg/errors"
+)
+
+// Breakpoints holds the locations at which the build pauses, in order to open an interactive shell
+// in the state of the build at that point.
+type Breakpoints struct {
+	lines   []lineBreakpoint
+	targets []domain.Target
+
+	// mu serializes the interactive sessions, as only a single debugger session is supported at a time.
+	mu sync.Mutex
+}
+
+type lineBreakpoint struct {
+	path string
+	line int
+}
+
+// ParseBreakpoints parses breakpoints, each of which is either <path>:<line>, where path is an
+// Earthfile or a directory containing one, or a target reference.
+func ParseBreakpoints(breakpoints []string) (*Breakpoints, error) {
+	bp := &Breakpoints{}
+	for _, b := range breakpoints {
+		if strings.Contains(b, "+") {
+			target, err := domain.ParseTarget(b)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse breakpoint %s", b)
+			}
+			bp.targets = append(bp.targets, target)
+			continue
+		}
+		sepIndex := strings.LastIndex(b, ":")
+		if sepIndex == -1 {
+			return nil, errors.Errorf("invalid breakpoint %s; expected <path>:<line> or a target reference", b)
+		}
+		line, err := strconv.Atoi(b[sepIndex+1:])
+		if err != nil || line <= 0 {
+			return nil, errors.Errorf("invalid line number in breakpoint %s", b)
+		}
+		path := b[:sepIndex]
+		if path == "" {
+			path = "Earthfile"
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "breakpoint %s", b)
+		}
+		if fi.IsDir() {
+			path = filepath.Join(path, "Earthfile")
+		}
+		path, err = filepath.Abs(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get absolute path of breakpoint %s", b)
+		}
+		bp.lines = append(bp.lines, lineBreakpoint{path: path, line: line})
+	}
+	return bp, nil
+}
+
+// matchCommand returns true if the build should pause before the command at the given location.
+func (bp *Breakpoints) matchCommand(sl *spec.SourceLocation) bool {
+	if bp == nil || sl == nil || len(bp.lines) == 0 {
+		return false
+	}
+	path, err := filepath.Abs(sl.File)
+	if err != nil {
+		return false
+	}
+	for _, l := range bp.lines {
+		if l.path == path && l.line >= sl.StartLine && l.line <= sl.EndLine {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTarget returns true if the build should pause before the first command of the given target.
+func (bp *Breakpoints) matchTarget(target domain.Target) bool {
+	if bp == nil {
+		return false
+	}
+	for _, t := range bp.targets {
+		if t.GetName() != target.GetName() || t.IsRemote() != target.IsRemote() {
+			continue
+		}
+		if t.IsRemote() {
+			if t.GetGitURL() == target.GetGitURL() {
+				return true
+			}
+			continue
+		}
+		tPath, err := filepath.Abs(t.GetLocalPath())
+		if err != nil {
+			continue
+		}
+		targetPath, err := filepath.Abs(target.GetLocalPath())
+		if err != nil {
+			continue
+		}
+		if tPath == targetPath {
+			return true
+		}
+	}
+	return false
+}
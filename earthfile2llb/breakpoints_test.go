@@ -0,0 +1,42 @@
+package earthfile2llb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakpoints(t *testing.T) {
+	dir := t.TempDir()
+	earthfile := filepath.Join(dir, "Earthfile")
+	err := os.WriteFile(earthfile, []byte("VERSION 0.5\n"), 0644)
+	assert.NoError(t, err)
+
+	bp, err := ParseBreakpoints([]string{earthfile + ":3", dir + ":7", dir + "+test"})
+	assert.NoError(t, err)
+
+	assert.True(t, bp.matchCommand(&spec.SourceLocation{File: earthfile, StartLine: 3, EndLine: 3}))
+	assert.True(t, bp.matchCommand(&spec.SourceLocation{File: earthfile, StartLine: 6, EndLine: 8}))
+	assert.False(t, bp.matchCommand(&spec.SourceLocation{File: earthfile, StartLine: 4, EndLine: 5}))
+	assert.False(t, bp.matchCommand(&spec.SourceLocation{File: filepath.Join(dir, "sub", "Earthfile"), StartLine: 3, EndLine: 3}))
+	assert.False(t, bp.matchCommand(nil))
+
+	assert.True(t, bp.matchTarget(domain.Target{LocalPath: dir, Target: "test"}))
+	assert.False(t, bp.matchTarget(domain.Target{LocalPath: dir, Target: "build"}))
+	assert.False(t, bp.matchTarget(domain.Target{GitURL: "github.com/earthly/earthly", Target: "test"}))
+
+	var none *Breakpoints
+	assert.False(t, none.matchCommand(&spec.SourceLocation{File: earthfile, StartLine: 3, EndLine: 3}))
+	assert.False(t, none.matchTarget(domain.Target{LocalPath: dir, Target: "test"}))
+}
+
+func TestParseBreakpointsInvalid(t *testing.T) {
+	for _, b := range []string{"Earthfile", "Earthfile:x", "Earthfile:0", "does-not-exist/Earthfile:3"} {
+		_, err := ParseBreakpoints([]string{b})
+		assert.Error(t, err, b)
+	}
+}
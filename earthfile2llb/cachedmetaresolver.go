@@ -2,11 +2,14 @@ package earthfile2llb
 
 import (
 	"context"
+	"strings"
 
+	"github.com/earthly/earthly/lockfile"
 	"github.com/earthly/earthly/util/llbutil"
 	"github.com/earthly/earthly/util/syncutil/synccache"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
 )
 
 var _ llb.ImageMetaResolver = &CachedMetaResolver{}
@@ -25,14 +28,17 @@ type cachedMetaResolverEntry struct {
 type CachedMetaResolver struct {
 	metaResolver llb.ImageMetaResolver
 	cache        *synccache.SyncCache // cachedMetaResolverKey -> cachedMetaResolverEntry
+	lock         *lockfile.Lock
 }
 
 // NewCachedMetaResolver creates a new cached meta resolver based on an underlying meta resolver
-// which needs to be provided.
-func NewCachedMetaResolver(metaResolver llb.ImageMetaResolver) *CachedMetaResolver {
+// which needs to be provided. References pinned by the lock (which may be nil) are resolved
+// to the pinned digest, and all resolved digests are recorded in the lock.
+func NewCachedMetaResolver(metaResolver llb.ImageMetaResolver, lock *lockfile.Lock) *CachedMetaResolver {
 	return &CachedMetaResolver{
 		metaResolver: metaResolver,
 		cache:        synccache.New(),
+		lock:         lock,
 	}
 }
 
@@ -43,10 +49,35 @@ func (cmr *CachedMetaResolver) ResolveImageConfig(ctx context.Context, ref strin
 		platform: llbutil.PlatformToString(opt.Platform),
 	}
 	value, err := cmr.cache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
-		dgst, config, err := cmr.metaResolver.ResolveImageConfig(ctx, ref, opt)
+		resolveRef := ref
+		var pinned string
+		hasDigest := strings.Contains(ref, "@")
+		if hasDigest {
+			pinned = ref[strings.LastIndex(ref, "@")+1:]
+		} else if dgst, ok := cmr.lock.Image(ref, key.platform); ok {
+			pinned = dgst
+			resolveRef = ref + "@" + pinned
+		}
+		if cmr.lock.Offline() {
+			// Only images pulled by earthly prefetch are present locally; anything else would
+			// need to be fetched from the registry.
+			if !cmr.lock.ImageAvailable(ref, key.platform, pinned) {
+				cmr.lock.RecordMissingImage(ref, key.platform)
+				return nil, errors.Errorf("image %s is not available offline", ref)
+			}
+			opt.ResolveMode = llb.ResolveModePreferLocal.String()
+		}
+		dgst, config, err := cmr.metaResolver.ResolveImageConfig(ctx, resolveRef, opt)
 		if err != nil {
 			return nil, err
 		}
+		if dgst != "" {
+			if hasDigest {
+				cmr.lock.RecordDigestImage(ref, key.platform, dgst.String())
+			} else {
+				cmr.lock.RecordImage(ref, key.platform, dgst.String())
+			}
+		}
 		return cachedMetaResolverEntry{
 			dgst:   dgst,
 			config: config,
@@ -0,0 +1,79 @@
+package earthfile2llb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/lockfile"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMetaResolver struct {
+	refs  []string
+	modes []string
+}
+
+func (fmr *fakeMetaResolver) ResolveImageConfig(ctx context.Context, ref string, opt llb.ResolveImageConfigOpt) (digest.Digest, []byte, error) {
+	fmr.refs = append(fmr.refs, ref)
+	fmr.modes = append(fmr.modes, opt.ResolveMode)
+	return digest.Digest("sha256:aaaa"), []byte("{}"), nil
+}
+
+func TestCachedMetaResolverOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-cachedmetaresolver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// alpine:3.12 is pinned in the Earthfile.lock, but was never prefetched.
+	lockPath := filepath.Join(dir, lockfile.FileName)
+	pins := lockfile.New()
+	pins.RecordImage("alpine:3.12", "", "sha256:bbbb")
+	err = pins.Write(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefetched := lockfile.New()
+	prefetched.RecordImage("alpine:3.13", "", "sha256:aaaa")
+	prefetched.RecordDigestImage("alpine@sha256:aaaa", "", "sha256:aaaa")
+
+	var tests = []struct {
+		ref        string
+		resolveRef string
+	}{
+		{"alpine:3.13", "alpine:3.13@sha256:aaaa"},
+		{"alpine@sha256:aaaa", "alpine@sha256:aaaa"},
+		{"alpine:3.14", ""},
+		{"alpine:3.12", ""},
+		{"alpine@sha256:bbbb", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			l, err := lockfile.Read(lockPath, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.SetOffline(prefetched)
+			fmr := &fakeMetaResolver{}
+			cmr := NewCachedMetaResolver(fmr, l)
+			_, _, err = cmr.ResolveImageConfig(context.Background(), tt.ref, llb.ResolveImageConfigOpt{
+				ResolveMode: llb.ResolveModeDefault.String(),
+			})
+			if tt.resolveRef == "" {
+				assert.Error(t, err)
+				assert.Empty(t, fmr.refs, "%s was resolved over the network", tt.ref)
+				assert.Equal(t, []string{"image " + tt.ref}, l.Missing())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, []string{tt.resolveRef}, fmr.refs)
+			assert.Equal(t, []string{llb.ResolveModePreferLocal.String()}, fmr.modes)
+			assert.Empty(t, l.Missing())
+		})
+	}
+}
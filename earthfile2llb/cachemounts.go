@@ -0,0 +1,138 @@
+package earthfile2llb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// cacheMount is a RUN --mount=type=cache.
+type cacheMount struct {
+	ID    string
+	Scope string
+}
+
+// CacheMountRecord is the earthly view of a buildkit cache mount record.
+type CacheMountRecord struct {
+	// Path is the path the cache is mounted at.
+	Path string `json:"path"`
+	// ID is the id of the cache mount, as given via RUN --mount id=.
+	ID string `json:"id"`
+	// Global is set for cache mounts with scope=global, which are shared between targets.
+	Global bool `json:"global,omitempty"`
+	// Target is the target which created the cache mount. It is empty if the cache mount
+	// is not known to the index.
+	Target string `json:"target,omitempty"`
+}
+
+// CacheMountsIndex records which cache mount created each buildkit cache mount record. Buildkit does
+// not keep the id of a cache mount, only a description of the form "cached mount <path> from exec <args>",
+// which is why the index is kept by earthly, keyed by that description. It is safe for concurrent use.
+type CacheMountsIndex struct {
+	records map[string]CacheMountRecord
+	mu      sync.Mutex
+}
+
+// NewCacheMountsIndex returns a new, empty CacheMountsIndex.
+func NewCacheMountsIndex() *CacheMountsIndex {
+	return &CacheMountsIndex{
+		records: make(map[string]CacheMountRecord),
+	}
+}
+
+// ReadCacheMountsIndex reads the index at the given path. An empty index is returned if the
+// file does not exist.
+func ReadCacheMountsIndex(path string) (*CacheMountsIndex, error) {
+	cmi := NewCacheMountsIndex()
+	dt, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cmi, nil
+		}
+		return nil, errors.Wrapf(err, "read cache mounts index %s", path)
+	}
+	err = json.Unmarshal(dt, &cmi.records)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse cache mounts index %s", path)
+	}
+	return cmi, nil
+}
+
+// Write writes the index to the given path.
+func (cmi *CacheMountsIndex) Write(path string) error {
+	cmi.mu.Lock()
+	defer cmi.mu.Unlock()
+	dt, err := json.MarshalIndent(cmi.records, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal cache mounts index")
+	}
+	err = ioutil.WriteFile(path, dt, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write cache mounts index %s", path)
+	}
+	return nil
+}
+
+// Merge adds the entries of the index to the index at the given path, which is created if it does not
+// exist. Entries written by other earthly processes since the index was read are preserved.
+func (cmi *CacheMountsIndex) Merge(path string) error {
+	existing, err := ReadCacheMountsIndex(path)
+	if err != nil {
+		return err
+	}
+	cmi.mu.Lock()
+	for description, record := range cmi.records {
+		existing.records[description] = record
+	}
+	cmi.mu.Unlock()
+	return existing.Write(path)
+}
+
+// Lookup returns the cache mount which created the buildkit record with the given description. Cache
+// mounts not known to the index are returned with the path they are mounted at as their id, which is
+// the id used when none is given.
+func (cmi *CacheMountsIndex) Lookup(description string) CacheMountRecord {
+	cmi.mu.Lock()
+	defer cmi.mu.Unlock()
+	if record, ok := cmi.records[description]; ok {
+		return record
+	}
+	mountPath := strings.TrimPrefix(description, "cached mount ")
+	if i := strings.Index(mountPath, " from "); i != -1 {
+		mountPath = mountPath[:i]
+	}
+	return CacheMountRecord{Path: mountPath, ID: mountPath}
+}
+
+// Retain removes all entries from the index, except for the given descriptions.
+func (cmi *CacheMountsIndex) Retain(descriptions []string) {
+	cmi.mu.Lock()
+	defer cmi.mu.Unlock()
+	retained := make(map[string]CacheMountRecord)
+	for _, description := range descriptions {
+		if record, ok := cmi.records[description]; ok {
+			retained[description] = record
+		}
+	}
+	cmi.records = retained
+}
+
+func (cmi *CacheMountsIndex) record(mountPath string, args []string, record CacheMountRecord) {
+	if cmi == nil {
+		return
+	}
+	cmi.mu.Lock()
+	defer cmi.mu.Unlock()
+	cmi.records[cacheMountDescription(mountPath, args)] = record
+}
+
+// cacheMountDescription returns the description buildkit gives to the record of a cache mount at
+// mountPath, used by an exec with the given args.
+func cacheMountDescription(mountPath string, args []string) string {
+	return fmt.Sprintf("cached mount %s from exec %s", mountPath, strings.Join(args, " "))
+}
@@ -0,0 +1,47 @@
+package earthfile2llb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheMountsIndex(t *testing.T) {
+	args := withShellAndEnvVars([]string{"npm", "install"}, []string{"FOO=bar"}, true, true, false)
+	npm := CacheMountRecord{Path: "/root/.npm", ID: "npm", Target: "./web+build"}
+	gomod := CacheMountRecord{Path: "/go/pkg/mod", ID: "gomod", Global: true, Target: "./web+build"}
+
+	var nilIndex *CacheMountsIndex
+	nilIndex.record("/root/.npm", args, npm)
+
+	cmi := NewCacheMountsIndex()
+	cmi.record("/root/.npm", args, npm)
+	cmi.record("/go/pkg/mod", args, gomod)
+	npmDescription := "cached mount /root/.npm from exec /bin/sh -c FOO=bar /usr/bin/earth_debugger /bin/sh -c 'npm install'"
+	assert.Equal(t, npm, cmi.Lookup(npmDescription))
+
+	// Cache mounts not in the index default to the path as id.
+	assert.Equal(t, CacheMountRecord{Path: "/cache", ID: "/cache"},
+		cmi.Lookup("cached mount /cache from exec /bin/sh -c make from source"))
+	assert.Equal(t, CacheMountRecord{Path: "/cache", ID: "/cache"}, cmi.Lookup("cached mount /cache"))
+
+	path := filepath.Join(t.TempDir(), "cache-mounts.json")
+	other := NewCacheMountsIndex()
+	other.record("/cache", []string{"make"}, CacheMountRecord{Path: "/cache", ID: "make", Target: "+test"})
+	assert.NoError(t, other.Merge(path))
+	assert.NoError(t, cmi.Merge(path))
+
+	read, err := ReadCacheMountsIndex(path)
+	assert.NoError(t, err)
+	assert.Equal(t, npm, read.Lookup(npmDescription))
+	assert.Equal(t, "make", read.Lookup("cached mount /cache from exec make").ID)
+
+	read.Retain([]string{npmDescription})
+	assert.Equal(t, npm, read.Lookup(npmDescription))
+	assert.Equal(t, "/cache", read.Lookup("cached mount /cache from exec make").ID)
+
+	empty, err := ReadCacheMountsIndex(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/cache", empty.Lookup("cached mount /cache from exec make").ID)
+}
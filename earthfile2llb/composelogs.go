@@ -0,0 +1,45 @@
+package earthfile2llb
+
+import (
+	"sync"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+)
+
+// ComposeLogsExport is a directory of compose service logs written by a WITH DOCKER command, which is
+// output locally once the build is done, regardless of whether the command succeeded.
+type ComposeLogsExport struct {
+	// State holds the logs at its root, once solved.
+	State pllb.State
+	// DestPath is the local directory to which the logs are output.
+	DestPath string
+}
+
+// ComposeLogsExports records the compose logs to be output once the build is done. The logs are not
+// part of the state of the command, which is discarded if the command fails. It is safe for
+// concurrent use.
+type ComposeLogsExports struct {
+	exports []ComposeLogsExport
+	mu      sync.Mutex
+}
+
+// NewComposeLogsExports returns a new, empty ComposeLogsExports.
+func NewComposeLogsExports() *ComposeLogsExports {
+	return &ComposeLogsExports{}
+}
+
+// All returns the recorded exports, in the order in which they were recorded.
+func (cle *ComposeLogsExports) All() []ComposeLogsExport {
+	cle.mu.Lock()
+	defer cle.mu.Unlock()
+	return append([]ComposeLogsExport{}, cle.exports...)
+}
+
+func (cle *ComposeLogsExports) record(export ComposeLogsExport) {
+	if cle == nil {
+		return
+	}
+	cle.mu.Lock()
+	defer cle.mu.Unlock()
+	cle.exports = append(cle.exports, export)
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/earthly/earthly/analytics"
+	"github.com/earthly/earthly/ast/spec"
 	"github.com/earthly/earthly/buildcontext"
 	"github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/domain"
@@ -38,6 +39,7 @@ import (
 	gwclient "github.com/moby/buildkit/frontend/gateway/client"
 	"github.com/moby/buildkit/session/localhost"
 	solverpb "github.com/moby/buildkit/solver/pb"
+	bkgitutil "github.com/moby/buildkit/util/gitutil"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 )
@@ -82,6 +84,8 @@ type Converter struct {
 	ranSave             bool
 	cmdSet              bool
 	ftrs                *features.Features
+	// sourceLocation is the location of the Earthfile command being converted, if any.
+	sourceLocation *spec.SourceLocation
 }
 
 // NewConverter constructs a new converter for a given earthly target.
@@ -1054,28 +1058,177 @@ func (c *Converter) Label(ctx context.Context, labels map[string]string) error {
 }
 
 // GitClone applies the GIT CLONE command.
-func (c *Converter) GitClone(ctx context.Context, gitURL string, branch string, dest string, keepTs bool) error {
+func (c *Converter) GitClone(ctx context.Context, gitURL string, dest string, opt GitCloneOpt) error {
 	err := c.checkAllowed(gitCloneCmd)
 	if err != nil {
 		return err
 	}
 	c.nonSaveCommand()
+	err = opt.validate()
+	if err != nil {
+		return err
+	}
 	gitURLScrubbed := stringutil.ScrubCredentials(gitURL)
-	gitOpts := []llb.GitOption{
-		llb.WithCustomNamef(
-			"%sGIT CLONE (--branch %s) %s", c.vertexPrefixWithURL(gitURLScrubbed), branch, gitURLScrubbed),
-		llb.KeepGitDir(),
+	commit, pinned, err := c.pinnedGitCloneCommit(opt)
+	if err != nil {
+		return err
 	}
-	gitState := pllb.Git(gitURL, branch, gitOpts...)
+	var gitState pllb.State
+	if opt.useGitImage() {
+		if !pinned {
+			commit, err = c.lsRemoteGitCloneRef(ctx, gitURL, gitURLScrubbed, opt)
+			if err != nil {
+				return err
+			}
+		}
+		gitState = c.gitCloneInImage(gitURL, gitURLScrubbed, commit, opt)
+	} else {
+		gitOpts := []llb.GitOption{
+			llb.WithCustomNamef(
+				"%sGIT CLONE (%s) %s", c.vertexPrefixWithURL(gitURLScrubbed), opt.description(), gitURLScrubbed),
+			llb.KeepGitDir(),
+		}
+		if opt.KeyScan != "" {
+			gitOpts = append(gitOpts, llb.KnownSSHHosts(opt.KeyScan))
+		}
+		if authSecret := c.opt.GitLookup.AuthSecret(gitURL); authSecret != "" {
+			gitOpts = append(gitOpts, llb.AuthHeaderSecret(authSecret))
+		}
+		gitRef := opt.ref()
+		if pinned {
+			gitRef = commit
+		}
+		gitState = pllb.Git(gitURL, gitRef, gitOpts...)
+		// The buildkit git source does not expose the commit it resolved the ref to. It is only read
+		// back when it is needed, as that requires the clone to be solved during conversion.
+		if !pinned && (opt.SHAArg != "" || c.opt.Lock.Exists() || c.opt.Lock.Updating() || c.opt.GitCloneSources != nil) {
+			commit, err = c.readGitMetaFile(ctx, c.gitCloneMeta(gitState, gitURLScrubbed), gitCloneSHAFile)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if commit != "" {
+		c.opt.Lock.RecordGit(opt.Repo, opt.ref(), commit)
+	}
+	if opt.SHAArg != "" {
+		c.varCollection.SetArg(opt.SHAArg, commit)
+	}
+	c.opt.GitCloneSources.record(GitCloneSource{Repo: opt.Repo, State: gitState})
 	c.mts.Final.MainState = llbutil.CopyOp(
-		gitState, []string{"."}, c.mts.Final.MainState, dest, false, false, keepTs,
+		gitState, []string{"."}, c.mts.Final.MainState, dest, false, false, opt.KeepTs,
 		c.mts.Final.MainImage.Config.User, false, false,
 		llb.WithCustomNamef(
-			"%sCOPY GIT CLONE (--branch %s) %s TO %s", c.vertexPrefix(false, false),
-			branch, gitURLScrubbed, dest))
+			"%sCOPY GIT CLONE (%s) %s TO %s", c.vertexPrefix(false, false),
+			opt.description(), gitURLScrubbed, dest))
 	return nil
 }
 
+// pinnedGitCloneCommit returns the commit the ref of a GIT CLONE is pinned to, which is the ref itself if it is a
+// SHA, or the commit pinned by the lock file. In offline mode, an error is returned unless the commit was prefetched.
+func (c *Converter) pinnedGitCloneCommit(opt GitCloneOpt) (string, bool, error) {
+	ref := opt.ref()
+	commit, pinned := c.opt.Lock.GitCommit(opt.Repo, ref)
+	if commitSHARegexp.MatchString(ref) {
+		commit, pinned = ref, true
+	}
+	if !c.opt.Lock.GitAvailable(opt.Repo, ref, commit) {
+		c.opt.Lock.RecordMissingGit(opt.Repo, ref)
+		return "", false, errors.Errorf("GIT CLONE %s is not available offline", opt.Repo)
+	}
+	return commit, pinned, nil
+}
+
+// lsRemoteGitCloneRef returns the SHA of the commit a ref which is not pinned resolves to. Branches and tags are
+// resolved via git ls-remote on every build, such that the clone, which is cached by the SHA, is never stale.
+func (c *Converter) lsRemoteGitCloneRef(ctx context.Context, gitURL, gitURLScrubbed string, opt GitCloneOpt) (string, error) {
+	ref := opt.ref()
+	if ref == "" {
+		ref = "HEAD"
+	}
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", gitLsRemoteScript(ref)}),
+		llb.IgnoreCache,
+		llb.WithCustomNamef("[internal] GIT LS-REMOTE %s %s", gitURLScrubbed, ref),
+	}
+	runOpts = append(runOpts, c.gitRunOpts(gitURL, opt.KeyScan)...)
+	lsRemoteOp := c.gitImage().Run(runOpts...)
+	metaState := lsRemoteOp.AddMount(gitCloneMetaDir, llbutil.ScratchWithPlatform())
+	output, err := c.readGitMetaFile(ctx, metaState, gitLsRemoteFile)
+	if err != nil {
+		return "", err
+	}
+	sha, err := resolveLsRemote(output, ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolve %s", gitURLScrubbed)
+	}
+	return sha, nil
+}
+
+// gitCloneInImage clones the given commit of the repository within a git container, which supports options
+// the buildkit git source does not. It returns the state of the clone.
+func (c *Converter) gitCloneInImage(gitURL, gitURLScrubbed, sha string, opt GitCloneOpt) pllb.State {
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", gitCloneScript(opt, sha)}),
+		llb.AddEnv("EARTHLY_GIT_URL_SCRUBBED", stripCredentials(gitURL)),
+		llb.WithCustomNamef(
+			"%sGIT CLONE (%s) %s", c.vertexPrefixWithURL(gitURLScrubbed), opt.description(), gitURLScrubbed),
+	}
+	runOpts = append(runOpts, c.gitRunOpts(gitURL, opt.KeyScan)...)
+	cloneOp := c.gitImage().Run(runOpts...)
+	return cloneOp.AddMount(gitCloneSrcDir, pllb.Scratch())
+}
+
+// gitRunOpts returns the run options which give a git container access to the given repository, to the known
+// host keys of its host, and to its credentials. The credentials are passed as a secret mount, such that they are kept out of the LLB definition
+// and the cache key. The name of the secret is only valid for the current session, so a clone which needs
+// credentials is not reused across sessions.
+func (c *Converter) gitRunOpts(gitURL, keyScan string) []llb.RunOption {
+	runOpts := []llb.RunOption{llb.AddEnv("EARTHLY_GIT_URL", gitURL)}
+	if _, protocol := bkgitutil.ParseProtocol(gitURL); protocol == bkgitutil.SSHProtocol {
+		runOpts = append(runOpts, llb.AddSSHSocket(), llb.AddEnv("EARTHLY_GIT_KNOWN_HOSTS", keyScan))
+	}
+	if authSecret := c.opt.GitLookup.AuthSecret(gitURL); authSecret != "" {
+		runOpts = append(runOpts,
+			llb.AddEnv("EARTHLY_GIT_AUTH_SCOPE", gitAuthScope(gitURL)),
+			llb.AddSecret(gitAuthPath, llb.SecretID(authSecret), llb.SecretFileOpt(0, 0, 0400)))
+	}
+	return runOpts
+}
+
+func (c *Converter) gitImage() pllb.State {
+	return pllb.Image(
+		buildcontext.DefaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+		llb.Platform(llbutil.DefaultPlatform()))
+}
+
+// gitCloneMeta returns a state containing the SHA of the commit checked out by the buildkit git source.
+func (c *Converter) gitCloneMeta(gitState pllb.State, gitURLScrubbed string) pllb.State {
+	metaOp := c.gitImage().Run(
+		llb.Args([]string{
+			"/bin/sh", "-c",
+			fmt.Sprintf("git rev-parse HEAD >%s/%s", gitCloneMetaDir, gitCloneSHAFile),
+		}),
+		llb.Dir(gitCloneSrcDir),
+		llb.ReadonlyRootFS(),
+		pllb.AddMount(gitCloneSrcDir, gitState, llb.Readonly),
+		llb.WithCustomNamef("[internal] GET GIT CLONE SHA %s", gitURLScrubbed))
+	return metaOp.AddMount(gitCloneMetaDir, llbutil.ScratchWithPlatform())
+}
+
+// readGitMetaFile solves the given state, and returns the trimmed contents of the given file within it.
+func (c *Converter) readGitMetaFile(ctx context.Context, metaState pllb.State, filename string) (string, error) {
+	ref, err := llbutil.StateToRef(ctx, c.opt.GwClient, metaState, c.opt.Platform, c.opt.CacheImports.AsMap())
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s state to ref", filename)
+	}
+	dt, err := ref.ReadFile(ctx, gwclient.ReadRequest{Filename: filename})
+	if err != nil {
+		return "", errors.Wrapf(err, "read git %s", filename)
+	}
+	return strings.TrimSpace(string(dt)), nil
+}
+
 // WithDockerRun applies an entire WITH DOCKER ... RUN ... END clause.
 func (c *Converter) WithDockerRun(ctx context.Context, args []string, opt WithDockerOpt) error {
 	err := c.checkAllowed(runCmd)
@@ -1191,6 +1344,9 @@ func (c *Converter) FinalizeStates(ctx context.Context) (*states.MultiTarget, er
 	c.mts.Final.VarCollection = c.varCollection
 	c.mts.Final.GlobalImports = c.varCollection.Imports().Global()
 	close(c.mts.Final.Done())
+	if c.opt.TargetConverted != nil {
+		c.opt.TargetConverted(c.mts.Final.ID)
+	}
 	return c.mts, nil
 }
 
@@ -1345,12 +1501,13 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 		finalArgs = append(c.mts.Final.MainImage.Config.Entrypoint, finalArgs...)
 		opts.WithShell = false // Don't use shell when --entrypoint is passed.
 	}
+	cmdArgs := append([]string{}, finalArgs...)
 
 	runOpts := opts.extraRunOpts[:]
 	if opts.Privileged {
 		runOpts = append(runOpts, llb.Security(llb.SecurityModeInsecure))
 	}
-	mountRunOpts, err := parseMounts(opts.Mounts, c.mts.Final.Target, c.targetInputActiveOnly(), c.cacheContext)
+	mountRunOpts, cacheMounts, err := parseMounts(opts.Mounts, c.mts.Final.Target, c.targetInputActiveOnly(), c.cacheContext)
 	if err != nil {
 		return pllb.State{}, errors.Wrap(err, "parse mounts")
 	}
@@ -1364,7 +1521,8 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 		strIf(opts.Interactive, "--interactive "),
 		strIf(opts.InteractiveKeep, "--interactive-keep "),
 		strings.Join(opts.Args, " "))
-	runOpts = append(runOpts, llb.WithCustomNamef("%s%s", c.vertexPrefix(opts.Locally, isInteractive), commandStr))
+	vertexName := fmt.Sprintf("%s%s", c.vertexPrefix(opts.Locally, isInteractive), commandStr)
+	runOpts = append(runOpts, llb.WithCustomName(vertexName))
 
 	var extraEnvVars []string
 	// Secrets.
@@ -1376,6 +1534,9 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 		if strings.HasPrefix(parts[1], "+secrets/") {
 			envVar := parts[0]
 			secretID := strings.TrimPrefix(parts[1], "+secrets/")
+			if buildcontext.IsGitAuthSecret(secretID) {
+				return pllb.State{}, errors.Errorf("secret %s is reserved for use by earthly", secretID)
+			}
 			secretPath := path.Join("/run/secrets", secretID)
 			secretOpts := []llb.SecretOption{
 				llb.SecretID(secretID),
@@ -1425,6 +1586,14 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 	}
 
 	runOpts = append(runOpts, llb.Args(finalArgs))
+	for mountPath, m := range cacheMounts {
+		c.opt.CacheMounts.record(mountPath, finalArgs, CacheMountRecord{
+			Path:   mountPath,
+			ID:     m.ID,
+			Global: m.Scope == cacheScopeGlobal,
+			Target: c.mts.Final.Target.String(),
+		})
+	}
 	if opts.NoCache || opts.Locally || opts.Push || isInteractive {
 		runOpts = append(runOpts, llb.IgnoreCache)
 	}
@@ -1450,6 +1619,21 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 			return pllb.State{}, err
 		}
 	}
+	if !opts.Locally && c.opt.RunStates != nil {
+		buildArgs := make(map[string]string)
+		for _, buildArgName := range c.varCollection.SortedActiveVariables() {
+			buildArgs[buildArgName], _ = c.varCollection.GetActive(buildArgName)
+		}
+		c.opt.RunStates.record(vertexName, RunState{
+			State:      state,
+			Image:      c.mts.Final.MainImage.Clone(),
+			Platform:   llbutil.PlatformWithDefault(c.mts.Final.Platform),
+			Args:       cmdArgs,
+			WithShell:  opts.WithShell,
+			BuildArgs:  buildArgs,
+			Privileged: opts.Privileged,
+		})
+	}
 	if isInteractive {
 		c.mts.Final.RanInteractive = true
 
@@ -1503,6 +1687,46 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 	}
 }
 
+// Breakpoint opens an interactive shell in the current state of the target, and blocks until the
+// shell exits. Any changes made within the shell are discarded.
+func (c *Converter) Breakpoint(ctx context.Context, location string) error {
+	c.opt.Breakpoints.mu.Lock()
+	defer c.opt.Breakpoints.mu.Unlock()
+	opts := ConvertRunOpts{
+		CommandName: "BREAK",
+		Args:        []string{location},
+		NoCache:     true,
+		Transient:   true,
+		shellWrap: func(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string {
+			return withShellAndEnvVars([]string{interactiveShellCmd}, envVars, true, true, true)
+		},
+	}
+	state, err := c.internalRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return c.forceExecution(ctx, state)
+}
+
+// InteractiveShell opens an interactive shell in the final state of the target, once the target has
+// been built. Any changes made within the shell are discarded.
+func (c *Converter) InteractiveShell(ctx context.Context) error {
+	err := c.checkAllowed(runCmd)
+	if err != nil {
+		return err
+	}
+	opts := ConvertRunOpts{
+		CommandName: "SHELL",
+		Args:        []string{c.mts.Final.Target.String()},
+		Interactive: true,
+		shellWrap: func(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string {
+			return withShellAndEnvVars([]string{interactiveShellCmd}, envVars, true, true, true)
+		},
+	}
+	_, err = c.internalRun(ctx, opts)
+	return err
+}
+
 func (c *Converter) forceExecution(ctx context.Context, state pllb.State) error {
 	ref, err := llbutil.StateToRef(ctx, c.opt.GwClient, state, c.opt.Platform, c.opt.CacheImports.AsMap())
 	if err != nil {
@@ -1644,6 +1868,11 @@ func (c *Converter) vertexPrefix(local bool, interactive bool) string {
 	if interactive {
 		varStrBuilder = append(varStrBuilder, fmt.Sprintf("@interactive=%s", base64True))
 	}
+	if c.sourceLocation != nil {
+		b64Location := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(
+			"%s:%d", c.sourceLocation.File, c.sourceLocation.StartLine)))
+		varStrBuilder = append(varStrBuilder, fmt.Sprintf("@location=%s", b64Location))
+	}
 	for _, key := range overriding {
 		variable, isActive := c.varCollection.GetActive(key)
 		if !isActive {
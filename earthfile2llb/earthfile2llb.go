@@ -16,6 +16,7 @@ import (
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/lockfile"
 	"github.com/earthly/earthly/states"
 	"github.com/earthly/earthly/variables"
 )
@@ -49,6 +50,9 @@ type ConvertOpt struct {
 	BuildContextProvider *provider.BuildContextProvider
 	// MetaResolver is the image meta resolver to use for resolving image metadata.
 	MetaResolver llb.ImageMetaResolver
+	// Lock pins image digests to those recorded in an Earthfile.lock, and records the digests resolved.
+	// It is only used when MetaResolver is not set.
+	Lock *lockfile.Lock
 	// CacheImports is a set of docker tags that can be used to import cache. Note that this
 	// set is modified by the converter if InlineCache is enabled.
 	CacheImports *states.CacheImports
@@ -96,6 +100,30 @@ type ConvertOpt struct {
 
 	// FeatureFlagOverride is used to override feature flags that are defined in specific Earthfiles
 	FeatureFlagOverrides string
+
+	// Breakpoints are the locations at which the build pauses to open an interactive shell.
+	Breakpoints *Breakpoints
+
+	// RunStates records the state in which each RUN command is executed, if not nil.
+	RunStates *RunStates
+
+	// ComposeLogs records the compose logs written by WITH DOCKER --compose-logs, if not nil.
+	ComposeLogs *ComposeLogsExports
+
+	// CacheMounts records the cache mount which created each buildkit cache mount record, if not nil.
+	CacheMounts *CacheMountsIndex
+
+	// GitCloneSources records the sources of the GIT CLONE commands, to be fetched by earthly prefetch. It
+	// is only set when prefetching.
+	GitCloneSources *GitCloneSources
+
+	// TargetConverted is called with the ID of each target once it has been converted, and no more
+	// commands are added to it, if not nil.
+	TargetConverted func(id string)
+
+	// InteractiveShell opens an interactive shell in the final state of the initial target, once it
+	// has been built.
+	InteractiveShell bool
 }
 
 // Earthfile2LLB parses a earthfile and executes the statements for a given target.
@@ -107,7 +135,7 @@ func Earthfile2LLB(ctx context.Context, target domain.Target, opt ConvertOpt, in
 		opt.Visited = states.NewVisitedCollection()
 	}
 	if opt.MetaResolver == nil {
-		opt.MetaResolver = NewCachedMetaResolver(opt.GwClient)
+		opt.MetaResolver = NewCachedMetaResolver(opt.GwClient, opt.Lock)
 	}
 	// Resolve build context.
 	bc, err := opt.Resolver.Resolve(ctx, opt.GwClient, target)
@@ -134,6 +162,9 @@ func Earthfile2LLB(ctx context.Context, target domain.Target, opt ConvertOpt, in
 			}
 			opt.ForceSaveImage = true // legacy mode always saves images regardless of locally or remotely referenced
 		}
+	} else {
+		// The shell is only opened in the initial target, not in the targets it depends on.
+		opt.InteractiveShell = false
 	}
 
 	targetWithMetadata := bc.Ref.(domain.Target)
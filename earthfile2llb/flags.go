@@ -21,7 +21,8 @@ type forOpts struct {
 	Separators string   `long:"sep" description:"The separators to use for tokenizing the output of the IN expression. Defaults to '\n\t '"`
 }
 
-type runOpts struct {
+// RunOpts are the options of the RUN command.
+type RunOpts struct {
 	Push            bool     `long:"push" description:"Execute this command only if the build succeeds and also if earthly is invoked in push mode"`
 	Privileged      bool     `long:"privileged" description:"Enable privileged mode"`
 	WithEntrypoint  bool     `long:"entrypoint" description:"Include the entrypoint of the image when running the command"`
@@ -34,7 +35,8 @@ type runOpts struct {
 	Mounts          []string `long:"mount" description:"Mount a file or directory"`
 }
 
-type fromOpts struct {
+// FromOpts are the options of the FROM command.
+type FromOpts struct {
 	AllowPrivileged bool     `long:"allow-privileged" description:"Allow commands under remote targets to enable privileged mode"`
 	BuildArgs       []string `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
 	Platform        string   `long:"platform" description:"The platform to use"`
@@ -47,7 +49,8 @@ type fromDockerfileOpts struct {
 	Path      string   `short:"f" description:"The Dockerfile location on the host, relative to the current Earthfile, or as an artifact reference"`
 }
 
-type copyOpts struct {
+// CopyOpts are the options of the COPY command.
+type CopyOpts struct {
 	From            string   `long:"from" description:"Not supported"`
 	IsDirCopy       bool     `long:"dir" description:"Copy entire directories, not just the contents"`
 	Chown           string   `long:"chown" description:"Apply a specific group and/or owner to the copied files and directories"`
@@ -60,7 +63,8 @@ type copyOpts struct {
 	BuildArgs       []string `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
 }
 
-type saveArtifactOpts struct {
+// SaveArtifactOpts are the options of the SAVE ARTIFACT command.
+type SaveArtifactOpts struct {
 	KeepTs          bool `long:"keep-ts" description:"Keep created time file timestamps"`
 	KeepOwn         bool `long:"keep-own" description:"Keep owner info"`
 	IfExists        bool `long:"if-exists" description:"Do not fail if the artifact does not exist"`
@@ -68,7 +72,8 @@ type saveArtifactOpts struct {
 	Force           bool `long:"force" description:"Force artifact to be saved, even if it means overwriting files or directories outside of the relative directory"`
 }
 
-type saveImageOpts struct {
+// SaveImageOpts are the options of the SAVE IMAGE command.
+type SaveImageOpts struct {
 	Push      bool     `long:"push" description:"Push the image to the remote registry provided that the build succeeds and also that earthly is invoked in push mode"`
 	CacheHint bool     `long:"cache-hint" description:"Instruct Earthly that the current target shuold be saved entirely as part of the remote cache"`
 	Insecure  bool     `long:"insecure" description:"Use unencrypted connection for the push"`
@@ -82,11 +87,18 @@ type buildOpts struct {
 }
 
 type gitCloneOpts struct {
-	Branch string `long:"branch" description:"The git ref to use when cloning"`
-	KeepTs bool   `long:"keep-ts" description:"Keep created time file timestamps"`
+	Branch     string `long:"branch" description:"The git ref to use when cloning"`
+	Commit     string `long:"commit" description:"The full SHA of the commit to check out"`
+	KeepTs     bool   `long:"keep-ts" description:"Keep created time file timestamps"`
+	Depth      string `long:"depth" description:"The number of commits of history to fetch; 0 fetches the full history"`
+	Submodules bool   `long:"submodules" description:"Check out submodules recursively"`
+	Sparse     string `long:"sparse" description:"A comma-separated list of directories to check out"`
+	LFS        bool   `long:"lfs" description:"Fetch Git LFS objects"`
+	SHAArg     string `long:"sha-arg" description:"The name of an ARG to set to the SHA of the checked out commit"`
 }
 
-type healthCheckOpts struct {
+// HealthCheckOpts are the options of the HEALTHCHECK command.
+type HealthCheckOpts struct {
 	Interval    time.Duration `long:"interval" description:"The interval between healthchecks" default:"30s"`
 	Timeout     time.Duration `long:"timeout" description:"The timeout before the command is considered failed" default:"30s"`
 	StartPeriod time.Duration `long:"start-period" description:"An initialization time period in which failures are not counted towards the maximum number of retries"`
@@ -94,19 +106,24 @@ type healthCheckOpts struct {
 }
 
 type withDockerOpts struct {
-	ComposeFiles    []string `long:"compose" description:"A compose file used to bring up services from"`
-	ComposeServices []string `long:"service" description:"A compose service to bring up"`
-	Loads           []string `long:"load" description:"An image produced by Earthly which is loaded as a Docker image"`
-	Platform        string   `long:"platform" description:"The platform to use"`
-	BuildArgs       []string `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
-	Pulls           []string `long:"pull" description:"An image which is pulled and made available in the docker cache"`
-	AllowPrivileged bool     `long:"allow-privileged" description:"Allow targets referenced by load to assume privileged mode"`
+	ComposeFiles         []string      `long:"compose" description:"A compose file used to bring up services from"`
+	ComposeServices      []string      `long:"service" description:"A compose service to bring up"`
+	ComposeHealthTimeout time.Duration `long:"compose-health-timeout" description:"The time to wait for compose services to become healthy; 0 disables waiting"`
+	ComposeLogs          string        `long:"compose-logs" description:"A directory to write the logs of the compose services to"`
+	Loads                []string      `long:"load" description:"An image produced by Earthly which is loaded as a Docker image"`
+	Platform             string        `long:"platform" description:"The platform to use"`
+	BuildArgs            []string      `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
+	Pulls                []string      `long:"pull" description:"An image which is pulled and made available in the docker cache"`
+	AllowPrivileged      bool          `long:"allow-privileged" description:"Allow targets referenced by load to assume privileged mode"`
+	CacheState           bool          `long:"cache-state" description:"Keep the state of the docker daemon, such as pulled images and layers, between runs of the target"`
 }
 
-type doOpts struct {
+// DoOpts are the options of the DO command.
+type DoOpts struct {
 	AllowPrivileged bool `long:"allow-privileged" description:"Allow targets to assume privileged mode"`
 }
 
-type importOpts struct {
+// ImportOpts are the options of the IMPORT command.
+type ImportOpts struct {
 	AllowPrivileged bool `long:"allow-privileged" description:"Allow targets to assume privileged mode"`
 }
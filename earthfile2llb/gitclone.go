@@ -0,0 +1,263 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/pkg/errors"
+)
+
+const (
+	gitCloneSrcDir  = "/earthly-git-src"
+	gitCloneMetaDir = "/earthly-git-meta"
+	gitCloneSHAFile = "sha"
+	gitLsRemoteFile = "ls-remote"
+	gitAuthPath     = "/run/secrets/earthly-git-auth"
+	gitKnownHosts   = "/tmp/earthly-known-hosts"
+)
+
+var commitSHARegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// GitCloneOpt contains the options of a GIT CLONE command.
+type GitCloneOpt struct {
+	// Branch is the branch or tag to check out. It is mutually exclusive with Commit.
+	Branch string
+	// Commit is the full SHA of the commit to check out.
+	Commit string
+	// KeepTs keeps the timestamps of the cloned files.
+	KeepTs bool
+	// Depth is the number of commits of history to fetch. Zero means the full history.
+	// It is only honored when DepthSet is true; otherwise a single commit is fetched.
+	Depth    int
+	DepthSet bool
+	// Submodules causes submodules to be checked out recursively.
+	Submodules bool
+	// Sparse is a list of directories to check out. All directories are checked out if it is empty.
+	Sparse []string
+	// LFS causes Git LFS objects to be fetched.
+	LFS bool
+	// SHAArg is the name of an ARG to set to the SHA of the checked out commit, if any.
+	SHAArg string
+
+	// Repo is the repository as given in the Earthfile, without credentials. It identifies the
+	// repository in the lock file.
+	Repo string
+	// KeyScan holds the known SSH host keys of the host of the repository, if any.
+	KeyScan string
+}
+
+// ref returns the git ref to check out.
+func (opt GitCloneOpt) ref() string {
+	if opt.Commit != "" {
+		return opt.Commit
+	}
+	return opt.Branch
+}
+
+// useGitImage returns true if the clone needs to be performed within a git container, rather than by the
+// buildkit git source, which only supports shallow clones with all submodules checked out.
+func (opt GitCloneOpt) useGitImage() bool {
+	return opt.DepthSet || opt.Submodules || len(opt.Sparse) > 0 || opt.LFS
+}
+
+func (opt GitCloneOpt) validate() error {
+	if opt.Branch != "" && opt.Commit != "" {
+		return errors.New("--branch and --commit cannot be used together")
+	}
+	if opt.Commit != "" && !commitSHARegexp.MatchString(opt.Commit) {
+		return errors.Errorf("--commit %s is not a full 40-character commit SHA", opt.Commit)
+	}
+	if opt.DepthSet && opt.Depth < 0 {
+		return errors.Errorf("--depth %d must not be negative", opt.Depth)
+	}
+	for _, p := range opt.Sparse {
+		if strings.HasPrefix(p, "/") || p == ".." || strings.HasPrefix(p, "../") {
+			return errors.Errorf("--sparse path %s must be relative to the repository root", p)
+		}
+	}
+	return nil
+}
+
+// description returns a summary of the options, for use in vertex names.
+func (opt GitCloneOpt) description() string {
+	var parts []string
+	if opt.Commit != "" {
+		parts = append(parts, fmt.Sprintf("--commit %s", opt.Commit))
+	} else {
+		parts = append(parts, fmt.Sprintf("--branch %s", opt.Branch))
+	}
+	if opt.DepthSet {
+		parts = append(parts, fmt.Sprintf("--depth %d", opt.Depth))
+	}
+	if opt.Submodules {
+		parts = append(parts, "--submodules")
+	}
+	if len(opt.Sparse) > 0 {
+		parts = append(parts, fmt.Sprintf("--sparse %s", strings.Join(opt.Sparse, ",")))
+	}
+	if opt.LFS {
+		parts = append(parts, "--lfs")
+	}
+	return strings.Join(parts, " ")
+}
+
+// gitScriptPrelude returns the start of a shell script which runs git commands against $EARTHLY_GIT_URL.
+// When a secret holding an Authorization header is mounted at gitAuthPath, the header is passed to every
+// git command (and to the git processes they spawn, such as for submodules or LFS) for the URLs under
+// $EARTHLY_GIT_AUTH_SCOPE only. It is passed on the command line, so that it is never written to disk.
+// SSH host keys are verified against $EARTHLY_GIT_KNOWN_HOSTS.
+func gitScriptPrelude() []string {
+	return []string{
+		"set -e",
+		fmt.Sprintf(`printf '%%s\n' "$EARTHLY_GIT_KNOWN_HOSTS" >%s`, gitKnownHosts),
+		fmt.Sprintf(`export GIT_SSH_COMMAND="ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s"`, gitKnownHosts),
+		"git() {",
+		fmt.Sprintf(`	if [ -f %s ]; then`, gitAuthPath),
+		fmt.Sprintf(`		command git -c "http.$EARTHLY_GIT_AUTH_SCOPE.extraheader=Authorization: $(cat %s)" "$@"`, gitAuthPath),
+		"	else",
+		`		command git "$@"`,
+		"	fi",
+		"}",
+	}
+}
+
+// gitLsRemoteScript returns a shell script which lists the refs of $EARTHLY_GIT_URL matching the given ref
+// into gitCloneMetaDir.
+func gitLsRemoteScript(ref string) string {
+	lines := append(gitScriptPrelude(),
+		fmt.Sprintf(`git ls-remote "$EARTHLY_GIT_URL" %s >%s/%s`,
+			shellescape.Quote(ref), gitCloneMetaDir, gitLsRemoteFile))
+	return strings.Join(lines, "\n")
+}
+
+// resolveLsRemote returns the SHA of the commit which ref refers to, given the output of git ls-remote.
+// As with git rev-parse, tags take precedence over branches. Annotated tags are peeled to the commit
+// they point to.
+func resolveLsRemote(output, ref string) (string, error) {
+	shas := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			shas[fields[1]] = fields[0]
+		}
+	}
+	for _, name := range []string{ref, "refs/" + ref, "refs/tags/" + ref, "refs/heads/" + ref} {
+		if sha, ok := shas[name+"^{}"]; ok {
+			return sha, nil
+		}
+		if sha, ok := shas[name]; ok {
+			return sha, nil
+		}
+	}
+	return "", errors.Errorf("ref %s not found", ref)
+}
+
+// gitCloneScript returns a shell script which clones the given commit of the repository given by
+// $EARTHLY_GIT_URL into gitCloneSrcDir. Any credentials within the URL are removed from the remote
+// configuration of the resulting clone.
+func gitCloneScript(opt GitCloneOpt, sha string) string {
+	lines := append(gitScriptPrelude(),
+		fmt.Sprintf("cd %s", gitCloneSrcDir),
+		"git init -q .",
+		`git remote add origin "$EARTHLY_GIT_URL"`,
+	)
+
+	fetch := []string{"git", "fetch", "-q", "--no-tags"}
+	if opt.DepthSet && opt.Depth > 0 {
+		fetch = append(fetch, fmt.Sprintf("--depth=%d", opt.Depth))
+	} else if !opt.DepthSet {
+		fetch = append(fetch, "--depth=1")
+	}
+	if len(opt.Sparse) > 0 {
+		// Only fetch the blobs of the files which are checked out.
+		fetch = append(fetch, "--filter=blob:none")
+		lines = append(lines,
+			"git sparse-checkout init --cone",
+			"git sparse-checkout set "+quoteAll(opt.Sparse))
+	}
+	fetch = append(fetch, "origin", shellescape.Quote(sha))
+	lines = append(lines, strings.Join(fetch, " "))
+	lines = append(lines, "git checkout -q FETCH_HEAD")
+
+	if opt.Submodules {
+		submodule := "git submodule update -q --init --recursive"
+		if opt.DepthSet && opt.Depth > 0 {
+			submodule += fmt.Sprintf(" --depth=%d", opt.Depth)
+		} else if !opt.DepthSet {
+			submodule += " --depth=1"
+		}
+		lines = append(lines, submodule)
+	}
+	if opt.LFS {
+		lines = append(lines, "git lfs install --local >/dev/null", "git lfs pull")
+	}
+	lines = append(lines, `git remote set-url origin "$EARTHLY_GIT_URL_SCRUBBED"`)
+	return strings.Join(lines, "\n")
+}
+
+// gitAuthScope returns the URL prefix to which the credentials of the given https URL apply.
+func gitAuthScope(gitURL string) string {
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return gitURL
+	}
+	return fmt.Sprintf("%s://%s/", u.Scheme, u.Host)
+}
+
+func quoteAll(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, shellescape.Quote(a))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// stripCredentials removes the user info from http(s) git URLs.
+func stripCredentials(gitURL string) string {
+	u, err := url.Parse(gitURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return gitURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// GitCloneSource is the source of a GIT CLONE command.
+type GitCloneSource struct {
+	// Repo is the URL of the repository, without credentials.
+	Repo string
+	// State is the state of the clone.
+	State pllb.State
+}
+
+// GitCloneSources records the sources of the GIT CLONE commands of a build. It is safe for concurrent use.
+type GitCloneSources struct {
+	sources []GitCloneSource
+	mu      sync.Mutex
+}
+
+// NewGitCloneSources returns a new, empty GitCloneSources.
+func NewGitCloneSources() *GitCloneSources {
+	return &GitCloneSources{}
+}
+
+// All returns the recorded sources, in the order in which they were recorded.
+func (gcs *GitCloneSources) All() []GitCloneSource {
+	gcs.mu.Lock()
+	defer gcs.mu.Unlock()
+	return append([]GitCloneSource{}, gcs.sources...)
+}
+
+func (gcs *GitCloneSources) record(source GitCloneSource) {
+	if gcs == nil {
+		return
+	}
+	gcs.mu.Lock()
+	defer gcs.mu.Unlock()
+	gcs.sources = append(gcs.sources, source)
+}
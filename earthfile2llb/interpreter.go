@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/earthly/earthly/analytics"
@@ -101,7 +102,39 @@ func (i *Interpreter) handleTarget(ctx context.Context, t spec.Target) error {
 	if err != nil {
 		return i.wrapError(err, t.SourceLocation, "apply FROM")
 	}
-	return i.handleBlock(ctx, t.Recipe)
+	if i.converter.opt.Breakpoints.matchTarget(i.target) {
+		err = i.handleBreakpoint(ctx, t.SourceLocation, fmt.Sprintf("before %s", i.target.String()))
+		if err != nil {
+			return err
+		}
+	}
+	err = i.handleBlock(ctx, t.Recipe)
+	if err != nil {
+		return err
+	}
+	if i.converter.opt.InteractiveShell {
+		if i.local {
+			return i.errorf(t.SourceLocation, "interactive shells are not supported in LOCALLY targets")
+		}
+		err = i.converter.InteractiveShell(ctx)
+		if err != nil {
+			return i.wrapError(err, t.SourceLocation, "open interactive shell")
+		}
+	}
+	return nil
+}
+
+// handleBreakpoint pauses the build to open an interactive shell in the current state of the target.
+func (i *Interpreter) handleBreakpoint(ctx context.Context, sl *spec.SourceLocation, location string) error {
+	if i.local {
+		i.console.Warnf("Ignoring breakpoint %s: breakpoints are not supported in LOCALLY targets\n", location)
+		return nil
+	}
+	err := i.converter.Breakpoint(ctx, location)
+	if err != nil {
+		return i.wrapError(err, sl, "breakpoint %s", location)
+	}
+	return nil
 }
 
 func (i *Interpreter) handleBlock(ctx context.Context, b spec.Block) error {
@@ -179,7 +212,7 @@ func (i *Interpreter) handleStatement(ctx context.Context, stmt spec.Statement)
 
 func (i *Interpreter) handleCommand(ctx context.Context, cmd spec.Command) (err error) {
 	// The AST should not be modified by any operation. This is a consistency check.
-	argsCopy := getArgsCopy(cmd)
+	argsCopy := GetArgsCopy(cmd)
 	defer func() {
 		if err != nil {
 			return
@@ -197,6 +230,15 @@ func (i *Interpreter) handleCommand(ctx context.Context, cmd spec.Command) (err
 	}()
 
 	analytics.Count("cmd", cmd.Name)
+	i.converter.sourceLocation = cmd.SourceLocation
+
+	if i.converter.opt.Breakpoints.matchCommand(cmd.SourceLocation) {
+		err = i.handleBreakpoint(ctx, cmd.SourceLocation,
+			fmt.Sprintf("before %s line %d", cmd.SourceLocation.File, cmd.SourceLocation.StartLine))
+		if err != nil {
+			return err
+		}
+	}
 
 	if i.isWith {
 		switch cmd.Name {
@@ -422,8 +464,8 @@ func (i *Interpreter) handleFrom(ctx context.Context, cmd spec.Command) error {
 	if i.pushOnlyAllowed {
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
-	opts := fromOpts{}
-	args, err := flagutil.ParseArgs("FROM", &opts, getArgsCopy(cmd))
+	opts := FromOpts{}
+	args, err := flagutil.ParseArgs("FROM", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid FROM arguments %v", cmd.Args)
 	}
@@ -505,8 +547,8 @@ func (i *Interpreter) handleRun(ctx context.Context, cmd spec.Command) error {
 	if len(cmd.Args) < 1 {
 		return i.errorf(cmd.SourceLocation, "not enough arguments for RUN")
 	}
-	opts := runOpts{}
-	args, err := flagutil.ParseArgsWithValueModifier("RUN", &opts, getArgsCopy(cmd), i.flagValModifier)
+	opts := RunOpts{}
+	args, err := flagutil.ParseArgsWithValueModifier("RUN", &opts, GetArgsCopy(cmd), i.flagValModifier)
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid RUN arguments %v", cmd.Args)
 	}
@@ -593,7 +635,7 @@ func (i *Interpreter) handleFromDockerfile(ctx context.Context, cmd spec.Command
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
 	opts := fromDockerfileOpts{}
-	args, err := flagutil.ParseArgs("FROM DOCKERFILE", &opts, getArgsCopy(cmd))
+	args, err := flagutil.ParseArgs("FROM DOCKERFILE", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid FROM DOCKERFILE arguments %v", cmd.Args)
 	}
@@ -654,8 +696,8 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 	if i.pushOnlyAllowed {
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
-	opts := copyOpts{}
-	args, err := flagutil.ParseArgs("COPY", &opts, getArgsCopy(cmd))
+	opts := CopyOpts{}
+	args, err := flagutil.ParseArgs("COPY", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid COPY arguments %v", cmd.Args)
 	}
@@ -683,7 +725,7 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 		var parseErr error
 		if strings.HasPrefix(src, "(") && strings.HasSuffix(src, ")") {
 			// COPY (<src> <flag-args>) ...
-			artifactStr, extraArgs, err := parseParans(src)
+			artifactStr, extraArgs, err := ParseParans(src)
 			if err != nil {
 				return i.wrapError(err, cmd.SourceLocation, "parse parans %s", src)
 			}
@@ -754,8 +796,8 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 }
 
 func (i *Interpreter) handleSaveArtifact(ctx context.Context, cmd spec.Command) error {
-	opts := saveArtifactOpts{}
-	args, err := flagutil.ParseArgs("SAVE ARTIFACT", &opts, getArgsCopy(cmd))
+	opts := SaveArtifactOpts{}
+	args, err := flagutil.ParseArgs("SAVE ARTIFACT", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid SAVE ARTIFACT arguments %v", cmd.Args)
 	}
@@ -806,8 +848,8 @@ func (i *Interpreter) handleSaveArtifact(ctx context.Context, cmd spec.Command)
 }
 
 func (i *Interpreter) handleSaveImage(ctx context.Context, cmd spec.Command) error {
-	opts := saveImageOpts{}
-	args, err := flagutil.ParseArgs("SAVE IMAGE", &opts, getArgsCopy(cmd))
+	opts := SaveImageOpts{}
+	args, err := flagutil.ParseArgs("SAVE IMAGE", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid SAVE IMAGE arguments %v", cmd.Args)
 	}
@@ -841,7 +883,7 @@ func (i *Interpreter) handleBuild(ctx context.Context, cmd spec.Command, async b
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
 	opts := buildOpts{}
-	args, err := flagutil.ParseArgs("BUILD", &opts, getArgsCopy(cmd))
+	args, err := flagutil.ParseArgs("BUILD", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid BUILD arguments %v", cmd.Args)
 	}
@@ -933,7 +975,7 @@ func (i *Interpreter) handleCmd(ctx context.Context, cmd spec.Command) error {
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
 	withShell := !cmd.ExecMode
-	cmdArgs := getArgsCopy(cmd)
+	cmdArgs := GetArgsCopy(cmd)
 	if !withShell {
 		for index, arg := range cmdArgs {
 			cmdArgs[index] = i.expandArgs(arg, false)
@@ -951,7 +993,7 @@ func (i *Interpreter) handleEntrypoint(ctx context.Context, cmd spec.Command) er
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
 	withShell := !cmd.ExecMode
-	entArgs := getArgsCopy(cmd)
+	entArgs := GetArgsCopy(cmd)
 	if !withShell {
 		for index, arg := range entArgs {
 			entArgs[index] = i.expandArgs(arg, false)
@@ -971,7 +1013,7 @@ func (i *Interpreter) handleExpose(ctx context.Context, cmd spec.Command) error
 	if len(cmd.Args) == 0 {
 		return i.errorf(cmd.SourceLocation, "no arguments provided to the EXPOSE command")
 	}
-	ports := getArgsCopy(cmd)
+	ports := GetArgsCopy(cmd)
 	for index, port := range ports {
 		ports[index] = i.expandArgs(port, false)
 	}
@@ -989,7 +1031,7 @@ func (i *Interpreter) handleVolume(ctx context.Context, cmd spec.Command) error
 	if len(cmd.Args) == 0 {
 		return i.errorf(cmd.SourceLocation, "no arguments provided to the VOLUME command")
 	}
-	volumes := getArgsCopy(cmd)
+	volumes := GetArgsCopy(cmd)
 	for index, volume := range volumes {
 		volumes[index] = i.expandArgs(volume, false)
 	}
@@ -1092,7 +1134,7 @@ func (i *Interpreter) handleGitClone(ctx context.Context, cmd spec.Command) erro
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
 	opts := gitCloneOpts{}
-	args, err := flagutil.ParseArgs("GIT CLONE", &opts, getArgsCopy(cmd))
+	args, err := flagutil.ParseArgs("GIT CLONE", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid GIT CLONE arguments %v", cmd.Args)
 	}
@@ -1101,14 +1143,39 @@ func (i *Interpreter) handleGitClone(ctx context.Context, cmd spec.Command) erro
 	}
 	gitURL := i.expandArgs(args[0], false)
 	gitCloneDest := i.expandArgs(args[1], false)
-	opts.Branch = i.expandArgs(opts.Branch, false)
+	cloneOpt := GitCloneOpt{
+		Branch:     i.expandArgs(opts.Branch, false),
+		Commit:     i.expandArgs(opts.Commit, false),
+		KeepTs:     opts.KeepTs,
+		Submodules: opts.Submodules,
+		LFS:        opts.LFS,
+		SHAArg:     opts.SHAArg,
+	}
+	if opts.Depth != "" {
+		depthStr := i.expandArgs(opts.Depth, false)
+		cloneOpt.Depth, err = strconv.Atoi(depthStr)
+		if err != nil {
+			return i.errorf(cmd.SourceLocation, "invalid --depth %s for GIT CLONE", depthStr)
+		}
+		cloneOpt.DepthSet = true
+	}
+	if opts.Sparse != "" {
+		for _, p := range strings.Split(i.expandArgs(opts.Sparse, false), ",") {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				cloneOpt.Sparse = append(cloneOpt.Sparse, p)
+			}
+		}
+	}
 
-	convertedGitURL, _, err := i.gitLookup.ConvertCloneURL(gitURL)
+	convertedGitURL, keyScan, err := i.gitLookup.ConvertCloneURL(gitURL)
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "unable to use %v with configured earthly credentials from ~/.earthly/config.yml", cmd.Args)
 	}
+	cloneOpt.Repo = stripCredentials(gitURL)
+	cloneOpt.KeyScan = keyScan
 
-	err = i.converter.GitClone(ctx, convertedGitURL, opts.Branch, gitCloneDest, opts.KeepTs)
+	err = i.converter.GitClone(ctx, convertedGitURL, gitCloneDest, cloneOpt)
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "git clone")
 	}
@@ -1119,8 +1186,8 @@ func (i *Interpreter) handleHealthcheck(ctx context.Context, cmd spec.Command) e
 	if i.pushOnlyAllowed {
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
-	opts := healthCheckOpts{}
-	args, err := flagutil.ParseArgs("HEALTHCHECK", &opts, getArgsCopy(cmd))
+	opts := HealthCheckOpts{}
+	args, err := flagutil.ParseArgs("HEALTHCHECK", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid HEALTHCHECK arguments %v", cmd.Args)
 	}
@@ -1164,7 +1231,7 @@ func (i *Interpreter) handleWithDocker(ctx context.Context, cmd spec.Command) er
 		return i.errorf(cmd.SourceLocation, "cannot use WITH DOCKER within WITH DOCKER")
 	}
 	opts := withDockerOpts{}
-	args, err := flagutil.ParseArgs("WITH DOCKER", &opts, getArgsCopy(cmd))
+	args, err := flagutil.ParseArgs("WITH DOCKER", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid WITH DOCKER arguments %v", cmd.Args)
 	}
@@ -1182,6 +1249,10 @@ func (i *Interpreter) handleWithDocker(ctx context.Context, cmd spec.Command) er
 	for index, cs := range opts.ComposeServices {
 		opts.ComposeServices[index] = i.expandArgs(cs, false)
 	}
+	if opts.ComposeHealthTimeout < 0 {
+		return i.errorf(cmd.SourceLocation, "invalid WITH DOCKER --compose-health-timeout %s", opts.ComposeHealthTimeout)
+	}
+	opts.ComposeLogs = i.expandArgs(opts.ComposeLogs, false)
 	for index, load := range opts.Loads {
 		opts.Loads[index] = i.expandArgs(load, true)
 	}
@@ -1191,8 +1262,11 @@ func (i *Interpreter) handleWithDocker(ctx context.Context, cmd spec.Command) er
 	}
 
 	i.withDocker = &WithDockerOpt{
-		ComposeFiles:    opts.ComposeFiles,
-		ComposeServices: opts.ComposeServices,
+		ComposeFiles:         opts.ComposeFiles,
+		ComposeServices:      opts.ComposeServices,
+		ComposeHealthTimeout: opts.ComposeHealthTimeout,
+		ComposeLogs:          opts.ComposeLogs,
+		CacheState:           opts.CacheState,
 	}
 	for _, pullStr := range opts.Pulls {
 		i.withDocker.Pulls = append(i.withDocker.Pulls, DockerPullOpt{
@@ -1249,8 +1323,8 @@ func (i *Interpreter) handleUserCommand(ctx context.Context, cmd spec.Command) e
 }
 
 func (i *Interpreter) handleDo(ctx context.Context, cmd spec.Command) error {
-	opts := doOpts{}
-	args, err := flagutil.ParseArgs("DO", &opts, getArgsCopy(cmd))
+	opts := DoOpts{}
+	args, err := flagutil.ParseArgs("DO", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid DO arguments %v", cmd.Args)
 	}
@@ -1295,8 +1369,8 @@ func (i *Interpreter) handleDo(ctx context.Context, cmd spec.Command) error {
 }
 
 func (i *Interpreter) handleImport(ctx context.Context, cmd spec.Command) error {
-	opts := importOpts{}
-	args, err := flagutil.ParseArgs("IMPORT", &opts, getArgsCopy(cmd))
+	opts := ImportOpts{}
+	args, err := flagutil.ParseArgs("IMPORT", &opts, GetArgsCopy(cmd))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "invalid IMPORT arguments %v", cmd.Args)
 	}
@@ -1378,11 +1452,11 @@ func (i *Interpreter) pushOnlyErr(sl *spec.SourceLocation) error {
 }
 
 func (i *Interpreter) expandArgs(word string, keepPlusEscape bool) string {
-	ret := i.converter.ExpandArgs(escapeSlashPlus(word))
+	ret := i.converter.ExpandArgs(EscapeSlashPlus(word))
 	if keepPlusEscape {
 		return ret
 	}
-	return unescapeSlashPlus(ret)
+	return UnescapeSlashPlus(ret)
 }
 
 func (i *Interpreter) monitorErrChan(ctx context.Context, errChan chan error) {
@@ -1397,12 +1471,14 @@ func (i *Interpreter) monitorErrChan(ctx context.Context, errChan chan error) {
 	}()
 }
 
-func escapeSlashPlus(str string) string {
+// EscapeSlashPlus escapes \+ in a word, such that it is kept through variable expansion.
+func EscapeSlashPlus(str string) string {
 	// TODO: This is not entirely correct in a string like "\\\\+".
 	return strings.ReplaceAll(str, "\\+", "\\\\+")
 }
 
-func unescapeSlashPlus(str string) string {
+// UnescapeSlashPlus reverses EscapeSlashPlus, once the variables have been expanded.
+func UnescapeSlashPlus(str string) string {
 	// TODO: This is not entirely correct in a string like "\\\\+".
 	return strings.ReplaceAll(str, "\\+", "+")
 }
@@ -1420,7 +1496,7 @@ func parseLoad(loadStr string) (image string, target string, extraArgs []string,
 		target = splitLoad[1]
 	}
 	if strings.HasPrefix(target, "(") && strings.HasSuffix(target, ")") {
-		target, extraArgs, err = parseParans(target)
+		target, extraArgs, err = ParseParans(target)
 		if err != nil {
 			return "", "", nil, err
 		}
@@ -1428,7 +1504,8 @@ func parseLoad(loadStr string) (image string, target string, extraArgs []string,
 	return image, target, extraArgs, nil
 }
 
-func getArgsCopy(cmd spec.Command) []string {
+// GetArgsCopy returns a copy of the args of the command, which may be modified while parsing its flags.
+func GetArgsCopy(cmd spec.Command) []string {
 	argsCopy := make([]string, len(cmd.Args))
 	copy(argsCopy, cmd.Args)
 	return argsCopy
@@ -1500,8 +1577,8 @@ func parseKeyValue(arg string) (string, *string, error) {
 	return name, value, nil
 }
 
-// parseParans turns "(+target --flag=something)" into "+target" and []string{"--flag=something"}.
-func parseParans(str string) (string, []string, error) {
+// ParseParans turns "(+target --flag=something)" into "+target" and []string{"--flag=something"}.
+func ParseParans(str string) (string, []string, error) {
 	if !strings.HasPrefix(str, "(") || !strings.HasSuffix(str, ")") {
 		return "", nil, errors.New("parans atom not in ( ... )")
 	}
@@ -46,7 +46,7 @@ func TestParseParans(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		actualFirst, actualArgs, err := parseParans(tt.in)
+		actualFirst, actualArgs, err := ParseParans(tt.in)
 		assert.NoError(t, err)
 		assert.Equal(t, tt.first, actualFirst)
 		assert.Equal(t, tt.args, actualArgs)
@@ -66,7 +66,7 @@ func TestNegativeParseParans(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		_, _, err := parseParans(tt.in)
+		_, _, err := ParseParans(tt.in)
 		assert.Error(t, err)
 	}
 }
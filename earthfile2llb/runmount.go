@@ -4,6 +4,7 @@ import (
 	"path"
 	"strings"
 
+	"github.com/earthly/earthly/buildcontext"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/states/dedup"
 	"github.com/earthly/earthly/util/llbutil"
@@ -12,19 +13,29 @@ import (
 	"github.com/pkg/errors"
 )
 
-func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State) ([]llb.RunOption, error) {
+const (
+	// cacheScopeTarget isolates a cache mount to the target (and its build args) it is used in.
+	cacheScopeTarget = "target"
+	// cacheScopeGlobal shares a cache mount with every other cache mount using the same id.
+	cacheScopeGlobal = "global"
+)
+
+// parseMounts returns the run options of the mounts, together with the cache mounts among them,
+// keyed by the path they are mounted at.
+func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State) ([]llb.RunOption, map[string]cacheMount, error) {
 	var runOpts []llb.RunOption
+	cacheMounts := make(map[string]cacheMount)
 	for _, mount := range mounts {
-		mountRunOpts, err := parseMount(mount, target, ti, cacheContext)
+		mountRunOpts, err := parseMount(mount, target, ti, cacheContext, cacheMounts)
 		if err != nil {
-			return nil, errors.Wrap(err, "parse mount")
+			return nil, nil, errors.Wrap(err, "parse mount")
 		}
 		runOpts = append(runOpts, mountRunOpts...)
 	}
-	return runOpts, nil
+	return runOpts, cacheMounts, nil
 }
 
-func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State) ([]llb.RunOption, error) {
+func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State, cacheMounts map[string]cacheMount) ([]llb.RunOption, error) {
 	var state pllb.State
 	var mountSource string
 	var mountTarget string
@@ -32,6 +43,7 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 	var mountType string
 	var mountOpts []llb.MountOption
 	sharingMode := llb.CacheMountShared
+	cacheScope := cacheScopeTarget
 	kvPairs := strings.Split(mount, ",")
 	for _, kvPair := range kvPairs {
 		kvSplit := strings.SplitN(kvPair, "=", 2)
@@ -110,6 +122,16 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 			default:
 				return nil, errors.Errorf("invalid mount arg %s", kvPair)
 			}
+		case "scope":
+			if len(kvSplit) != 2 {
+				return nil, errors.Errorf("invalid mount arg %s", kvPair)
+			}
+			switch kvSplit[1] {
+			case cacheScopeTarget, cacheScopeGlobal:
+				cacheScope = kvSplit[1]
+			default:
+				return nil, errors.Errorf("invalid mount arg %s", kvPair)
+			}
 		case "from":
 			return nil, errors.Errorf("not yet supported %s", kvPair)
 		default:
@@ -119,6 +141,12 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 	if mountType == "" {
 		return nil, errors.Errorf("mount type not specified")
 	}
+	if cacheScope != cacheScopeTarget && mountType != "cache" {
+		return nil, errors.Errorf("scope is only supported for cache mounts")
+	}
+	if cacheScope == cacheScopeGlobal && mountID == "" {
+		return nil, errors.Errorf("an explicit id is required for cache mounts with scope=global")
+	}
 	if mountID == "" {
 		mountID = path.Clean(mountTarget)
 	}
@@ -137,12 +165,12 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 		if mountTarget == "" {
 			return nil, errors.Errorf("mount target not specified")
 		}
-		key, err := cacheKeyTargetInput(ti)
+		cachePath, err := cacheMountPath(cacheScope, mountID, ti)
 		if err != nil {
 			return nil, err
 		}
-		cachePath := path.Join("/run/cache", key, mountID)
 		mountOpts = append(mountOpts, llb.AsPersistentCacheDir(cachePath, sharingMode))
+		cacheMounts[mountTarget] = cacheMount{ID: mountID, Scope: cacheScope}
 		state = cacheContext
 		return []llb.RunOption{pllb.AddMount(mountTarget, state, mountOpts...)}, nil
 	case "tmpfs":
@@ -163,6 +191,9 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 			return nil, errors.Errorf("mount target not specified")
 		}
 		secretID := strings.TrimPrefix(mountID, "+secrets/")
+		if buildcontext.IsGitAuthSecret(secretID) {
+			return nil, errors.Errorf("secret %s is reserved for use by earthly", secretID)
+		}
 		secretOpts := []llb.SecretOption{
 			llb.SecretID(secretID),
 			// TODO: Perhaps this should just default to the current user automatically from
@@ -175,6 +206,30 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 	}
 }
 
+// cacheMountPath returns the ID of the persistent cache dir of a cache mount. The id of a global cache mount
+// may not escape its own dir, as that would allow it to reach the cache of another id, or all of them.
+func cacheMountPath(cacheScope, mountID string, ti dedup.TargetInput) (string, error) {
+	switch cacheScope {
+	case cacheScopeGlobal:
+		if strings.HasPrefix(mountID, "/") {
+			return "", errors.Errorf("invalid cache mount id %s: must not start with / when scope=global", mountID)
+		}
+		for _, segment := range strings.Split(mountID, "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				return "", errors.Errorf("invalid cache mount id %s: must not contain empty, . or .. segments when scope=global", mountID)
+			}
+		}
+		// Keyed only by the id, such that it is shared across targets and Earthfiles.
+		return path.Join("/run/global-cache", mountID), nil
+	default:
+		key, err := cacheKeyTargetInput(ti)
+		if err != nil {
+			return "", err
+		}
+		return path.Join("/run/cache", key, mountID), nil
+	}
+}
+
 func cacheKeyTargetInput(ti dedup.TargetInput) (string, error) {
 	digest, err := ti.HashNoTag()
 	if err != nil {
@@ -0,0 +1,76 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/states/dedup"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMountScope(t *testing.T) {
+	var tests = []struct {
+		mount string
+		ok    bool
+	}{
+		{"type=cache,target=/cache", true},
+		{"type=cache,target=/cache,scope=target", true},
+		{"type=cache,target=/cache,id=gomod,scope=global", true},
+		{"type=cache,target=/cache,id=gomod,scope=global,sharing=locked", true},
+		{"type=cache,target=/cache,scope=global", false},
+		{"type=cache,target=/cache,id=gomod,scope=project", false},
+		{"type=tmpfs,target=/tmp,scope=global", false},
+		{"type=cache,target=/cache,id=../other,scope=global", false},
+		{"type=cache,target=/cache,id=/other,scope=global", false},
+		{"type=cache,target=/cache,id=../other", true},
+		{"type=cache,target=../cache", true},
+		{"type=cache,target=/cache,id=.,scope=global", false},
+		{"type=secret,target=/token,id=+secrets/token", true},
+		{"type=secret,target=/token,id=+secrets/earthly_git_auth_0123456789abcdef", false},
+	}
+
+	cacheContext := pllb.Scratch()
+	for _, tt := range tests {
+		_, err := parseMount(tt.mount, domain.Target{}, dedup.TargetInput{}, cacheContext, make(map[string]cacheMount))
+		if tt.ok {
+			assert.NoError(t, err, tt.mount)
+		} else {
+			assert.Error(t, err, tt.mount)
+		}
+	}
+}
+
+func TestCacheMountPath(t *testing.T) {
+	ti := dedup.TargetInput{TargetCanonical: "+build"}
+	key, err := cacheKeyTargetInput(ti)
+	assert.NoError(t, err)
+
+	var tests = []struct {
+		scope    string
+		id       string
+		expected string
+	}{
+		{cacheScopeGlobal, "gomod", "/run/global-cache/gomod"},
+		{cacheScopeGlobal, "go/mod", "/run/global-cache/go/mod"},
+		{cacheScopeGlobal, "../other", ""},
+		{cacheScopeGlobal, "go/../../other", ""},
+		{cacheScopeGlobal, "/other", ""},
+		{cacheScopeGlobal, ".", ""},
+		{cacheScopeGlobal, "go/.", ""},
+		{cacheScopeGlobal, "go//mod", ""},
+		{cacheScopeGlobal, "gomod/", ""},
+		{cacheScopeTarget, "/cache", "/run/cache/" + key + "/cache"},
+		{cacheScopeTarget, "gomod", "/run/cache/" + key + "/gomod"},
+		{cacheScopeTarget, "npm/../yarn", "/run/cache/" + key + "/yarn"},
+	}
+	for _, tt := range tests {
+		actual, err := cacheMountPath(tt.scope, tt.id, ti)
+		if tt.expected == "" {
+			assert.Error(t, err, tt.id)
+			continue
+		}
+		assert.NoError(t, err, tt.id)
+		assert.Equal(t, tt.expected, actual, tt.id)
+	}
+}
@@ -0,0 +1,104 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/earthly/earthly/states/image"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+
+	"github.com/alessio/shellescape"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// RunState is the state in which a RUN command is executed.
+type RunState struct {
+	// State is the filesystem on which the command runs.
+	State pllb.State
+	// Image is the image config of the target at the time the command runs.
+	Image *image.Image
+	// Platform is the platform the command runs on.
+	Platform specs.Platform
+	// Args are the arguments of the command.
+	Args []string
+	// WithShell indicates whether the args are run via /bin/sh -c.
+	WithShell bool
+	// BuildArgs are the build args, as environment variables, available to the command.
+	BuildArgs map[string]string
+	// Privileged indicates whether the command runs with --privileged.
+	Privileged bool
+}
+
+// RunStates records the state in which each RUN command is executed, by vertex name, so that the
+// state of a command which failed can be reproduced after the build. It is safe for concurrent use.
+type RunStates struct {
+	runs map[string]RunState
+	mu   sync.Mutex
+}
+
+// NewRunStates returns a new, empty RunStates.
+func NewRunStates() *RunStates {
+	return &RunStates{
+		runs: make(map[string]RunState),
+	}
+}
+
+// Get returns the state in which the command of the given vertex was executed.
+func (rs *RunStates) Get(vertexName string) (RunState, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	run, ok := rs.runs[vertexName]
+	return run, ok
+}
+
+func (rs *RunStates) record(vertexName string, run RunState) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.runs[vertexName]; ok {
+		// Identical vertex names are typically the same command on the same state; keep the first.
+		return
+	}
+	rs.runs[vertexName] = run
+}
+
+// DockerRunCmd returns the command which runs the command again, in the given image, using the
+// binary of the container frontend (e.g. docker or podman).
+func (run RunState) DockerRunCmd(binary, imageName string) string {
+	args := []string{binary, "run", "--rm", "-it"}
+	if run.Privileged {
+		args = append(args, "--privileged")
+	}
+	if run.Image != nil {
+		if run.Image.Config.WorkingDir != "" {
+			args = append(args, "-w", run.Image.Config.WorkingDir)
+		}
+		if run.Image.Config.User != "" {
+			args = append(args, "--user", run.Image.Config.User)
+		}
+	}
+	buildArgNames := make([]string, 0, len(run.BuildArgs))
+	for name := range run.BuildArgs {
+		buildArgNames = append(buildArgNames, name)
+	}
+	sort.Strings(buildArgNames)
+	for _, name := range buildArgNames {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", name, run.BuildArgs[name]))
+	}
+	cmd := run.Args
+	if run.WithShell {
+		cmd = []string{"/bin/sh", "-c", strings.Join(run.Args, " ")}
+	}
+	if len(cmd) > 0 {
+		// Override any entrypoint, as the args are run as is.
+		args = append(args, "--entrypoint", cmd[0], imageName)
+		args = append(args, cmd[1:]...)
+	} else {
+		args = append(args, imageName)
+	}
+	return shellescape.QuoteCommand(args)
+}
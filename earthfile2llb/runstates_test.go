@@ -0,0 +1,49 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/states/image"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunStateDockerRunCmd(t *testing.T) {
+	img := image.NewImage()
+	img.Config.WorkingDir = "/src"
+	img.Config.Entrypoint = []string{"/entrypoint.sh"}
+
+	run := RunState{
+		Image:     img,
+		Args:      []string{"go", "test", "./...", "&&", "echo", "$VERSION"},
+		WithShell: true,
+		BuildArgs: map[string]string{"VERSION": "1.0 beta", "GOOS": "linux"},
+	}
+	assert.Equal(t,
+		`docker run --rm -it -w /src -e GOOS=linux -e 'VERSION=1.0 beta' --entrypoint /bin/sh failed:latest -c 'go test ./... && echo $VERSION'`,
+		run.DockerRunCmd("docker", "failed:latest"))
+
+	run = RunState{
+		Image:      img,
+		Args:       []string{"/entrypoint.sh", "--flag"},
+		Privileged: true,
+	}
+	assert.Equal(t,
+		`podman run --rm -it --privileged -w /src --entrypoint /entrypoint.sh failed --flag`,
+		run.DockerRunCmd("podman", "failed"))
+}
+
+func TestRunStatesRecord(t *testing.T) {
+	rs := NewRunStates()
+	rs.record("[+build] RUN make", RunState{Args: []string{"make"}})
+	rs.record("[+build] RUN make", RunState{Args: []string{"make", "again"}})
+
+	run, ok := rs.Get("[+build] RUN make")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"make"}, run.Args)
+
+	_, ok = rs.Get("[+build] RUN other")
+	assert.False(t, ok)
+
+	var none *RunStates
+	none.record("[+build] RUN make", RunState{})
+}
@@ -74,6 +74,9 @@ func strWithEnvVarsAndDocker(args []string, envVars []string, withShell, withDeb
 
 type shellWrapFun func(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string
 
+// interactiveShellCmd starts the best available shell for an interactive session.
+const interactiveShellCmd = "if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"
+
 func withShellAndEnvVars(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string {
 	return []string{
 		"/bin/sh", "-c",
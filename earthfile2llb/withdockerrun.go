@@ -10,6 +10,7 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd/platforms"
 	"github.com/earthly/earthly/dockertar"
@@ -21,6 +22,7 @@ import (
 	gwclient "github.com/moby/buildkit/frontend/gateway/client"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
@@ -28,6 +30,12 @@ const (
 	dockerdWrapperPath          = "/var/earthly/dockerd-wrapper.sh"
 	dockerAutoInstallScriptPath = "/var/earthly/docker-auto-install.sh"
 	composeConfigFile           = "compose-config.yml"
+	// composeLogsDir is bound to a directory of the buildkit host, such that the compose logs are
+	// kept even if the command fails, and the state of its container is discarded.
+	composeLogsDir     = "/var/earthly/compose-logs"
+	composeLogsHostDir = "/tmp/earthly/compose-logs"
+	// composeLogsImage is the image of the internal container which outputs the compose logs.
+	composeLogsImage = "busybox:1.33.1"
 )
 
 // DockerLoadOpt holds parameters for WITH DOCKER --load parameter.
@@ -58,6 +66,15 @@ type WithDockerOpt struct {
 	Loads           []DockerLoadOpt
 	ComposeFiles    []string
 	ComposeServices []string
+	// ComposeHealthTimeout is the time to wait for the compose services to become healthy before
+	// running the command. Zero disables waiting.
+	ComposeHealthTimeout time.Duration
+	// ComposeLogs is a local directory, relative to the Earthfile, to which the logs of the compose
+	// services are output once the command has run, whether it succeeded or not.
+	ComposeLogs string
+	// CacheState keeps the data root of dockerd between runs of the target, instead of starting
+	// from an empty one each time.
+	CacheState bool
 }
 
 type withDockerRun struct {
@@ -101,11 +118,10 @@ func (wdr *withDockerRun) Run(ctx context.Context, args []string, opt WithDocker
 		if composeImagesSet[key] {
 			delete(composeImagesSet, key)
 		}
-		// Load.
-		err := wdr.load(ctx, loadOpt)
-		if err != nil {
-			return errors.Wrap(err, "load")
-		}
+	}
+	err = wdr.loadAll(ctx, opt.Loads)
+	if err != nil {
+		return errors.Wrap(err, "load")
 	}
 	// Add compose images (what's left of them) to the pull list.
 	for _, pull := range composePulls {
@@ -146,6 +162,10 @@ func (wdr *withDockerRun) Run(ctx context.Context, args []string, opt WithDocker
 		"/var/earthly/dind", pllb.Scratch(), llb.HostBind(), llb.SourcePath("/tmp/earthly/dind")))
 	crOpts.extraRunOpts = append(crOpts.extraRunOpts, pllb.AddMount(
 		dockerdWrapperPath, pllb.Scratch(), llb.HostBind(), llb.SourcePath(dockerdWrapperPath)))
+	if opt.CacheState {
+		crOpts.extraRunOpts = append(crOpts.extraRunOpts, pllb.AddMount(
+			"/var/earthly/dind-cache", pllb.Scratch(), llb.HostBind(), llb.SourcePath("/tmp/earthly/dind-cache")))
+	}
 	var tarPaths []string
 	for index, tarContext := range wdr.tarLoads {
 		loadDir := fmt.Sprintf("/var/earthly/load-%d", index)
@@ -157,7 +177,27 @@ func (wdr *withDockerRun) Run(ctx context.Context, args []string, opt WithDocker
 	if err != nil {
 		return errors.Wrap(err, "compute dind id")
 	}
-	crOpts.shellWrap = makeWithDockerdWrapFun(dindID, tarPaths, opt)
+	cacheDindID := ""
+	if opt.CacheState {
+		// The cached state is shared by all the runs of the target, regardless of its build args.
+		sha256CacheDindID := sha256.Sum256([]byte(wdr.c.target.StringCanonical()))
+		cacheDindID = hex.EncodeToString(sha256CacheDindID[:])
+	}
+	logsDir := ""
+	if opt.ComposeLogs != "" && wdr.c.opt.DoSaves {
+		logsDir = path.Join(composeLogsDir, dindID)
+		crOpts.extraRunOpts = append(crOpts.extraRunOpts, pllb.AddMount(
+			composeLogsDir, pllb.Scratch(), llb.HostBind(), llb.SourcePath(composeLogsHostDir)))
+		destPath := opt.ComposeLogs
+		if !path.IsAbs(destPath) {
+			destPath = path.Join(wdr.c.target.LocalPath, destPath)
+		}
+		wdr.c.opt.ComposeLogs.record(ComposeLogsExport{
+			State:    wdr.composeLogsState(logsDir),
+			DestPath: destPath,
+		})
+	}
+	crOpts.shellWrap = makeWithDockerdWrapFun(dindID, cacheDindID, logsDir, tarPaths, opt)
 
 	_, err = wdr.c.internalRun(ctx, crOpts)
 	return err
@@ -265,45 +305,88 @@ func (wdr *withDockerRun) pull(ctx context.Context, opt DockerPullOpt) error {
 			},
 		},
 	}
-	return wdr.solveImage(
+	tarContext, err := wdr.solveImage(
 		ctx, mts, opt.ImageName, opt.ImageName,
 		llb.WithCustomNamef("%sDOCKER LOAD (PULL %s)", wdr.c.imageVertexPrefix(opt.ImageName), opt.ImageName))
+	if err != nil {
+		return err
+	}
+	wdr.tarLoads = append(wdr.tarLoads, tarContext)
+	return nil
 }
 
-func (wdr *withDockerRun) load(ctx context.Context, opt DockerLoadOpt) error {
+// loadAll converts the targets to be loaded one after the other, as the converter is not safe for
+// concurrent use, and then builds their images in parallel.
+func (wdr *withDockerRun) loadAll(ctx context.Context, opts []DockerLoadOpt) error {
+	mtss := make([]*states.MultiTarget, len(opts))
+	preparedOpts := make([]DockerLoadOpt, len(opts))
+	for index, opt := range opts {
+		mts, preparedOpt, err := wdr.prepareLoad(ctx, opt)
+		if err != nil {
+			return err
+		}
+		mtss[index] = mts
+		preparedOpts[index] = preparedOpt
+	}
+	tarContexts := make([]pllb.State, len(opts))
+	eg, ctx := errgroup.WithContext(ctx)
+	for index, opt := range preparedOpts {
+		index, opt := index, opt
+		eg.Go(func() error {
+			tarContext, err := wdr.solveImage(
+				ctx, mtss[index], opt.Target, opt.ImageName,
+				llb.WithCustomNamef(
+					"%sDOCKER LOAD %s %s", wdr.c.imageVertexPrefix(opt.Target), opt.Target, opt.ImageName))
+			if err != nil {
+				return err
+			}
+			tarContexts[index] = tarContext
+			return nil
+		})
+	}
+	err := eg.Wait()
+	if err != nil {
+		return err
+	}
+	// Keep the order of the loads, such that the resulting command is consistent.
+	wdr.tarLoads = append(wdr.tarLoads, tarContexts...)
+	return nil
+}
+
+// prepareLoad converts the target to be loaded. It returns the resulting states, together with the
+// load options, in which the target is normalized and the image name is inferred if needed.
+func (wdr *withDockerRun) prepareLoad(ctx context.Context, opt DockerLoadOpt) (*states.MultiTarget, DockerLoadOpt, error) {
 	depTarget, err := domain.ParseTarget(opt.Target)
 	if err != nil {
-		return errors.Wrapf(err, "parse target %s", opt.Target)
+		return nil, opt, errors.Wrapf(err, "parse target %s", opt.Target)
 	}
-	mts, err := wdr.c.buildTarget(ctx, depTarget.String(), opt.Platform, opt.AllowPrivileged, opt.BuildArgs, false, loadCmd)
+	opt.Target = depTarget.String()
+	mts, err := wdr.c.buildTarget(ctx, opt.Target, opt.Platform, opt.AllowPrivileged, opt.BuildArgs, false, loadCmd)
 	if err != nil {
-		return err
+		return nil, opt, err
 	}
 	if opt.ImageName == "" {
 		// Infer image name from the SAVE IMAGE statement.
 		if len(mts.Final.SaveImages) == 0 || mts.Final.SaveImages[0].DockerTag == "" {
-			return errors.New(
+			return nil, opt, errors.New(
 				"no docker image tag specified in load and it cannot be inferred from the SAVE IMAGE statement")
 		}
 		if len(mts.Final.SaveImages) > 1 {
-			return errors.New(
+			return nil, opt, errors.New(
 				"no docker image tag specified in load and it cannot be inferred from the SAVE IMAGE statement: " +
 					"multiple tags mentioned in SAVE IMAGE")
 		}
 		opt.ImageName = mts.Final.SaveImages[0].DockerTag
 	}
-	return wdr.solveImage(
-		ctx, mts, depTarget.String(), opt.ImageName,
-		llb.WithCustomNamef(
-			"%sDOCKER LOAD %s %s", wdr.c.imageVertexPrefix(depTarget.String()), depTarget.String(), opt.ImageName))
+	return mts, opt, nil
 }
 
-func (wdr *withDockerRun) solveImage(ctx context.Context, mts *states.MultiTarget, opName string, dockerTag string, opts ...llb.RunOption) error {
+func (wdr *withDockerRun) solveImage(ctx context.Context, mts *states.MultiTarget, opName string, dockerTag string, opts ...llb.RunOption) (pllb.State, error) {
 	solveID, err := states.KeyFromHashAndTag(mts.Final, dockerTag)
 	if err != nil {
-		return errors.Wrap(err, "state key func")
+		return pllb.State{}, errors.Wrap(err, "state key func")
 	}
-	tarContext, err := wdr.c.opt.SolveCache.Do(ctx, solveID, func(ctx context.Context, _ states.StateKey) (pllb.State, error) {
+	return wdr.c.opt.SolveCache.Do(ctx, solveID, func(ctx context.Context, _ states.StateKey) (pllb.State, error) {
 		// Use a builder to create docker .tar file, mount it via a local build context,
 		// then docker load it within the current side effects state.
 		outDir, err := ioutil.TempDir(os.TempDir(), "earthly-docker-load")
@@ -339,19 +422,44 @@ func (wdr *withDockerRun) solveImage(ctx context.Context, mts *states.MultiTarge
 		wdr.c.opt.BuildContextProvider.AddDir(string(solveID), outDir)
 		return tarContext, nil
 	})
-	if err != nil {
-		return err
-	}
-	wdr.tarLoads = append(wdr.tarLoads, tarContext)
-	return nil
 }
 
-func makeWithDockerdWrapFun(dindID string, tarPaths []string, opt WithDockerOpt) shellWrapFun {
+// composeLogsState returns a state which holds the compose logs written to the given directory at its
+// root. The logs are moved out of the directory, such that they are only output once.
+func (wdr *withDockerRun) composeLogsState(logsDir string) pllb.State {
+	return pllb.Image(
+		composeLogsImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+		llb.Platform(llbutil.DefaultPlatform()),
+	).Run(
+		llb.Args([]string{
+			"/bin/sh", "-c",
+			fmt.Sprintf("if [ -d %[1]s ]; then cp -R %[1]s/. /out/ && rm -rf %[1]s; fi", logsDir),
+		}),
+		pllb.AddMount(composeLogsDir, pllb.Scratch(), llb.HostBind(), llb.SourcePath(composeLogsHostDir)),
+		llb.IgnoreCache,
+		llb.WithCustomNamef("[internal] WITH DOCKER (output compose logs)"),
+	).AddMount("/out", llbutil.ScratchWithPlatform())
+}
+
+func makeWithDockerdWrapFun(dindID, cacheDindID, logsDir string, tarPaths []string, opt WithDockerOpt) shellWrapFun {
 	dockerRoot := path.Join("/var/earthly/dind", dindID)
 	params := []string{
 		fmt.Sprintf("EARTHLY_DOCKERD_DATA_ROOT=\"%s\"", dockerRoot),
 		fmt.Sprintf("EARTHLY_DOCKER_LOAD_FILES=\"%s\"", strings.Join(tarPaths, " ")),
 	}
+	// The params of optional features are only passed when the feature is used, as they are part of the
+	// command, and therefore of its cache key.
+	if cacheDindID != "" {
+		params = append(params, fmt.Sprintf(
+			"EARTHLY_DOCKERD_CACHE_DATA_ROOT=\"%s\"", path.Join("/var/earthly/dind-cache", cacheDindID)))
+	}
+	if opt.ComposeHealthTimeout > 0 {
+		params = append(params, fmt.Sprintf(
+			"EARTHLY_COMPOSE_HEALTH_TIMEOUT=\"%d\"", int((opt.ComposeHealthTimeout+time.Second-1)/time.Second)))
+	}
+	if logsDir != "" {
+		params = append(params, fmt.Sprintf("EARTHLY_COMPOSE_LOGS=\"%s\"", logsDir))
+	}
 	params = append(params, composeParams(opt)...)
 	return func(args []string, envVars []string, isWithShell, withDebugger, forceDebugger bool) []string {
 		envVars2 := append(params, envVars...)
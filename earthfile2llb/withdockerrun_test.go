@@ -0,0 +1,82 @@
+package earthfile2llb
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/solver/pb"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeWithDockerdWrapFun(t *testing.T) {
+	tarPaths := []string{"/var/earthly/load-0/image.tar", "/var/earthly/load-1/image.tar"}
+
+	wrap := makeWithDockerdWrapFun("abc", "", "", tarPaths, WithDockerOpt{})
+	cmd := wrap([]string{"docker", "ps"}, nil, false, false, false)
+	assert.Len(t, cmd, 3)
+	assert.Contains(t, cmd[2], `EARTHLY_DOCKERD_DATA_ROOT="/var/earthly/dind/abc"`)
+	assert.Contains(t, cmd[2], `EARTHLY_DOCKER_LOAD_FILES="/var/earthly/load-0/image.tar /var/earthly/load-1/image.tar"`)
+	assert.Contains(t, cmd[2], dockerdWrapperPath+" execute docker ps")
+	// The params of features which are not used are left out, such that they do not change the cache key.
+	assert.NotContains(t, cmd[2], "EARTHLY_DOCKERD_CACHE_DATA_ROOT")
+	assert.NotContains(t, cmd[2], "EARTHLY_COMPOSE_HEALTH_TIMEOUT")
+	assert.NotContains(t, cmd[2], "EARTHLY_COMPOSE_LOGS")
+
+	wrap = makeWithDockerdWrapFun("abc", "def", "", tarPaths, WithDockerOpt{CacheState: true})
+	cmd = wrap([]string{"docker", "ps"}, nil, false, false, false)
+	assert.Contains(t, cmd[2], `EARTHLY_DOCKERD_DATA_ROOT="/var/earthly/dind/abc"`)
+	assert.Contains(t, cmd[2], `EARTHLY_DOCKERD_CACHE_DATA_ROOT="/var/earthly/dind-cache/def"`)
+
+	wrap = makeWithDockerdWrapFun("abc", "", "/var/earthly/compose-logs/abc", nil, WithDockerOpt{
+		ComposeFiles:         []string{"docker-compose.yml"},
+		ComposeHealthTimeout: 90500 * time.Millisecond,
+		ComposeLogs:          "logs",
+	})
+	cmd = wrap([]string{"docker", "ps"}, nil, false, false, false)
+	assert.Contains(t, cmd[2], `EARTHLY_START_COMPOSE="true"`)
+	assert.Contains(t, cmd[2], `EARTHLY_COMPOSE_HEALTH_TIMEOUT="91"`)
+	assert.Contains(t, cmd[2], `EARTHLY_COMPOSE_LOGS="/var/earthly/compose-logs/abc"`)
+}
+
+func TestComposeLogsExports(t *testing.T) {
+	var nilExports *ComposeLogsExports
+	nilExports.record(ComposeLogsExport{DestPath: "logs"})
+
+	exports := NewComposeLogsExports()
+	assert.Empty(t, exports.All())
+	exports.record(ComposeLogsExport{DestPath: "a/logs"})
+	exports.record(ComposeLogsExport{DestPath: "b/logs"})
+	all := exports.All()
+	assert.Equal(t, []string{"a/logs", "b/logs"}, []string{all[0].DestPath, all[1].DestPath})
+}
+
+// TestComposeLogsFailedCommand checks that the compose logs are written outside of the filesystem of
+// the command, which is discarded when the command fails, and that they are output from there.
+func TestComposeLogsFailedCommand(t *testing.T) {
+	wdr := &withDockerRun{c: &Converter{}}
+	logsDir := path.Join(composeLogsDir, "abc")
+	def, err := wdr.composeLogsState(logsDir).Marshal(context.Background())
+	assert.NoError(t, err)
+	var script string
+	var hostBound bool
+	for _, dt := range def.Def {
+		var op pb.Op
+		err := op.Unmarshal(dt)
+		assert.NoError(t, err)
+		exec := op.GetExec()
+		if exec == nil {
+			continue
+		}
+		script = exec.Meta.Args[len(exec.Meta.Args)-1]
+		for _, m := range exec.Mounts {
+			if m.Dest == composeLogsDir {
+				hostBound = m.MountType == pb.MountType_HOST_BIND
+			}
+		}
+	}
+	assert.True(t, hostBound, "the compose logs dir is not bound to the buildkit host")
+	assert.Contains(t, script, "cp -R "+logsDir+"/. /out/")
+}
@@ -0,0 +1,422 @@
+package lockfile
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FileName is the name of the lock file, which is placed next to the Earthfile it pins the dependencies of.
+	FileName = "Earthfile.lock"
+
+	version = 1
+)
+
+// Image is a pinned image reference.
+type Image struct {
+	Ref      string `yaml:"ref"`
+	Platform string `yaml:"platform,omitempty"`
+	Digest   string `yaml:"digest"`
+}
+
+// Git is a pinned remote Earthfile reference.
+type Git struct {
+	Repo   string `yaml:"repo"`
+	Ref    string `yaml:"ref,omitempty"`
+	Commit string `yaml:"commit"`
+}
+
+type file struct {
+	Version int     `yaml:"version"`
+	Images  []Image `yaml:"images,omitempty"`
+	Git     []Git   `yaml:"git,omitempty"`
+}
+
+type imageKey struct {
+	ref      string
+	platform string
+}
+
+type gitKey struct {
+	repo string
+	ref  string
+}
+
+// Lock holds the image digests and git commits pinned by a lock file, and records those resolved during a build.
+// A nil *Lock pins nothing and records nothing.
+type Lock struct {
+	mu sync.Mutex
+
+	exists  bool
+	update  bool
+	offline bool
+
+	pinnedImages map[imageKey]string
+	pinnedGit    map[gitKey]string
+
+	// prefetchedImages and prefetchedGit hold the entries of the prefetch index, which are the only
+	// references available in offline mode.
+	prefetchedImages map[imageKey]string
+	prefetchedGit    map[gitKey]string
+
+	images       map[imageKey]string
+	digestImages map[imageKey]string
+	git          map[gitKey]string
+	missing      map[string]bool
+}
+
+// New returns an empty lock, which is not backed by a lock file.
+func New() *Lock {
+	return &Lock{
+		pinnedImages:     make(map[imageKey]string),
+		pinnedGit:        make(map[gitKey]string),
+		prefetchedImages: make(map[imageKey]string),
+		prefetchedGit:    make(map[gitKey]string),
+		images:           make(map[imageKey]string),
+		digestImages:     make(map[imageKey]string),
+		git:              make(map[gitKey]string),
+		missing:          make(map[string]bool),
+	}
+}
+
+// Read reads the lock file at the given path. A missing lock file results in an empty lock.
+// When update is true, the existing entries are not honored, such that every reference is resolved afresh.
+func Read(path string, update bool) (*Lock, error) {
+	l := New()
+	l.update = update
+	dt, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, errors.Wrapf(err, "read %s", path)
+	}
+	l.exists = true
+	if update {
+		return l, nil
+	}
+	var f file
+	err = yaml.Unmarshal(dt, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+	if f.Version > version {
+		return nil, errors.Errorf("%s has version %d, which is not supported by this version of earthly", path, f.Version)
+	}
+	for _, img := range f.Images {
+		l.pinnedImages[imageKey{ref: img.Ref, platform: img.Platform}] = img.Digest
+	}
+	for _, g := range f.Git {
+		l.pinnedGit[gitKey{repo: g.Repo, ref: g.Ref}] = g.Commit
+	}
+	return l, nil
+}
+
+// Exists returns true if the lock file existed when it was read.
+func (l *Lock) Exists() bool {
+	if l == nil {
+		return false
+	}
+	return l.exists
+}
+
+// Updating returns true if the lock file is being updated, in which case its existing entries are not honored.
+func (l *Lock) Updating() bool {
+	if l == nil {
+		return false
+	}
+	return l.update
+}
+
+// SetOffline puts the lock in offline mode, in which only the references recorded in the given
+// prefetch index can be resolved. References which are not pinned are pinned to the prefetched entry.
+func (l *Lock) SetOffline(prefetched *Lock) {
+	prefetched.mu.Lock()
+	defer prefetched.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.offline = true
+	for _, m := range []map[imageKey]string{prefetched.pinnedImages, prefetched.images, prefetched.digestImages} {
+		for k, dgst := range m {
+			l.prefetchedImages[k] = dgst
+			if _, ok := l.pinnedImages[k]; !ok {
+				l.pinnedImages[k] = dgst
+			}
+		}
+	}
+	for _, m := range []map[gitKey]string{prefetched.pinnedGit, prefetched.git} {
+		for k, commit := range m {
+			l.prefetchedGit[k] = commit
+			if _, ok := l.pinnedGit[k]; !ok {
+				l.pinnedGit[k] = commit
+			}
+		}
+	}
+}
+
+// Offline returns true if the lock is in offline mode.
+func (l *Lock) Offline() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.offline
+}
+
+// ImageAvailable returns true unless the lock is in offline mode and the given image reference and
+// platform were not prefetched at the given digest. An empty digest is never available offline.
+func (l *Lock) ImageAvailable(ref, platform, dgst string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.offline {
+		return true
+	}
+	prefetched, ok := l.prefetchedImages[imageKey{ref: ref, platform: platform}]
+	return ok && dgst != "" && prefetched == dgst
+}
+
+// GitAvailable returns true unless the lock is in offline mode and the given git repository and ref
+// were not prefetched at the given commit. An empty commit is never available offline.
+func (l *Lock) GitAvailable(repo, ref, commit string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.offline {
+		return true
+	}
+	prefetched, ok := l.prefetchedGit[gitKey{repo: repo, ref: ref}]
+	return ok && commit != "" && prefetched == commit
+}
+
+// Image returns the digest pinned for the given image reference and platform, if any.
+func (l *Lock) Image(ref, platform string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dgst, ok := l.pinnedImages[imageKey{ref: ref, platform: platform}]
+	return dgst, ok
+}
+
+// RecordImage records the digest which the given image reference and platform resolved to.
+func (l *Lock) RecordImage(ref, platform, dgst string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.images[imageKey{ref: ref, platform: platform}] = dgst
+}
+
+// RecordDigestImage records an image reference which already includes a digest. Such references
+// need no pin, so they are not written to the lock file, but they are prefetched like any other image.
+func (l *Lock) RecordDigestImage(ref, platform, dgst string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.digestImages[imageKey{ref: ref, platform: platform}] = dgst
+}
+
+// GitCommit returns the commit pinned for the given git repository and ref, if any.
+func (l *Lock) GitCommit(repo, ref string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	commit, ok := l.pinnedGit[gitKey{repo: repo, ref: ref}]
+	return commit, ok
+}
+
+// RecordGit records the commit which the given git repository and ref resolved to.
+func (l *Lock) RecordGit(repo, ref, commit string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.git[gitKey{repo: repo, ref: ref}] = commit
+}
+
+// RecordMissingImage records that the given image reference and platform could not be resolved offline.
+func (l *Lock) RecordMissingImage(ref, platform string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.missing["image "+imageDescription(imageKey{ref: ref, platform: platform})] = true
+}
+
+// RecordMissingGit records that the given git repository and ref could not be resolved offline.
+func (l *Lock) RecordMissingGit(repo, ref string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.missing["git "+gitDescription(gitKey{repo: repo, ref: ref})] = true
+}
+
+// Missing returns a description of each reference which could not be resolved offline.
+func (l *Lock) Missing() []string {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := make([]string, 0, len(l.missing))
+	for m := range l.missing {
+		ret = append(ret, m)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Images returns the image references recorded during the build.
+func (l *Lock) Images() []Image {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := make([]Image, 0, len(l.images))
+	for k, dgst := range l.images {
+		ret = append(ret, Image{Ref: k.ref, Platform: k.platform, Digest: dgst})
+	}
+	sortImages(ret)
+	return ret
+}
+
+// PrefetchImages returns the image references recorded during the build, including those which
+// already include a digest.
+func (l *Lock) PrefetchImages() []Image {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := make([]Image, 0, len(l.images)+len(l.digestImages))
+	for _, m := range []map[imageKey]string{l.images, l.digestImages} {
+		for k, dgst := range m {
+			ret = append(ret, Image{Ref: k.ref, Platform: k.platform, Digest: dgst})
+		}
+	}
+	sortImages(ret)
+	return ret
+}
+
+// Unpinned returns a description of each recorded reference which was not pinned by the lock file.
+func (l *Lock) Unpinned() []string {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ret []string
+	for k := range l.images {
+		if _, ok := l.pinnedImages[k]; !ok {
+			ret = append(ret, imageDescription(k))
+		}
+	}
+	for k := range l.git {
+		if _, ok := l.pinnedGit[k]; !ok {
+			ret = append(ret, gitDescription(k))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Write writes the references recorded during the build to the lock file at the given path.
+func (l *Lock) Write(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return write(path, l.images, l.git)
+}
+
+// Merge adds the references recorded during the build to the lock file at the given path,
+// keeping any other entries already in it.
+func (l *Lock) Merge(path string) error {
+	existing, err := Read(path, false)
+	if err != nil {
+		return err
+	}
+	images := existing.pinnedImages
+	git := existing.pinnedGit
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range []map[imageKey]string{l.images, l.digestImages} {
+		for k, dgst := range m {
+			images[k] = dgst
+		}
+	}
+	for k, commit := range l.git {
+		git[k] = commit
+	}
+	return write(path, images, git)
+}
+
+func write(path string, images map[imageKey]string, git map[gitKey]string) error {
+	f := file{Version: version}
+	for k, dgst := range images {
+		f.Images = append(f.Images, Image{Ref: k.ref, Platform: k.platform, Digest: dgst})
+	}
+	for k, commit := range git {
+		f.Git = append(f.Git, Git{Repo: k.repo, Ref: k.ref, Commit: commit})
+	}
+	sortImages(f.Images)
+	sort.Slice(f.Git, func(i, j int) bool {
+		if f.Git[i].Repo != f.Git[j].Repo {
+			return f.Git[i].Repo < f.Git[j].Repo
+		}
+		return f.Git[i].Ref < f.Git[j].Ref
+	})
+	dt, err := yaml.Marshal(f)
+	if err != nil {
+		return errors.Wrap(err, "marshal lock file")
+	}
+	dt = append([]byte("# This file is generated by earthly lock. Do not edit it by hand.\n"), dt...)
+	err = ioutil.WriteFile(path, dt, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", path)
+	}
+	return nil
+}
+
+func sortImages(images []Image) {
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].Ref != images[j].Ref {
+			return images[i].Ref < images[j].Ref
+		}
+		return images[i].Platform < images[j].Platform
+	})
+}
+
+func imageDescription(k imageKey) string {
+	if k.platform == "" {
+		return k.ref
+	}
+	return k.ref + " (" + k.platform + ")"
+}
+
+func gitDescription(k gitKey) string {
+	if k.ref == "" {
+		return k.repo
+	}
+	return k.repo + ":" + k.ref
+}
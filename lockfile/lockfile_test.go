@@ -0,0 +1,172 @@
+package lockfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLockRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lockfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, FileName)
+
+	l, err := Read(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Exists() {
+		t.Error("Exists() = true for a missing lock file")
+	}
+	if _, ok := l.Image("docker.io/library/alpine:3.13", "linux/amd64"); ok {
+		t.Error("empty lock pins an image")
+	}
+	l.RecordImage("docker.io/library/alpine:3.13", "linux/amd64", "sha256:aaaa")
+	l.RecordImage("docker.io/library/alpine:3.13", "linux/arm64", "sha256:bbbb")
+	l.RecordGit("github.com/earthly/hello-world", "main", "0123456789abcdef0123456789abcdef01234567")
+	err = l.Write(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Read(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Exists() {
+		t.Error("Exists() = false for an existing lock file")
+	}
+	if dgst, ok := l.Image("docker.io/library/alpine:3.13", "linux/arm64"); !ok || dgst != "sha256:bbbb" {
+		t.Errorf("Image() = %q, %v; want sha256:bbbb, true", dgst, ok)
+	}
+	if commit, ok := l.GitCommit("github.com/earthly/hello-world", "main"); !ok || commit != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("GitCommit() = %q, %v", commit, ok)
+	}
+	if _, ok := l.GitCommit("github.com/earthly/hello-world", "v1"); ok {
+		t.Error("GitCommit() pins an unrecorded ref")
+	}
+
+	l.RecordImage("docker.io/library/alpine:3.13", "linux/arm64", "sha256:bbbb")
+	l.RecordImage("docker.io/library/golang:1.16", "", "sha256:cccc")
+	want := []string{"docker.io/library/golang:1.16"}
+	if got := l.Unpinned(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unpinned() = %v; want %v", got, want)
+	}
+}
+
+func TestLockUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lockfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, FileName)
+	err = ioutil.WriteFile(path, []byte(`
+version: 1
+images:
+  - ref: docker.io/library/alpine:3.13
+    digest: sha256:aaaa
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Read(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.Image("docker.io/library/alpine:3.13", ""); ok {
+		t.Error("lock being updated pins an image")
+	}
+}
+
+func TestNilLock(t *testing.T) {
+	var l *Lock
+	l.RecordImage("alpine", "", "sha256:aaaa")
+	l.RecordGit("github.com/earthly/earthly", "", "0123")
+	if _, ok := l.Image("alpine", ""); ok {
+		t.Error("nil lock pins an image")
+	}
+	if _, ok := l.GitCommit("github.com/earthly/earthly", ""); ok {
+		t.Error("nil lock pins a commit")
+	}
+	if l.Exists() || len(l.Unpinned()) != 0 {
+		t.Error("nil lock is not empty")
+	}
+}
+
+func TestLockMergeAndSetOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lockfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prefetch.lock")
+
+	first := New()
+	first.RecordImage("docker.io/library/alpine:3.13", "", "sha256:aaaa")
+	err = first.Merge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := New()
+	second.RecordImage("docker.io/library/golang:1.16", "", "sha256:bbbb")
+	second.RecordGit("github.com/earthly/hello-world", "", "0123")
+	second.RecordDigestImage("docker.io/library/node@sha256:dddd", "", "sha256:dddd")
+	err = second.Merge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefetched, err := Read(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New()
+	l.RecordImage("docker.io/library/alpine:3.13", "", "sha256:cccc")
+	if l.Offline() || !l.ImageAvailable("docker.io/library/busybox:1.33", "", "") {
+		t.Error("online lock does not make every image available")
+	}
+	l.SetOffline(prefetched)
+	if !l.Offline() {
+		t.Error("Offline() = false")
+	}
+	if !l.ImageAvailable("docker.io/library/alpine:3.13", "", "sha256:aaaa") {
+		t.Error("prefetched image is not available")
+	}
+	if l.ImageAvailable("docker.io/library/alpine:3.13", "", "sha256:cccc") {
+		t.Error("image pinned to a digest other than the prefetched one is available")
+	}
+	if l.ImageAvailable("docker.io/library/busybox:1.33", "", "") {
+		t.Error("image which was not prefetched is available")
+	}
+	if !l.ImageAvailable("docker.io/library/node@sha256:dddd", "", "sha256:dddd") {
+		t.Error("prefetched digest image is not available")
+	}
+	if !l.GitAvailable("github.com/earthly/hello-world", "", "0123") || l.GitAvailable("github.com/earthly/hello-world", "", "4567") {
+		t.Error("GitAvailable() does not match the prefetch index")
+	}
+	for ref, want := range map[string]string{
+		"docker.io/library/alpine:3.13": "sha256:aaaa",
+		"docker.io/library/golang:1.16": "sha256:bbbb",
+	} {
+		if dgst, ok := l.Image(ref, ""); !ok || dgst != want {
+			t.Errorf("Image(%s) = %q, %v; want %s", ref, dgst, ok, want)
+		}
+	}
+	if commit, ok := l.GitCommit("github.com/earthly/hello-world", ""); !ok || commit != "0123" {
+		t.Errorf("GitCommit() = %q, %v; want 0123", commit, ok)
+	}
+
+	l.RecordMissingImage("docker.io/library/node:16", "linux/amd64")
+	l.RecordMissingGit("github.com/earthly/earthly", "main")
+	want := []string{"git github.com/earthly/earthly:main", "image docker.io/library/node:16 (linux/amd64)"}
+	if got := l.Missing(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Missing() = %v; want %v", got, want)
+	}
+}
@@ -21,6 +21,7 @@ type ContainerFrontend interface {
 	ContainerRemove(ctx context.Context, force bool, namesOrIDs ...string) error
 	ContainerStop(ctx context.Context, timeoutSec uint, namesOrIDs ...string) error
 	ContainerLogs(ctx context.Context, namesOrIDs ...string) (map[string]*ContainerLogs, error)
+	ContainerLogsFollow(ctx context.Context, nameOrID string, stdout, stderr io.Writer) error
 	ContainerRun(ctx context.Context, containers ...ContainerRun) error
 
 	ImageInfo(ctx context.Context, refs ...string) (map[string]*ImageInfo, error)
@@ -50,6 +51,10 @@ func autodetectFrontend(ctx context.Context) (ContainerFrontend, error) {
 		return fe, nil
 	}
 
+	if fe, err := frontendIfAvaliable(ctx, FrontendNerdctlShell); err == nil {
+		return fe, nil
+	}
+
 	return nil, errors.New("failed to autodetect a supported frontend")
 }
 
@@ -60,6 +65,8 @@ func frontendIfAvaliable(ctx context.Context, feType string) (ContainerFrontend,
 		newFe = NewDockerShellFrontend
 	case FrontendPodmanShell:
 		newFe = NewPodmanShellFrontend
+	case FrontendNerdctlShell:
+		newFe = NewNerdctlShellFrontend
 	default:
 		return nil, fmt.Errorf("%s is not a supported container frontend", feType)
 	}
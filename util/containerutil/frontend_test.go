@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/earthly/earthly/util/containerutil"
 	"github.com/hashicorp/go-multierror"
@@ -21,6 +22,7 @@ func TestFrontendNew(t *testing.T) {
 	}{
 		{"docker", containerutil.NewDockerShellFrontend},
 		{"podman", containerutil.NewPodmanShellFrontend},
+		{"nerdctl", containerutil.NewNerdctlShellFrontend},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.binary, func(t *testing.T) {
@@ -42,6 +44,7 @@ func TestFrontendScheme(t *testing.T) {
 	}{
 		{"docker", containerutil.NewDockerShellFrontend, "docker-container"},
 		{"podman", containerutil.NewPodmanShellFrontend, "podman-container"},
+		{"nerdctl", containerutil.NewNerdctlShellFrontend, "tcp"},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.binary, func(t *testing.T) {
@@ -64,6 +67,7 @@ func TestFrontendIsAvaliable(t *testing.T) {
 	}{
 		{"docker", containerutil.NewDockerShellFrontend},
 		{"podman", containerutil.NewPodmanShellFrontend},
+		{"nerdctl", containerutil.NewNerdctlShellFrontend},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.binary, func(t *testing.T) {
@@ -86,6 +90,7 @@ func TestFrontendInformation(t *testing.T) {
 	}{
 		{"docker", containerutil.NewDockerShellFrontend},
 		{"podman", containerutil.NewPodmanShellFrontend},
+		{"nerdctl", containerutil.NewNerdctlShellFrontend},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.binary, func(t *testing.T) {
@@ -247,6 +252,39 @@ func TestFrontendContainerLogs(t *testing.T) {
 	}
 }
 
+func TestFrontendContainerLogsFollow(t *testing.T) {
+	testCases := []struct {
+		binary  string
+		newFunc func(context.Context) (containerutil.ContainerFrontend, error)
+	}{
+		{"docker", containerutil.NewDockerShellFrontend},
+		{"podman", containerutil.NewPodmanShellFrontend},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.binary, func(t *testing.T) {
+			ctx := context.Background()
+			onlyIfBinaryIsInstalled(ctx, t, tC.binary)
+
+			testContainers := []string{"logs-follow-1"}
+			cleanup, err := spawnTestContainers(ctx, tC.binary, testContainers...)
+			assert.NoError(t, err)
+			defer cleanup()
+
+			fe, err := tC.newFunc(ctx)
+			assert.NoError(t, err)
+
+			// The container keeps running, so following its logs only stops once the context is done.
+			ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			defer cancel()
+			var stdout, stderr bytes.Buffer
+			_ = fe.ContainerLogsFollow(ctx, testContainers[0], &stdout, &stderr)
+
+			assert.Empty(t, stdout.String())
+			assert.NotEmpty(t, stderr.String())
+		})
+	}
+}
+
 func TestFrontendContainerRun(t *testing.T) {
 	testCases := []struct {
 		binary  string
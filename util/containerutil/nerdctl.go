@@ -0,0 +1,188 @@
+package containerutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+type nerdctlShellFrontend struct {
+	*shellFrontend
+}
+
+// NewNerdctlShellFrontend constructs a new Frontend using the nerdctl binary installed on the host, which talks to containerd.
+// It also ensures that the binary is functional for our needs and collects compatibility information.
+// The containerd namespace used is controlled by the CONTAINERD_NAMESPACE environment variable, as it is for nerdctl itself.
+func NewNerdctlShellFrontend(ctx context.Context) (ContainerFrontend, error) {
+	fe := &nerdctlShellFrontend{
+		shellFrontend: &shellFrontend{
+			binaryName: "nerdctl",
+		},
+	}
+
+	output, err := fe.commandContextOutput(ctx, "info", "--format={{.SecurityOptions}}")
+	if err != nil {
+		return nil, err
+	}
+	fe.rootless = strings.Contains(output.string(), "rootless")
+
+	return fe, nil
+}
+
+func (nsf *nerdctlShellFrontend) Scheme() string {
+	// There is no buildkit connection helper for nerdctl; buildkitd is always reached over TCP.
+	return "tcp"
+}
+
+func (nsf *nerdctlShellFrontend) Config() *FrontendConfig {
+	return &FrontendConfig{
+		Setting: FrontendNerdctlShell,
+		Binary:  nsf.binaryName,
+		Type:    FrontendTypeShell,
+	}
+}
+
+func (nsf *nerdctlShellFrontend) Information(ctx context.Context) (*FrontendInfo, error) {
+	output, err := nsf.commandContextOutput(ctx, "version", "--format={{json .}}")
+	if err != nil {
+		return nil, err
+	}
+
+	type info struct {
+		Client struct {
+			Version string
+			Os      string
+			Arch    string
+		}
+		Server struct {
+			Components []struct {
+				Name    string
+				Version string
+			}
+		}
+	}
+
+	allInfo := info{}
+	err = json.Unmarshal([]byte(output.string()), &allInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode nerdctl version info")
+	}
+
+	// nerdctl talks to containerd directly, which does not have a versioned API in the docker sense;
+	// report the containerd version as the server version.
+	serverVersion := ""
+	for _, component := range allInfo.Server.Components {
+		if component.Name == "containerd" {
+			serverVersion = component.Version
+		}
+	}
+
+	host, exists := os.LookupEnv("CONTAINERD_ADDRESS")
+	if !exists {
+		host = "/run/containerd/containerd.sock"
+	}
+	namespace, exists := os.LookupEnv("CONTAINERD_NAMESPACE")
+	if !exists {
+		namespace = "default"
+	}
+
+	return &FrontendInfo{
+		ClientVersion:  allInfo.Client.Version,
+		ClientPlatform: fmt.Sprintf("%s/%s", allInfo.Client.Os, allInfo.Client.Arch),
+		ServerVersion:  serverVersion,
+		ServerPlatform: getPlatform(),
+		ServerAddress:  fmt.Sprintf("%s (namespace %s)", host, namespace),
+	}, nil
+}
+
+func (nsf *nerdctlShellFrontend) ContainerInfo(ctx context.Context, namesOrIDs ...string) (map[string]*ContainerInfo, error) {
+	results, err := nsf.shellFrontend.ContainerInfo(ctx, namesOrIDs...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range results {
+		v.Name = strings.TrimPrefix(v.Name, "/")
+
+		// nerdctl names networks after the interface in the container (e.g. unknown-eth0), rather than
+		// after the network itself. Callers expect the default network to be reported as bridge.
+		if _, ok := v.IPs["bridge"]; !ok && len(v.IPs) > 0 {
+			networks := make([]string, 0, len(v.IPs))
+			for k := range v.IPs {
+				networks = append(networks, k)
+			}
+			sort.Strings(networks)
+			v.IPs["bridge"] = v.IPs[networks[0]]
+		}
+	}
+
+	return results, nil
+}
+
+func (nsf *nerdctlShellFrontend) ContainerRun(ctx context.Context, containers ...ContainerRun) error {
+	// Older nerdctl versions do not support --mount, but all support the equivalent --volume syntax.
+	nerdctlContainers := make([]ContainerRun, 0, len(containers))
+	for _, container := range containers {
+		var volumeArgs []string
+		for _, mnt := range container.Mounts {
+			volume := fmt.Sprintf("%s:%s", mnt.Source, mnt.Dest)
+			if mnt.ReadOnly {
+				volume = fmt.Sprintf("%s:ro", volume)
+			}
+			volumeArgs = append(volumeArgs, "--volume", volume)
+		}
+		container.Mounts = nil
+		container.AdditionalArgs = append(volumeArgs, container.AdditionalArgs...)
+		nerdctlContainers = append(nerdctlContainers, container)
+	}
+
+	return nsf.shellFrontend.ContainerRun(ctx, nerdctlContainers...)
+}
+
+func (nsf *nerdctlShellFrontend) VolumeInfo(ctx context.Context, volumeNames ...string) (map[string]*VolumeInfo, error) {
+	results := map[string]*VolumeInfo{}
+	var err error
+	for _, name := range volumeNames {
+		// Pre-initialize all as missing. It will get overwritten when we encounter a real one.
+		results[name] = &VolumeInfo{Name: name}
+
+		// --size is not supported by older versions of nerdctl; fall back to reporting no size.
+		output, cmdErr := nsf.commandContextOutput(ctx, "volume", "inspect", "--size", name)
+		if cmdErr != nil {
+			output, cmdErr = nsf.commandContextOutput(ctx, "volume", "inspect", name)
+		}
+		if cmdErr != nil {
+			// Missing volumes are reported as such, rather than as an error.
+			continue
+		}
+
+		volumeInfos := []struct {
+			Name       string `json:"Name"`
+			Mountpoint string `json:"Mountpoint"`
+			Size       uint64 `json:"Size"`
+		}{}
+		jsonErr := json.Unmarshal([]byte(output.stdout.String()), &volumeInfos)
+		if jsonErr != nil {
+			err = multierror.Append(err, jsonErr)
+			continue
+		}
+
+		for _, volumeInfo := range volumeInfos {
+			if volumeInfo.Name == name {
+				results[name] = &VolumeInfo{
+					Name:       volumeInfo.Name,
+					Size:       volumeInfo.Size,
+					Mountpoint: volumeInfo.Mountpoint,
+				}
+			}
+		}
+	}
+
+	return results, err
+}
@@ -48,7 +48,8 @@ func (sf *shellFrontend) ContainerInfo(ctx context.Context, namesOrIDs ...string
 		ID    string `json:"Id"`
 		Name  string `json:"Name"`
 		State struct {
-			Status string `json:"Status"`
+			Status   string `json:"Status"`
+			ExitCode int    `json:"ExitCode"`
 		} `json:"State"`
 		NetworkSettings struct {
 			Networks map[string]struct {
@@ -70,13 +71,14 @@ func (sf *shellFrontend) ContainerInfo(ctx context.Context, namesOrIDs ...string
 		}
 
 		infos[namesOrIDs[i]] = &ContainerInfo{
-			ID:      container.ID,
-			Name:    container.Name,
-			Status:  container.State.Status,
-			IPs:     ipAddresses,
-			Image:   container.Config.Image,
-			ImageID: container.Image,
-			Labels:  container.Config.Labels,
+			ID:       container.ID,
+			Name:     container.Name,
+			Status:   container.State.Status,
+			ExitCode: container.State.ExitCode,
+			IPs:      ipAddresses,
+			Image:    container.Config.Image,
+			ImageID:  container.Image,
+			Labels:   container.Config.Labels,
 		}
 	}
 
@@ -129,6 +131,20 @@ func (sf *shellFrontend) ContainerLogs(ctx context.Context, namesOrIDs ...string
 	return logs, err
 }
 
+func (sf *shellFrontend) ContainerLogsFollow(ctx context.Context, nameOrID string, stdout, stderr io.Writer) error {
+	// Don't use the wrapper so the logs are streamed as they are produced.
+	cmd := exec.CommandContext(ctx, sf.binaryName, "logs", "--follow", nameOrID)
+	cmd.Env = os.Environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrapf(err, "command failed: %s logs --follow %s", sf.binaryName, nameOrID)
+	}
+	return nil
+}
+
 func (sf *shellFrontend) ContainerRun(ctx context.Context, containers ...ContainerRun) error {
 	var err error
 	for _, container := range containers {
@@ -167,6 +183,10 @@ func (sf *shellFrontend) ContainerRun(ctx context.Context, containers ...Contain
 			}
 
 			port := fmt.Sprintf("%s:%v:%v", prt.IP, hostPort, prt.ContainerPort)
+			if prt.IP == "" {
+				// Bind to all interfaces, as the CLI does by default.
+				port = fmt.Sprintf("%v:%v", hostPort, prt.ContainerPort)
+			}
 
 			if prt.Protocol != "" {
 				// Unspecified protocol means we dont specify a protocol either.
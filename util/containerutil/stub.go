@@ -41,6 +41,9 @@ func (*stubFrontend) ContainerStop(ctx context.Context, timeoutSec uint, namesOr
 func (*stubFrontend) ContainerLogs(ctx context.Context, namesOrIDs ...string) (map[string]*ContainerLogs, error) {
 	return make(map[string]*ContainerLogs), nil
 }
+func (*stubFrontend) ContainerLogsFollow(ctx context.Context, nameOrID string, stdout, stderr io.Writer) error {
+	return nil
+}
 func (*stubFrontend) ContainerRun(ctx context.Context, containers ...ContainerRun) error {
 	return nil
 }
@@ -2,13 +2,14 @@ package containerutil
 
 // ContainerInfo contains things we may care about from inspect output for a given container.
 type ContainerInfo struct {
-	ID      string
-	Name    string
-	Status  string
-	IPs     map[string]string
-	Image   string
-	ImageID string
-	Labels  map[string]string
+	ID       string
+	Name     string
+	Status   string
+	ExitCode int
+	IPs      map[string]string
+	Image    string
+	ImageID  string
+	Labels   map[string]string
 }
 
 const (
@@ -158,6 +159,9 @@ const (
 	// FrontendPodmanShell forces usage of the podman binary for container operations.
 	FrontendPodmanShell = "podman-shell"
 
+	// FrontendNerdctlShell forces usage of the nerdctl binary for container operations, which talks to containerd.
+	FrontendNerdctlShell = "nerdctl-shell"
+
 	// FrontendStub is for when there is no valid provider but attempting to run anyways is desired; like integration tests, or the earthly/earthly image when NO_DOCKER is set.
 	FrontendStub = "stub"
 )
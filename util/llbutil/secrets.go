@@ -69,14 +69,35 @@ func (sp *secretProvider) GetSecret(ctx context.Context, req *secrets.GetSecretR
 	}, nil
 }
 
-// NewSecretProvider returns a new secrets provider
-func NewSecretProvider(client secretsclient.Client, overrides map[string][]byte) session.Attachable {
+// NewSecretProvider returns a new secrets provider. Secrets which are not found in overrides are looked up in
+// the given stores, in order, before falling back to the secrets server.
+func NewSecretProvider(client secretsclient.Client, overrides map[string][]byte, stores ...secrets.SecretStore) session.Attachable {
 	return &secretProvider{
-		store:  mapStore(overrides),
+		store:  chainStore(append([]secrets.SecretStore{mapStore(overrides)}, stores...)),
 		client: client,
 	}
 }
 
+type chainStore []secrets.SecretStore
+
+// GetSecret gets a secret from the first store which has it. If none does, the error of the first store is returned.
+func (cs chainStore) GetSecret(ctx context.Context, id string) ([]byte, error) {
+	var firstErr error
+	for _, s := range cs {
+		dt, err := s.GetSecret(ctx, id)
+		if err == nil {
+			return dt, nil
+		}
+		if !errors.Is(err, secrets.ErrNotFound) {
+			return nil, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
 type mapStore map[string][]byte
 
 // GetSecret gets a secret from the map store